@@ -0,0 +1,232 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/laWiki/comment/config"
+	"github.com/laWiki/comment/database"
+	"github.com/laWiki/comment/model"
+	"github.com/laWiki/comment/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	errAnchorEmpty    = errors.New("anchor without quote or range")
+	errAnchorNotFound = errors.New("anchor not found in the version")
+	errAnchorReply    = errors.New("anchor on a reply")
+)
+
+// checkAnchor completes the anchor of a new inline comment against the content of its version. The passage
+// can be given by its offsets, by its quote, or both; the quote wins when they disagree.
+func checkAnchor(anchor *model.Anchor, versionID string, content string) error {
+	if anchor.Quote == "" && anchor.End <= anchor.Start {
+		return errAnchorEmpty
+	}
+
+	quote, _, _, inRange := utils.Passage(content, anchor.Start, anchor.End)
+	if !inRange || (anchor.Quote != "" && quote != anchor.Quote) {
+		if anchor.Quote == "" {
+			return errAnchorNotFound
+		}
+		match, ok := utils.LocateQuote(content, anchor.Quote, anchor.Prefix, anchor.Suffix, anchor.Start, false)
+		if !ok {
+			return errAnchorNotFound
+		}
+		anchor.Start, anchor.End = match.Start, match.End
+	}
+
+	anchor.Quote, anchor.Prefix, anchor.Suffix, _ = utils.Passage(content, anchor.Start, anchor.End)
+	anchor.VersionID = versionID
+	anchor.Outdated = false
+	anchor.AnchoredAt = time.Now().UTC()
+	return nil
+}
+
+// writeAnchorError answers an inline comment whose anchor was refused by checkAnchor
+func writeAnchorError(w http.ResponseWriter, err error) {
+	switch err {
+	case errAnchorEmpty:
+		config.App.Logger.Error().Msg("Anchor without quote or range")
+		http.Error(w, "The anchor needs a quote or a range", http.StatusBadRequest)
+	case errAnchorNotFound:
+		config.App.Logger.Error().Msg("Anchor not found in the version")
+		http.Error(w, "The anchored passage isn't in the version", http.StatusBadRequest)
+	case errAnchorReply:
+		config.App.Logger.Error().Msg("Anchor on a reply")
+		http.Error(w, "Replies can't be anchored", http.StatusBadRequest)
+	default:
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// versionContent is the part of a version the anchors are matched against, see the version service
+type versionContent struct {
+	ID        string    `json:"id"`
+	EntryID   string    `json:"entry_id"`
+	Content   string    `json:"content"`
+	State     string    `json:"state"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// published reports whether the version is visible to everyone. Versions stored before the review workflow
+// have no state.
+func (v versionContent) published() bool {
+	return v.State == "" || v.State == "published"
+}
+
+// AnchorReport is the result of moving the inline comments of an entry to a version
+type AnchorReport struct {
+	VersionID string   `json:"version_id"`
+	Comments  int      `json:"comments"`
+	Moved     int      `json:"moved"`
+	Fuzzy     int      `json:"fuzzy"`
+	Outdated  []string `json:"outdated"`
+	Skipped   []string `json:"skipped"`
+}
+
+// reanchorComments looks up the quotes of the inline comments of the entry of a version in its content. The
+// anchors found move to the version, taking the text matched as their new quote; the others are flagged as
+// outdated until a version has their quote again. Only anchors on older published versions move: comments
+// on drafts and versions pending review stay with them. Comments on versions that can't be retrieved are
+// skipped, and move with the next version.
+func reanchorComments(ctx context.Context, version versionContent) (AnchorReport, error) {
+	report := AnchorReport{VersionID: version.ID, Outdated: []string{}, Skipped: []string{}}
+
+	filter := bson.M{
+		"entry_id":          version.EntryID,
+		"anchor":            bson.M{"$exists": true},
+		"anchor.version_id": bson.M{"$ne": version.ID},
+	}
+	cursor, err := database.CommentCollection.Find(ctx, filter)
+	if err != nil {
+		return report, err
+	}
+	defer cursor.Close(ctx)
+
+	var comments []model.Comment
+	if err := cursor.All(ctx, &comments); err != nil {
+		return report, err
+	}
+
+	// the anchors of deleted versions move too
+	movable := map[string]bool{}
+	unreachable := map[string]bool{}
+	now := time.Now().UTC()
+	for _, comment := range comments {
+		from := comment.Anchor.VersionID
+		_, checked := movable[from]
+		if !checked && !unreachable[from] {
+			var anchored versionContent
+			err := fetchJSON(fmt.Sprintf("%s/api/versions/%s", config.App.API_GATEWAY_URL, from), &anchored)
+			switch {
+			case errors.Is(err, errNotFound):
+				movable[from] = true
+			case err != nil:
+				config.App.Logger.Warn().Err(err).Str("versionID", from).Msg("Failed to retrieve the version of the anchors, skipping them")
+				unreachable[from] = true
+			default:
+				movable[from] = anchored.published() && !anchored.CreatedAt.After(version.CreatedAt)
+			}
+		}
+		if unreachable[from] {
+			report.Skipped = append(report.Skipped, comment.ID)
+			continue
+		}
+		if !movable[from] {
+			continue
+		}
+
+		report.Comments++
+		objID, err := primitive.ObjectIDFromHex(comment.ID)
+		if err != nil {
+			return report, err
+		}
+
+		anchor := *comment.Anchor
+		match, ok := utils.LocateQuote(version.Content, anchor.Quote, anchor.Prefix, anchor.Suffix, anchor.Start, true)
+		var update bson.M
+		if ok {
+			anchor.Start, anchor.End = match.Start, match.End
+			anchor.Quote, anchor.Prefix, anchor.Suffix, _ = utils.Passage(version.Content, match.Start, match.End)
+			anchor.VersionID = version.ID
+			anchor.Outdated = false
+			anchor.AnchoredAt = now
+			update = bson.M{"$set": bson.M{"anchor": anchor}}
+			report.Moved++
+			if match.Distance > 0 {
+				report.Fuzzy++
+			}
+		} else {
+			update = bson.M{"$set": bson.M{"anchor.outdated": true}}
+			report.Outdated = append(report.Outdated, comment.ID)
+		}
+		if _, err := database.CommentCollection.UpdateOne(ctx, bson.M{"_id": objID}, update); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// ReanchorComments godoc
+// @Summary      Move the inline comments to a version
+// @Description  Looks up the quoted passages of the inline comments of the entry of a version in its content, allowing small changes. The anchors found move to the version; the others are flagged as outdated. Comments on versions that can't be retrieved are skipped and reported. The version service calls it when the current version of an entry changes. Only admins can run it.
+// @Tags         Comments
+// @Produce      application/json
+// @Param        versionID  query     string  true  "Version ID"
+// @Success      200        {object}  AnchorReport
+// @Failure      400        {string}  string  "VersionID is required"
+// @Failure      403        {string}  string  "Forbidden"
+// @Failure      404        {string}  string  "Version not found"
+// @Failure      409        {string}  string  "Version not published"
+// @Failure      500        {string}  string  "Internal server error"
+// @Router       /api/comments/reanchor [post]
+func ReanchorComments(w http.ResponseWriter, r *http.Request) {
+	versionID := r.URL.Query().Get("versionID")
+
+	if r.Header.Get("X-Internal-Auth") != config.App.JWTSecret && r.Header.Get("X-User-Role") != "admin" {
+		config.App.Logger.Warn().Str("userID", r.Header.Get("X-User-ID")).Msg("Re-anchoring without privileges")
+		http.Error(w, "Forbidden: insufficient privileges", http.StatusForbidden)
+		return
+	}
+
+	if versionID == "" {
+		config.App.Logger.Error().Msg("Missing versionID parameter")
+		http.Error(w, "VersionID is required", http.StatusBadRequest)
+		return
+	}
+
+	var version versionContent
+	if err := fetchJSON(fmt.Sprintf("%s/api/versions/%s", config.App.API_GATEWAY_URL, versionID), &version); errors.Is(err, errNotFound) {
+		config.App.Logger.Error().Err(err).Str("versionID", versionID).Msg("Version not found")
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		config.App.Logger.Error().Err(err).Str("versionID", versionID).Msg("Failed to retrieve the version")
+		http.Error(w, "Failed to retrieve the version", http.StatusInternalServerError)
+		return
+	}
+	if !version.published() {
+		config.App.Logger.Warn().Str("versionID", versionID).Str("state", version.State).Msg("Re-anchoring to an unpublished version")
+		http.Error(w, "Only published versions take the anchors of the comments", http.StatusConflict)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	report, err := reanchorComments(ctx, version)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Str("versionID", versionID).Msg("Failed to re-anchor the comments")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	config.App.Logger.Info().Str("versionID", versionID).Int("comments", report.Comments).Int("moved", report.Moved).Int("fuzzy", report.Fuzzy).Int("outdated", len(report.Outdated)).Int("skipped", len(report.Skipped)).Msg("Comments re-anchored")
+	writeJSON(w, report)
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/laWiki/comment/model"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/mailersend/mailersend-go"
 )
@@ -121,7 +122,7 @@ func GetCommentByID(w http.ResponseWriter, r *http.Request) {
 
 // SearchComments godoc
 // @Summary      Search comments
-// @Description  Search for comments using various query parameters. You can search by content, author, createdAt, rating, or versionID. All parameters are optional and can be combined.
+// @Description  Search for comments using various query parameters. You can search by content, author, createdAt, rating, or versionID. All parameters are optional and can be combined. Deleted comments kept for their replies are only listed in the tree and thread views, which list the whole discussion of a version and ignore the other filters.
 // @Tags         Comments
 // @Produce      application/json
 // @Param        content     query     string  false  "Partial content to search for (case-insensitive)"
@@ -129,6 +130,12 @@ func GetCommentByID(w http.ResponseWriter, r *http.Request) {
 // @Param        createdAt   query     string  false  "Creation date (YYYY-MM-DD)"
 // @Param        rating      query     int     false  "Rating to filter by"
 // @Param        versionID   query     string  false  "Version ID to search for"
+// @Param        anchoredTo  query     string  false  "Version the inline comments are anchored to now"
+// @Param        outdated    query     bool    false  "Only the inline comments whose passage is gone (true) or still there (false)"
+// @Param        view        query     string  false  "tree for the replies nested under the comments they answer, thread for a page of the comments in thread order (need versionID)"
+// @Param        threadID    query     string  false  "Comment whose thread the tree or thread view is limited to"
+// @Param        page        query     int     false  "Page of the thread view (default 1)"
+// @Param        limit       query     int     false  "Comments per page of the thread view (default 50)"
 // @Success      200         {array}   model.Comment
 // @Failure      400         {string}  string  "Bad Request"
 // @Failure      500         {string}  string  "Internal Server Error"
@@ -143,8 +150,13 @@ func SearchComments(w http.ResponseWriter, r *http.Request) {
 	versionID := r.URL.Query().Get("versionID")
 	entryID := r.URL.Query().Get("entryID")
 
+	if view := r.URL.Query().Get("view"); view != "" {
+		searchCommentThreads(w, r, view, versionID)
+		return
+	}
+
 	// Build the MongoDB filter dynamically
-	filter := bson.M{}
+	filter := bson.M{"deleted": bson.M{"$ne": true}}
 
 	if content != "" {
 		filter["content"] = bson.M{
@@ -202,6 +214,18 @@ func SearchComments(w http.ResponseWriter, r *http.Request) {
 		filter["entry_id"] = entryID
 	}
 
+	if anchoredTo := r.URL.Query().Get("anchoredTo"); anchoredTo != "" {
+		filter["anchor.version_id"] = anchoredTo
+	}
+
+	switch r.URL.Query().Get("outdated") {
+	case "true":
+		filter["anchor.outdated"] = true
+	case "false":
+		filter["anchor"] = bson.M{"$exists": true}
+		filter["anchor.outdated"] = bson.M{"$ne": true}
+	}
+
 	// Query the database
 	var comments []model.Comment
 
@@ -247,13 +271,14 @@ func SearchComments(w http.ResponseWriter, r *http.Request) {
 
 // PostComment godoc
 // @Summary      Create a new comment
-// @Description  Creates a new comment. Expects a JSON object in the request body.
+// @Description  Creates a new comment. Expects a JSON object in the request body. With parent_id, the comment is a reply in the thread of that comment, on its version, and the author of that comment is notified instead of the editor of the version. With an anchor, the comment is about a passage of the content of the version, given by its quote, its offsets in characters or both.
 // @Tags         Comments
 // @Accept       application/json
 // @Produce      application/json
 // @Param        comment  body      model.Comment  true  "Comment to create"
 // @Success      201      {object}  model.Comment
 // @Failure      400      {string}  string  "Invalid request body"
+// @Failure      409      {string}  string  "Can't reply to a deleted comment"
 // @Failure      500      {string}  string  "Internal server error"
 // @Router       /api/comments/ [post]
 func PostComment(w http.ResponseWriter, r *http.Request) {
@@ -267,6 +292,25 @@ func PostComment(w http.ResponseWriter, r *http.Request) {
 
 	comment.CreatedAt = time.Now().UTC()
 
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// replies go to the version of the comment they answer
+	var parent *model.Comment
+	if comment.ParentID != "" {
+		var err error
+		parent, err = replyParent(ctx, comment)
+		if err != nil {
+			writeReplyError(w, err, comment.ParentID)
+			return
+		}
+		comment.VersionID = parent.VersionID
+	}
+	if comment.Anchor != nil && parent != nil {
+		writeAnchorError(w, errAnchorReply)
+		return
+	}
+
 	// Retrieve EntryID from the provided VersionID by making an HTTP request
 	versionServiceURL := fmt.Sprintf("%s/api/versions/%s", config.App.API_GATEWAY_URL, comment.VersionID)
 	config.App.Logger.Info().Str("url", versionServiceURL).Msg("Fetching Version to get EntryID")
@@ -308,7 +352,9 @@ func PostComment(w http.ResponseWriter, r *http.Request) {
 	var version struct {
 		ID      string `json:"id"`
 		EntryID string `json:"entry_id"`
+		WikiID  string `json:"wiki_id"`
 		Editor  string `json:"editor"`
+		Content string `json:"content"`
 	}
 
 	err = json.NewDecoder(resp.Body).Decode(&version)
@@ -320,25 +366,25 @@ func PostComment(w http.ResponseWriter, r *http.Request) {
 
 	// Set the EntryID in the comment
 	comment.EntryID = version.EntryID
-
-	// Proceed to insert the comment into the database
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	result, err := database.CommentCollection.InsertOne(ctx, comment)
+	if comment.Anchor != nil {
+		if err := checkAnchor(comment.Anchor, comment.VersionID, version.Content); err != nil {
+			writeAnchorError(w, err)
+			return
+		}
+	}
+	comment.Content, err = sanitizeContent(comment.VersionID, comment.Content)
 	if err != nil {
-		config.App.Logger.Error().Err(err).Msg("Database error")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		config.App.Logger.Error().Err(err).Msg("Failed to sanitize the comment")
+		http.Error(w, "Failed to sanitize the comment", http.StatusInternalServerError)
 		return
 	}
 
-	objID, ok := result.InsertedID.(primitive.ObjectID)
-	if !ok {
-		config.App.Logger.Error().Msg("Failed to convert InsertedID to ObjectID")
+	// Proceed to insert the comment into the database
+	if err := insertComment(ctx, &comment, parent); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
-	comment.ID = objID.Hex()
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -350,6 +396,15 @@ func PostComment(w http.ResponseWriter, r *http.Request) {
 
 	config.App.Logger.Info().Interface("comment", comment).Msg("Added new comment")
 
+	// a reply notifies the author of the comment it answers instead of the editor of the version
+	recipient := version.Editor
+	if parent != nil {
+		recipient = parent.Author
+	}
+	if recipient == "" || (parent != nil && recipient == comment.Author) {
+		return
+	}
+
 	// Retrieve the entry title from the entry service with the entryID from the comment
 	entryServiceURL := fmt.Sprintf("%s/api/entries/%s", config.App.API_GATEWAY_URL, comment.EntryID)
 	config.App.Logger.Info().Str("url", entryServiceURL).Msg("Fetching Entry to get title")
@@ -388,8 +443,8 @@ func PostComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Retrieve the editor's email address from the user service with the editor ID from the version
-	userServiceURL := fmt.Sprintf("%s/api/auth/user?id=%s", config.App.API_GATEWAY_URL, version.Editor)
+	// Retrieve the email address of the user to notify from the user service
+	userServiceURL := fmt.Sprintf("%s/api/auth/user?id=%s", config.App.API_GATEWAY_URL, recipient)
 	config.App.Logger.Info().Str("url", userServiceURL).Msg("Fetching User to get email")
 
 	req, err = http.NewRequest("GET", userServiceURL, nil)
@@ -428,7 +483,18 @@ func PostComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if user.EnableMails {
+	if parent != nil && user.EnableMails {
+		// email notification al autor del comentario
+		notifyEmail("Nueva respuesta a tu comentario",
+			"Hola {{ nombre }},\nHan respondido a tu comentario en la entrada \"{{ entrada }}\".",
+			"<p> Hola {{ nombre }},</p><p>Han respondido a tu comentario en la entrada \"{{ entrada }}\".</p>",
+			user.Name,
+			user.Email,
+			entry.Title)
+	} else if parent != nil {
+		// notificacion interna al autor del comentario
+		notifyInterno("Han respondido a tu comentario en la entrada "+entry.Title, recipient)
+	} else if user.EnableMails {
 		// email notification al editor
 		notifyEmail("Nuevo comentario recibido",
 			"Hola {{ nombre }},\nSe ha añadido un nuevo comentario a tu entrada \"{{ entrada }}\".",
@@ -438,7 +504,7 @@ func PostComment(w http.ResponseWriter, r *http.Request) {
 			entry.Title)
 	} else {
 		// notificacion interna al editor
-		notifyInterno("Se ha añadido un nuevo comentario a tu entrada "+entry.Title, version.Editor)
+		notifyInterno("Se ha añadido un nuevo comentario a tu entrada "+entry.Title, recipient)
 	}
 }
 
@@ -453,6 +519,7 @@ func PostComment(w http.ResponseWriter, r *http.Request) {
 // @Success      200      {object}  model.Comment
 // @Failure      400      {string}  string  "Invalid ID or request body"
 // @Failure      404      {string}  string  "Comment not found"
+// @Failure      409      {string}  string  "Comment was deleted"
 // @Failure      500      {string}  string  "Internal server error"
 // @Router       /api/comments/{id} [put]
 func PutComment(w http.ResponseWriter, r *http.Request) {
@@ -478,6 +545,30 @@ func PutComment(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	var current model.Comment
+	err = database.CommentCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&current)
+	if err == mongo.ErrNoDocuments {
+		config.App.Logger.Warn().Str("id", id).Msg("Comment not found for update")
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if current.Deleted {
+		config.App.Logger.Warn().Str("id", id).Msg("Update of a deleted comment")
+		http.Error(w, "Comment was deleted", http.StatusConflict)
+		return
+	}
+	comment.Content, err = sanitizeContent(current.VersionID, comment.Content)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to sanitize the comment")
+		http.Error(w, "Failed to sanitize the comment", http.StatusInternalServerError)
+		return
+	}
+
 	update := bson.M{
 		"$set": bson.M{
 			"content":    comment.Content,
@@ -495,7 +586,7 @@ func PutComment(w http.ResponseWriter, r *http.Request) {
 	}
 	if result.MatchedCount == 0 {
 		config.App.Logger.Warn().Str("id", id).Msg("Comment not found for update")
-		w.WriteHeader(http.StatusNoContent)
+		http.Error(w, "Comment not found", http.StatusNotFound)
 		return
 	}
 
@@ -517,7 +608,7 @@ func PutComment(w http.ResponseWriter, r *http.Request) {
 
 // DeleteComment godoc
 // @Summary      Delete comment by ID
-// @Description  Deletes a comment by its ID.
+// @Description  Deletes a comment by its ID. A comment with replies is kept as a tombstone without content or author.
 // @Tags         Comments
 // @Param        id      query     string  true  "Comment ID"
 // @Success      204     {string}  string  "No Content"
@@ -538,16 +629,23 @@ func DeleteComment(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	result, err := database.CommentCollection.DeleteOne(ctx, bson.M{"_id": objID})
+	var comment model.Comment
+	err = database.CommentCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&comment)
+	if err == mongo.ErrNoDocuments {
+		config.App.Logger.Error().Msg("Comment not found")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
 	if err != nil {
 		config.App.Logger.Error().Err(err).Msg("Database error")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	if result.DeletedCount == 0 {
-		config.App.Logger.Error().Msg("Comment not found")
-		w.WriteHeader(http.StatusNoContent)
+	// comments with replies are left as tombstones so the thread stays whole
+	if err := removeComment(ctx, comment); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/laWiki/comment/config"
+	"github.com/laWiki/comment/database"
+	"github.com/laWiki/comment/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// errNotFound is returned by fetchJSON when the resource doesn't exist
+var errNotFound = errors.New("not found")
+
+// fetchJSON sends an internal GET request through the gateway and decodes the response
+func fetchJSON(url string, target interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Internal-Auth", config.App.JWTSecret)
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("GET %s: %w", url, errNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("GET %s returned %d: %s", url, resp.StatusCode, string(bodyBytes))
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+// sanitizeContent sanitizes the content of a comment through the version service, with the content
+// policy of the wiki of the version it is posted on
+func sanitizeContent(versionID string, content string) (string, error) {
+	payload, err := json.Marshal(map[string]string{"content": content})
+	if err != nil {
+		return "", err
+	}
+
+	url := fmt.Sprintf("%s/api/versions/%s/sanitize", config.App.API_GATEWAY_URL, versionID)
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Internal-Auth", config.App.JWTSecret)
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("POST %s returned %d: %s", url, resp.StatusCode, string(bodyBytes))
+	}
+
+	var sanitized struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&sanitized); err != nil {
+		return "", err
+	}
+	return sanitized.Content, nil
+}
+
+// SanitizationReport is the result of sanitizing the stored comments
+type SanitizationReport struct {
+	DryRun    bool     `json:"dry_run"`
+	Examined  int      `json:"examined"`
+	Sanitized int      `json:"sanitized"`
+	Comments  []string `json:"comments"`
+	Skipped   []string `json:"skipped"`
+}
+
+// SanitizeComments godoc
+// @Summary      Sanitize the stored comments
+// @Description  One-off migration that sanitizes the content of the comments stored before the sanitizer with the content policy of their wiki. With dryRun the comments that would change are reported but nothing is written. Comments that can't be sanitized, e.g. because their version can't be retrieved, are skipped and reported so the migration can be run again. Only admins can run it.
+// @Tags         Comments
+// @Produce      application/json
+// @Param        dryRun  query     bool    false  "Report the result without writing anything"
+// @Success      200     {object}  SanitizationReport
+// @Failure      403     {string}  string  "Forbidden"
+// @Failure      500     {string}  string  "Internal server error"
+// @Router       /api/comments/sanitize [post]
+func SanitizeComments(w http.ResponseWriter, r *http.Request) {
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+
+	if r.Header.Get("X-Internal-Auth") != config.App.JWTSecret && r.Header.Get("X-User-Role") != "admin" {
+		config.App.Logger.Warn().Str("userID", r.Header.Get("X-User-ID")).Msg("Sanitization without privileges")
+		http.Error(w, "Forbidden: insufficient privileges", http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	cursor, err := database.CommentCollection.Find(ctx, bson.M{})
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	report := SanitizationReport{DryRun: dryRun, Comments: []string{}, Skipped: []string{}}
+	for cursor.Next(ctx) {
+		var comment model.Comment
+		if err := cursor.Decode(&comment); err != nil {
+			config.App.Logger.Error().Err(err).Msg("Failed to decode comment")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		report.Examined++
+
+		content, err := sanitizeContent(comment.VersionID, comment.Content)
+		if err != nil {
+			config.App.Logger.Error().Err(err).Str("commentID", comment.ID).Msg("Failed to sanitize the comment, skipping it")
+			report.Skipped = append(report.Skipped, comment.ID)
+			continue
+		}
+		if content == comment.Content {
+			continue
+		}
+
+		report.Sanitized++
+		report.Comments = append(report.Comments, comment.ID)
+		if dryRun {
+			continue
+		}
+
+		objID, err := primitive.ObjectIDFromHex(comment.ID)
+		if err != nil {
+			config.App.Logger.Error().Err(err).Str("commentID", comment.ID).Msg("Invalid ID format")
+			continue
+		}
+		if _, err := database.CommentCollection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"content": content}}); err != nil {
+			config.App.Logger.Error().Err(err).Msg("Database error")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Cursor error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	config.App.Logger.Info().Bool("dryRun", dryRun).Int("examined", report.Examined).Int("sanitized", report.Sanitized).Int("skipped", len(report.Skipped)).Msg("Comments sanitized")
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,301 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/laWiki/comment/config"
+	"github.com/laWiki/comment/database"
+	"github.com/laWiki/comment/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	errParentNotFound = errors.New("parent comment not found")
+	errParentDeleted  = errors.New("parent comment deleted")
+	errParentVersion  = errors.New("parent comment of another version")
+)
+
+// findComment retrieves a comment by its ID
+func findComment(ctx context.Context, id string) (*model.Comment, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	var comment model.Comment
+	if err := database.CommentCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&comment); err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// replyParent returns the comment a reply answers. Replies belong to the version of their parent, and
+// deleted comments can't be answered.
+func replyParent(ctx context.Context, comment model.Comment) (*model.Comment, error) {
+	parent, err := findComment(ctx, comment.ParentID)
+	if err == mongo.ErrNoDocuments {
+		return nil, errParentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if parent.Deleted {
+		return nil, errParentDeleted
+	}
+	if comment.VersionID != "" && comment.VersionID != parent.VersionID {
+		return nil, errParentVersion
+	}
+	return parent, nil
+}
+
+// writeReplyError answers a reply whose parent was refused by replyParent
+func writeReplyError(w http.ResponseWriter, err error, parentID string) {
+	switch err {
+	case errParentNotFound:
+		config.App.Logger.Error().Str("parentID", parentID).Msg("Parent comment not found")
+		http.Error(w, "Parent comment not found", http.StatusBadRequest)
+	case errParentVersion:
+		config.App.Logger.Error().Str("parentID", parentID).Msg("Reply to a comment of another version")
+		http.Error(w, "The parent comment belongs to another version", http.StatusBadRequest)
+	case errParentDeleted:
+		config.App.Logger.Warn().Str("parentID", parentID).Msg("Reply to a deleted comment")
+		http.Error(w, "Can't reply to a deleted comment", http.StatusConflict)
+	default:
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// insertComment stores a new comment, placing it in the thread of its parent if it has one. The ID is
+// chosen beforehand so the path can include it.
+func insertComment(ctx context.Context, comment *model.Comment, parent *model.Comment) error {
+	objID := primitive.NewObjectID()
+	comment.ID = ""
+	comment.Path = objID.Hex()
+	comment.Depth = 0
+	comment.Deleted = false
+	comment.DeletedAt = time.Time{}
+	comment.Replies = nil
+	if parent != nil {
+		comment.Path = parent.ThreadPath() + model.PathSeparator + objID.Hex()
+		comment.Depth = parent.Depth + 1
+	}
+
+	raw, err := bson.Marshal(comment)
+	if err != nil {
+		return err
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+	doc = append(bson.D{{Key: "_id", Value: objID}}, doc...)
+	if _, err := database.CommentCollection.InsertOne(ctx, doc); err != nil {
+		return err
+	}
+	comment.ID = objID.Hex()
+	return nil
+}
+
+// removeComment deletes a comment. A comment with replies becomes a tombstone; one without is deleted, along
+// with the tombstones above it left without replies.
+func removeComment(ctx context.Context, comment model.Comment) error {
+	for {
+		objID, err := primitive.ObjectIDFromHex(comment.ID)
+		if err != nil {
+			return err
+		}
+		replies, err := database.CommentCollection.CountDocuments(ctx, bson.M{"parent_id": comment.ID})
+		if err != nil {
+			return err
+		}
+		if replies > 0 {
+			if comment.Deleted {
+				return nil
+			}
+			update := bson.M{
+				"$set":   bson.M{"deleted": true, "deleted_at": time.Now().UTC(), "content": "", "rating": 0},
+				"$unset": bson.M{"author": ""},
+			}
+			_, err := database.CommentCollection.UpdateOne(ctx, bson.M{"_id": objID}, update)
+			return err
+		}
+
+		if _, err := database.CommentCollection.DeleteOne(ctx, bson.M{"_id": objID}); err != nil {
+			return err
+		}
+		if comment.ParentID == "" {
+			return nil
+		}
+		parent, err := findComment(ctx, comment.ParentID)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !parent.Deleted {
+			return nil
+		}
+		comment = *parent
+	}
+}
+
+// buildCommentTree nests the replies under the comments they answer. Comments whose parent isn't among them
+// are roots. Comments are ordered by creation at every level.
+func buildCommentTree(comments []model.Comment) []*model.Comment {
+	sort.SliceStable(comments, func(i, j int) bool {
+		return comments[i].CreatedAt.Before(comments[j].CreatedAt)
+	})
+
+	nodes := make(map[string]*model.Comment, len(comments))
+	for i := range comments {
+		nodes[comments[i].ID] = &comments[i]
+	}
+	roots := []*model.Comment{}
+	for i := range comments {
+		node := &comments[i]
+		if parent, ok := nodes[node.ParentID]; ok && node.ParentID != "" {
+			parent.Replies = append(parent.Replies, node)
+			continue
+		}
+		roots = append(roots, node)
+	}
+	return roots
+}
+
+// CommentThreadPage is a page of the thread view of the comments of a version
+type CommentThreadPage struct {
+	Comments []model.Comment `json:"comments"`
+	Page     int             `json:"page"`
+	Limit    int             `json:"limit"`
+	Total    int64           `json:"total"`
+}
+
+// searchCommentThreads answers the tree and thread views of SearchComments
+func searchCommentThreads(w http.ResponseWriter, r *http.Request, view string, versionID string) {
+	if versionID == "" {
+		config.App.Logger.Error().Str("view", view).Msg("Missing versionID parameter")
+		http.Error(w, "VersionID is required", http.StatusBadRequest)
+		return
+	}
+
+	filter := bson.M{"version_id": versionID}
+	if threadID := r.URL.Query().Get("threadID"); threadID != "" {
+		filter["$or"] = bson.A{
+			bson.M{"_id": threadObjectID(threadID)},
+			bson.M{"path": bson.M{"$regex": "(^|" + model.PathSeparator + ")" + regexp.QuoteMeta(threadID) + model.PathSeparator}},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	switch view {
+	case "tree":
+		cursor, err := database.CommentCollection.Find(ctx, filter)
+		if err != nil {
+			config.App.Logger.Error().Err(err).Msg("Database error")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		defer cursor.Close(ctx)
+
+		var comments []model.Comment
+		if err := cursor.All(ctx, &comments); err != nil {
+			config.App.Logger.Error().Err(err).Msg("Failed to decode comments")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if len(comments) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, buildCommentTree(comments))
+
+	case "thread":
+		page, limit := 1, 50
+		for _, param := range []struct {
+			name  string
+			value *int
+		}{{"page", &page}, {"limit", &limit}} {
+			value := r.URL.Query().Get(param.name)
+			if value == "" {
+				continue
+			}
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				config.App.Logger.Error().Str(param.name, value).Msg("Invalid pagination")
+				http.Error(w, "Invalid "+param.name, http.StatusBadRequest)
+				return
+			}
+			*param.value = parsed
+		}
+
+		total, err := database.CommentCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			config.App.Logger.Error().Err(err).Msg("Database error")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if total == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		// comments stored before threads are roots, their path is their ID
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: filter}},
+			{{Key: "$addFields", Value: bson.M{"path": bson.M{"$ifNull": bson.A{"$path", bson.M{"$toString": "$_id"}}}}}},
+			{{Key: "$sort", Value: bson.D{{Key: "path", Value: 1}}}},
+			{{Key: "$skip", Value: int64((page - 1) * limit)}},
+			{{Key: "$limit", Value: int64(limit)}},
+		}
+		cursor, err := database.CommentCollection.Aggregate(ctx, pipeline)
+		if err != nil {
+			config.App.Logger.Error().Err(err).Msg("Database error")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		defer cursor.Close(ctx)
+
+		comments := []model.Comment{}
+		if err := cursor.All(ctx, &comments); err != nil {
+			config.App.Logger.Error().Err(err).Msg("Failed to decode comments")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, CommentThreadPage{Comments: comments, Page: page, Limit: limit, Total: total})
+
+	default:
+		config.App.Logger.Error().Str("view", view).Msg("Invalid view")
+		http.Error(w, "Invalid view, use 'tree' or 'thread'", http.StatusBadRequest)
+	}
+}
+
+// threadObjectID returns the ObjectID of the root of a thread, or the ID itself if it isn't one so that it
+// matches nothing
+func threadObjectID(id string) interface{} {
+	if objID, err := primitive.ObjectIDFromHex(id); err == nil {
+		return objID
+	}
+	return id
+}
+
+// writeJSON encodes a response
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
@@ -2,13 +2,52 @@ package model
 
 import "time"
 
+// Comment is a comment on a version, or a reply to another comment. Path is the materialized thread path:
+// the IDs of the root of the thread, the replies down to the comment and the comment itself, joined by
+// PathSeparator, so sorting by it lists a thread in reading order. Comments deleted while they had replies
+// are kept as tombstones without content, so the thread isn't orphaned. Inline comments have an Anchor to the
+// passage of the content they are about.
 type Comment struct {
-	ID        string    `json:"id" bson:"_id,omitempty"`
-	Content   string    `json:"content" bson:"content"`
-	Rating    int       `json:"rating" bson:"rating"`
-	CreatedAt time.Time `json:"created_at" bson:"created_at"`
-	UpdatedAt time.Time `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
-	Author    string    `json:"author" bson:"author"`
-	VersionID string    `json:"version_id" bson:"version_id"`
-	EntryID   string    `json:"entry_id" bson:"entry_id"`
+	ID        string     `json:"id" bson:"_id,omitempty"`
+	Content   string     `json:"content" bson:"content"`
+	Rating    int        `json:"rating" bson:"rating"`
+	CreatedAt time.Time  `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time  `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+	Author    string     `json:"author" bson:"author"`
+	VersionID string     `json:"version_id" bson:"version_id"`
+	EntryID   string     `json:"entry_id" bson:"entry_id"`
+	ParentID  string     `json:"parent_id,omitempty" bson:"parent_id,omitempty"`
+	Path      string     `json:"path,omitempty" bson:"path,omitempty"`
+	Depth     int        `json:"depth,omitempty" bson:"depth,omitempty"`
+	Deleted   bool       `json:"deleted,omitempty" bson:"deleted,omitempty"`
+	DeletedAt time.Time  `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	Anchor    *Anchor    `json:"anchor,omitempty" bson:"anchor,omitempty"`
+	Replies   []*Comment `json:"replies,omitempty" bson:"-"`
+}
+
+// Anchor selects a passage of the content of a version: the quoted text, some text before and after it to
+// tell apart repeated quotes, and its offsets in characters. When a newer version of the entry becomes the
+// current one, the quote is looked up in its content and the anchor moves to it; VersionID is the version the
+// offsets refer to. Outdated anchors weren't found in the current version and still point at the last
+// version they were found in.
+type Anchor struct {
+	Quote      string    `json:"quote" bson:"quote"`
+	Prefix     string    `json:"prefix,omitempty" bson:"prefix,omitempty"`
+	Suffix     string    `json:"suffix,omitempty" bson:"suffix,omitempty"`
+	Start      int       `json:"start" bson:"start"`
+	End        int       `json:"end" bson:"end"`
+	VersionID  string    `json:"version_id,omitempty" bson:"version_id,omitempty"`
+	Outdated   bool      `json:"outdated,omitempty" bson:"outdated,omitempty"`
+	AnchoredAt time.Time `json:"anchored_at,omitempty" bson:"anchored_at,omitempty"`
+}
+
+// PathSeparator separates the IDs of a thread path
+const PathSeparator = "/"
+
+// ThreadPath returns the path of the comment, which comments stored before threads don't have
+func (c Comment) ThreadPath() string {
+	if c.Path != "" {
+		return c.Path
+	}
+	return c.ID
 }
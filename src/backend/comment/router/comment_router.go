@@ -16,6 +16,8 @@ func NewRouter() http.Handler {
 		r.Post("/", handler.PostComment)
 		r.Get("/search", handler.SearchComments)
 		r.Delete("/version", handler.DeleteCommentsByVersionID)
+		r.Post("/sanitize", handler.SanitizeComments)
+		r.Post("/reanchor", handler.ReanchorComments)
 
 		r.Route("/{id}", func(r chi.Router) {
 			r.Get("/", handler.GetCommentByID)
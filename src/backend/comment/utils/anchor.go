@@ -0,0 +1,147 @@
+package utils
+
+import "slices"
+
+// AnchorContext is the number of characters kept before and after the quote of an anchor to tell apart the
+// places the quote appears in
+const AnchorContext = 32
+
+// maxFuzzyQuote is the length of the longest quote matched approximately. Longer quotes have to appear as they
+// were, so matching stays cheap in long contents.
+const maxFuzzyQuote = 512
+
+// AnchorMatch is the place a quote was found in a content, in characters. Distance is the number of
+// characters inserted, deleted or replaced between the quote and the text found, 0 for an exact match.
+type AnchorMatch struct {
+	Start    int
+	End      int
+	Distance int
+}
+
+// Passage returns the text of a content between two offsets in characters, with the characters of
+// context around it. ok is false when the range isn't in the content.
+func Passage(content string, start int, end int) (quote string, prefix string, suffix string, ok bool) {
+	text := []rune(content)
+	if start < 0 || end <= start || end > len(text) {
+		return "", "", "", false
+	}
+	return string(text[start:end]), string(text[max(0, start-AnchorContext):start]), string(text[end:min(len(text), end+AnchorContext)]), true
+}
+
+// LocateQuote finds a quote in a content. Among the places it appears exactly, the one whose surroundings
+// best match the prefix and suffix wins, then the one closest to hint, the offset where it was last. When it
+// doesn't appear, the closest approximate match is taken if fuzzy and it differs from the quote in at most a
+// quarter of its characters.
+func LocateQuote(content string, quote string, prefix string, suffix string, hint int, fuzzy bool) (AnchorMatch, bool) {
+	text, q := []rune(content), []rune(quote)
+	if len(q) == 0 || len(q) > len(text) && !fuzzy {
+		return AnchorMatch{}, false
+	}
+
+	best, found := AnchorMatch{}, false
+	bestScore := -1
+	for _, start := range occurrences(text, q) {
+		score := commonSuffix([]rune(prefix), text[:start]) + commonPrefix([]rune(suffix), text[start+len(q):])
+		if !found || score > bestScore || score == bestScore && distance(start, hint) < distance(best.Start, hint) {
+			best, bestScore, found = AnchorMatch{Start: start, End: start + len(q)}, score, true
+		}
+	}
+	if found || !fuzzy || len(q) > maxFuzzyQuote {
+		return best, found
+	}
+	return fuzzyMatch(text, q, len(q)/4, hint)
+}
+
+// occurrences returns the offsets in characters where a quote appears in a text
+func occurrences(text []rune, quote []rune) []int {
+	var offsets []int
+	for i := 0; i+len(quote) <= len(text); i++ {
+		if text[i] == quote[0] && slices.Equal(text[i:i+len(quote)], quote) {
+			offsets = append(offsets, i)
+		}
+	}
+	return offsets
+}
+
+// fuzzyMatch finds the part of a text closest to a quote by edit distance, with at most maxErrors
+// differences. Ties go to the match closest to hint, then to the one closest to the length of the quote.
+func fuzzyMatch(text []rune, quote []rune, maxErrors int, hint int) (AnchorMatch, bool) {
+	if maxErrors < 1 {
+		return AnchorMatch{}, false
+	}
+
+	// cost[i] is the distance between quote[:i] and the best part of the text ending at the current
+	// offset, and start[i] the offset that part begins at. Matches can begin anywhere in the text.
+	cost := make([]int, len(quote)+1)
+	start := make([]int, len(quote)+1)
+	next := make([]int, len(quote)+1)
+	nextStart := make([]int, len(quote)+1)
+	for i := range cost {
+		cost[i] = i
+	}
+
+	best, found := AnchorMatch{}, false
+	for j := 1; j <= len(text); j++ {
+		next[0], nextStart[0] = 0, j
+		for i := 1; i <= len(quote); i++ {
+			c, s := cost[i-1], start[i-1]
+			if quote[i-1] != text[j-1] {
+				c++
+			}
+			if cost[i]+1 < c {
+				c, s = cost[i]+1, start[i]
+			}
+			if next[i-1]+1 < c {
+				c, s = next[i-1]+1, nextStart[i-1]
+			}
+			next[i], nextStart[i] = c, s
+		}
+		cost, next = next, cost
+		start, nextStart = nextStart, start
+
+		d := cost[len(quote)]
+		if d > maxErrors {
+			continue
+		}
+		match := AnchorMatch{Start: start[len(quote)], End: j, Distance: d}
+		if !found || d < best.Distance || d == best.Distance && closer(match, best, hint, len(quote)) {
+			best, found = match, true
+		}
+	}
+	return best, found
+}
+
+// closer reports whether a match is closer than another to hint or, starting at the same offset, to the
+// length of the quote
+func closer(a AnchorMatch, b AnchorMatch, hint int, length int) bool {
+	if da, db := distance(a.Start, hint), distance(b.Start, hint); da != db {
+		return da < db
+	}
+	return distance(a.End-a.Start, length) < distance(b.End-b.Start, length)
+}
+
+// commonPrefix is the number of characters a and b start with in common
+func commonPrefix(a []rune, b []rune) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// commonSuffix is the number of characters a and b end with in common
+func commonSuffix(a []rune, b []rune) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+		n++
+	}
+	return n
+}
+
+// distance is the number of characters between two offsets
+func distance(a int, b int) int {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPassage(t *testing.T) {
+	long := strings.Repeat("a", 40) + "X" + strings.Repeat("b", 40)
+
+	tests := []struct {
+		name       string
+		content    string
+		start, end int
+		quote      string
+		prefix     string
+		suffix     string
+		ok         bool
+	}{
+		{"middle", "Año tras año", 4, 8, "tras", "Año ", " año", true},
+		{"start", "Año tras año", 0, 3, "Año", "", " tras año", true},
+		{"end", "Año tras año", 9, 12, "año", "Año tras ", "", true},
+		{"context is cut", long, 40, 41, "X", strings.Repeat("a", AnchorContext), strings.Repeat("b", AnchorContext), true},
+		{"empty range", "Año tras año", 4, 4, "", "", "", false},
+		{"negative start", "Año tras año", -1, 3, "", "", "", false},
+		{"past the end", "Año tras año", 9, 13, "", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quote, prefix, suffix, ok := Passage(tt.content, tt.start, tt.end)
+			if ok != tt.ok || quote != tt.quote || prefix != tt.prefix || suffix != tt.suffix {
+				t.Errorf("Passage() = %q, %q, %q, %v, want %q, %q, %q, %v", quote, prefix, suffix, ok, tt.quote, tt.prefix, tt.suffix, tt.ok)
+			}
+		})
+	}
+}
+
+func TestLocateQuote(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		quote   string
+		prefix  string
+		suffix  string
+		hint    int
+		fuzzy   bool
+		want    AnchorMatch
+		found   bool
+	}{
+		{name: "exact", content: "El gato come pescado.", quote: "gato", want: AnchorMatch{Start: 3, End: 7}, found: true},
+		{name: "offsets in characters", content: "Año tras año", quote: "año", want: AnchorMatch{Start: 9, End: 12}, found: true},
+		{name: "prefix picks the occurrence", content: "uno dos uno tres", quote: "uno", prefix: "dos ", want: AnchorMatch{Start: 8, End: 11}, found: true},
+		{name: "suffix picks the occurrence", content: "uno dos uno tres", quote: "uno", suffix: " tres", want: AnchorMatch{Start: 8, End: 11}, found: true},
+		{name: "context beats hint", content: "uno dos uno tres", quote: "uno", suffix: " dos", hint: 8, want: AnchorMatch{Start: 0, End: 3}, found: true},
+		{name: "hint breaks ties", content: "uno dos uno tres", quote: "uno", hint: 10, want: AnchorMatch{Start: 8, End: 11}, found: true},
+		{name: "missing", content: "El gato come pescado.", quote: "perro", fuzzy: false},
+		{name: "empty quote", content: "El gato come pescado.", quote: "", fuzzy: true},
+		{name: "longer than the content", content: "gato", quote: "gato negro", fuzzy: false},
+		{
+			name:    "fuzzy after an edit",
+			content: "Había una vez un pero negro en el parque.",
+			quote:   "un perro negro",
+			fuzzy:   true,
+			want:    AnchorMatch{Start: 14, End: 27, Distance: 1},
+			found:   true,
+		},
+		{name: "fuzzy too different", content: "Había una vez un gato blanco.", quote: "un perro negro", fuzzy: true},
+		{name: "fuzzy off", content: "Había una vez un pero negro en el parque.", quote: "un perro negro", fuzzy: false},
+		{
+			name:    "long quotes must be exact",
+			content: strings.Repeat("x", maxFuzzyQuote) + "y",
+			quote:   strings.Repeat("x", maxFuzzyQuote) + "z",
+			fuzzy:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := LocateQuote(tt.content, tt.quote, tt.prefix, tt.suffix, tt.hint, tt.fuzzy)
+			if found != tt.found || got != tt.want {
+				t.Errorf("LocateQuote() = %+v, %v, want %+v, %v", got, found, tt.want, tt.found)
+			}
+		})
+	}
+}
+
+func TestFuzzyMatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		quote     string
+		maxErrors int
+		hint      int
+		want      AnchorMatch
+		found     bool
+	}{
+		{name: "exact", text: "el gato", quote: "gato", maxErrors: 1, want: AnchorMatch{Start: 3, End: 7}, found: true},
+		{name: "substitution", text: "el gata", quote: "gato", maxErrors: 1, want: AnchorMatch{Start: 3, End: 7, Distance: 1}, found: true},
+		{name: "extra letters", text: "el gatito", quote: "gato", maxErrors: 2, want: AnchorMatch{Start: 3, End: 7, Distance: 1}, found: true},
+		{name: "same start, closest length", text: "el gatos", quote: "gato", maxErrors: 1, want: AnchorMatch{Start: 3, End: 7}, found: true},
+		{name: "too many errors", text: "el perro", quote: "gato", maxErrors: 1},
+		{name: "no errors allowed", text: "el gato", quote: "gato", maxErrors: 0},
+		{name: "closest distance wins", text: "gatas y gatos", quote: "gatos", maxErrors: 1, want: AnchorMatch{Start: 8, End: 13}, found: true},
+		{name: "tie near the start", text: "gatos y gatas", quote: "gatis", maxErrors: 1, hint: 0, want: AnchorMatch{Start: 0, End: 5, Distance: 1}, found: true},
+		{name: "tie near the hint", text: "gatos y gatas", quote: "gatis", maxErrors: 1, hint: 10, want: AnchorMatch{Start: 8, End: 13, Distance: 1}, found: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := fuzzyMatch([]rune(tt.text), []rune(tt.quote), tt.maxErrors, tt.hint)
+			if found != tt.found || got != tt.want {
+				t.Errorf("fuzzyMatch() = %+v, %v, want %+v, %v", got, found, tt.want, tt.found)
+			}
+		})
+	}
+}
@@ -26,8 +26,10 @@ type GlobalConfig struct {
 
 // EntryConfig holds the configuration specific to the entry service
 type EntryConfig struct {
-	Port             int    `toml:"PORT"`
-	DBCollectionName string `toml:"DB_COLLECTION_NAME"`
+	Port                   int    `toml:"PORT"`
+	DBCollectionName       string `toml:"DB_COLLECTION_NAME"`
+	EditLockMinutes        int    `toml:"EDIT_LOCK_MINUTES"`
+	CategoryCollectionName string `toml:"CATEGORY_COLLECTION_NAME"`
 }
 
 // Config represents the structure of the config.toml file
@@ -36,19 +38,21 @@ type Config struct {
 	Global GlobalConfig `toml:"global"`
 }
 type AppConfig struct {
-	Logger           *zerolog.Logger
-	Port             string
-	PrettyLogs       bool
-	Debug            bool
-	MongoDBURI       string
-	DBCollectionName string
-	DBName           string
-	API_GATEWAY_URL  string
-	DeepLKey         string
-	JWTSecret        string
-	MailSenderAPIKey string
-	MailSenderDomain string
-	MailSenderName   string
+	Logger             *zerolog.Logger
+	Port               string
+	PrettyLogs         bool
+	Debug              bool
+	MongoDBURI         string
+	DBCollectionName   string
+	CategoryCollection string
+	DBName             string
+	API_GATEWAY_URL    string
+	DeepLKey           string
+	JWTSecret          string
+	MailSenderAPIKey   string
+	MailSenderDomain   string
+	MailSenderName     string
+	EditLockTTL        time.Duration
 }
 
 // App holds app configuration
@@ -115,6 +119,22 @@ func (cfg *AppConfig) LoadConfig(configPath string) {
 		log.Warn().Msg("DBCOLLECTIONNAME not set in config file. Using default 'wiki'.")
 	}
 
+	// CATEGORY_COLLECTION_NAME with default value
+	if config.Entry.CategoryCollectionName != "" {
+		cfg.CategoryCollection = config.Entry.CategoryCollectionName
+	} else {
+		cfg.CategoryCollection = "categorias" // Default to "categorias"
+		log.Warn().Msg("CATEGORY_COLLECTION_NAME not set in config file. Using default 'categorias'.")
+	}
+
+	// EDIT_LOCK_MINUTES with default value
+	if config.Entry.EditLockMinutes > 0 {
+		cfg.EditLockTTL = time.Duration(config.Entry.EditLockMinutes) * time.Minute
+	} else {
+		cfg.EditLockTTL = 10 * time.Minute // Default to ten minutes
+		log.Warn().Msg("EDIT_LOCK_MINUTES not set in config file. Using default '10'.")
+	}
+
 	// MONGODB_URI is required
 	if config.Global.MongoDBURI != "" {
 		cfg.MongoDBURI = config.Global.MongoDBURI
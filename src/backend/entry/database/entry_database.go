@@ -10,8 +10,9 @@ import (
 )
 
 var (
-	Client          *mongo.Client
-	EntryCollection *mongo.Collection
+	Client             *mongo.Client
+	EntryCollection    *mongo.Collection
+	CategoryCollection *mongo.Collection
 )
 
 func Connect() {
@@ -32,5 +33,6 @@ func Connect() {
 
 	Client = client
 	EntryCollection = client.Database(config.App.DBName).Collection(config.App.DBCollectionName)
+	CategoryCollection = client.Database(config.App.DBName).Collection(config.App.CategoryCollection)
 	config.App.Logger.Info().Msg("Connected to mongoDB")
 }
@@ -0,0 +1,19 @@
+package dto
+
+import "time"
+
+// BlameDTO represents the attribution of the current version of an entry received from the Version service.
+type BlameDTO struct {
+	EntryID   string          `json:"entry_id"`
+	VersionID string          `json:"version_id"`
+	Blocks    []BlameBlockDTO `json:"blocks"`
+}
+
+// BlameBlockDTO is a block of the current content together with the version that introduced it.
+type BlameBlockDTO struct {
+	Content   string    `json:"content"`
+	VersionID string    `json:"version_id"`
+	Editor    string    `json:"editor"`
+	Summary   string    `json:"summary,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
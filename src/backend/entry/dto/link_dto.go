@@ -0,0 +1,10 @@
+package dto
+
+// LinkDTO represents a wiki link between entries received from the Version service.
+type LinkDTO struct {
+	WikiID        string `json:"wiki_id"`
+	SourceEntryID string `json:"source_entry_id"`
+	TargetTitle   string `json:"target_title"`
+	TargetEntryID string `json:"target_entry_id"`
+	Count         int    `json:"count"`
+}
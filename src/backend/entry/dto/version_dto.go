@@ -1,11 +1,16 @@
 package dto
 
+import "time"
+
 // VersionDTO represents the data structure received from the Version service.
 type VersionDTO struct {
-    ID          string `json:"id"`
-    Content     string `json:"content"`
-    EntryID     string `json:"entryId"`
-    CreatedAt   string `json:"createdAt"`
-    UpdatedAt   string `json:"updatedAt"`
-    // Add other necessary fields if required
-}
\ No newline at end of file
+	ID        string    `json:"id"`
+	Content   string    `json:"content"`
+	EntryID   string    `json:"entry_id"`
+	Editor    string    `json:"editor"`
+	Summary   string    `json:"summary,omitempty"`
+	Minor     bool      `json:"minor"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+	// Add other necessary fields if required
+}
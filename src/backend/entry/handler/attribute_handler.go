@@ -0,0 +1,272 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/laWiki/entry/config"
+	"github.com/laWiki/entry/database"
+	"github.com/laWiki/entry/model"
+	"github.com/laWiki/entry/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// errInvalidAttributes is returned when the attributes of an entry don't match the schema of its wiki
+var errInvalidAttributes = errors.New("invalid attributes")
+
+// Operators of the attribute conditions of a search
+var attributeOperators = map[string]string{
+	"=": "$eq", "!=": "$ne", ">": "$gt", ">=": "$gte", "<": "$lt", "<=": "$lte",
+}
+
+// entryPath is the frontend route the entry attributes link to
+const entryPath = "/entrada/%s"
+
+// fetchSchema retrieves the infobox schema of a wiki from the wiki service
+func fetchSchema(wikiID string) ([]utils.AttributeDef, error) {
+	wikiServiceURL := fmt.Sprintf("%s/api/wikis/%s", config.App.API_GATEWAY_URL, url.PathEscape(wikiID))
+	var wiki struct {
+		InfoboxSchema []utils.AttributeDef `json:"infobox_schema"`
+	}
+	if err := fetchJSON(wikiServiceURL, &wiki); err != nil {
+		return nil, err
+	}
+	return wiki.InfoboxSchema, nil
+}
+
+// fetchJSON sends an internal GET request through the gateway and decodes the JSON response
+func fetchJSON(url string, out interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Internal-Auth", config.App.JWTSecret)
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("GET %s returned %d: %s", url, resp.StatusCode, string(bodyBytes))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// checkAttributes validates the attributes of an entry against the schema of its wiki, returning them as they
+// are stored. Entry attributes must lead to another entry of the same wiki.
+func checkAttributes(ctx context.Context, entry model.Entry) (map[string]interface{}, error) {
+	schema, err := fetchSchema(entry.WikiID)
+	if err != nil {
+		return nil, err
+	}
+	attributes, err := utils.ParseAttributes(schema, entry.Attributes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidAttributes, err)
+	}
+
+	for _, def := range schema {
+		value, ok := attributes[def.Name].(string)
+		if def.Type != utils.AttributeEntry || !ok {
+			continue
+		}
+		if value == entry.ID {
+			return nil, fmt.Errorf("%w: attribute %q links the entry to itself", errInvalidAttributes, def.Name)
+		}
+		objID, _ := primitive.ObjectIDFromHex(value)
+		count, err := database.EntryCollection.CountDocuments(ctx, bson.M{"_id": objID, "wiki_id": entry.WikiID})
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, fmt.Errorf("%w: attribute %q leads to a missing entry", errInvalidAttributes, def.Name)
+		}
+	}
+	if len(attributes) == 0 {
+		return nil, nil
+	}
+	return attributes, nil
+}
+
+// writeAttributesError writes the response to a failed checkAttributes
+func writeAttributesError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errInvalidAttributes) {
+		config.App.Logger.Error().Err(err).Msg("Invalid attributes")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	config.App.Logger.Error().Err(err).Msg("Failed to validate the attributes")
+	http.Error(w, "Failed to validate the attributes", http.StatusInternalServerError)
+}
+
+// attributeFilter turns the attribute conditions of a search into a MongoDB filter. With a wiki, the values are
+// typed by its schema and only its attributes can be searched; across wikis numbers are told apart from text.
+func attributeFilter(conditions []utils.AttributeCondition, wikiID string) (bson.M, error) {
+	filter := bson.M{}
+	if len(conditions) == 0 {
+		return filter, nil
+	}
+
+	var defs map[string]utils.AttributeDef
+	if wikiID != "" {
+		schema, err := fetchSchema(wikiID)
+		if err != nil {
+			return nil, err
+		}
+		defs = map[string]utils.AttributeDef{}
+		for _, def := range schema {
+			defs[def.Name] = def
+		}
+	}
+
+	for _, condition := range conditions {
+		var value interface{}
+		if defs != nil {
+			def, ok := defs[condition.Name]
+			if !ok {
+				return nil, fmt.Errorf("%w: unknown attribute %q", errInvalidAttributes, condition.Name)
+			}
+			typed, err := utils.ParseAttributeValue(def, condition.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", errInvalidAttributes, err)
+			}
+			value = typed
+		} else {
+			value = utils.GuessAttributeValue(condition.Value)
+		}
+
+		field := "attributes." + condition.Name
+		conds, _ := filter[field].(bson.M)
+		if conds == nil {
+			conds = bson.M{}
+		}
+		conds[attributeOperators[condition.Operator]] = value
+		filter[field] = conds
+	}
+	return filter, nil
+}
+
+// Infobox is the box of attributes of an entry, in the order of the schema of its wiki
+type Infobox struct {
+	EntryID string       `json:"entry_id"`
+	Title   string       `json:"title"`
+	Rows    []InfoboxRow `json:"rows"`
+	HTML    string       `json:"html"`
+}
+
+// InfoboxRow is an attribute of an infobox. Text is the value as shown, and Link where it leads, if anywhere.
+type InfoboxRow struct {
+	Name  string      `json:"name"`
+	Label string      `json:"label"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+	Text  string      `json:"text"`
+	Link  string      `json:"link,omitempty"`
+}
+
+// GetEntryInfobox godoc
+// @Summary      Get the infobox of an entry
+// @Description  Returns the attributes of an entry labelled and ordered by the schema of its wiki, with the HTML of the infobox. Entry attributes show the title of the entry they lead to and media attributes the image.
+// @Tags         Entries
+// @Produce      application/json
+// @Param        id    path      string  true  "Entry ID"
+// @Success      200   {object}  Infobox
+// @Success      204   {string}  string  "No Content"
+// @Failure      400   {string}  string  "Invalid ID"
+// @Failure      404   {string}  string  "Entry not found"
+// @Failure      500   {string}  string  "Internal server error"
+// @Router       /api/entries/{id}/infobox [get]
+func GetEntryInfobox(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, entry, ok := loadEntry(w, r, ctx)
+	if !ok {
+		return
+	}
+	if len(entry.Attributes) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	schema, err := fetchSchema(entry.WikiID)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Str("wikiID", entry.WikiID).Msg("Failed to retrieve the infobox schema")
+		http.Error(w, "Failed to retrieve the infobox schema", http.StatusInternalServerError)
+		return
+	}
+
+	infobox := Infobox{EntryID: entry.ID, Title: entry.Title, Rows: []InfoboxRow{}}
+	for _, def := range schema {
+		value, ok := entry.Attributes[def.Name]
+		if !ok {
+			continue
+		}
+		row := InfoboxRow{Name: def.Name, Label: def.Label, Type: def.Type, Value: value, Text: fmt.Sprint(value)}
+		if n, ok := value.(float64); ok {
+			row.Text = strconv.FormatFloat(n, 'f', -1, 64)
+		}
+		if row.Label == "" {
+			row.Label = def.Name
+		}
+		switch def.Type {
+		case utils.AttributeEntry:
+			row.Link = fmt.Sprintf(entryPath, url.PathEscape(row.Text))
+			var target model.Entry
+			if objID, err := primitive.ObjectIDFromHex(row.Text); err == nil &&
+				database.EntryCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&target) == nil {
+				row.Text = target.Title
+			}
+		case utils.AttributeMedia:
+			var media struct {
+				UploadUrl string `json:"uploadUrl"`
+			}
+			mediaServiceURL := fmt.Sprintf("%s/api/media/%s", config.App.API_GATEWAY_URL, url.PathEscape(row.Text))
+			if err := fetchJSON(mediaServiceURL, &media); err != nil {
+				config.App.Logger.Warn().Err(err).Str("mediaID", row.Text).Msg("Failed to retrieve the media of an attribute")
+			}
+			row.Link = media.UploadUrl
+		}
+		infobox.Rows = append(infobox.Rows, row)
+	}
+	infobox.HTML = infoboxHTML(infobox)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(infobox); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// infoboxHTML renders an infobox as a table
+func infoboxHTML(infobox Infobox) string {
+	var b strings.Builder
+	b.WriteString(`<table class="infobox">`)
+	fmt.Fprintf(&b, `<caption>%s</caption>`, html.EscapeString(infobox.Title))
+	for _, row := range infobox.Rows {
+		value := html.EscapeString(row.Text)
+		switch {
+		case row.Type == utils.AttributeMedia && row.Link != "":
+			value = fmt.Sprintf(`<img src="%s" alt="%s">`, html.EscapeString(row.Link), html.EscapeString(row.Label))
+		case row.Link != "":
+			value = fmt.Sprintf(`<a href="%s" class="wiki-link">%s</a>`, html.EscapeString(row.Link), value)
+		}
+		fmt.Fprintf(&b, `<tr><th>%s</th><td>%s</td></tr>`, html.EscapeString(row.Label), value)
+	}
+	b.WriteString(`</table>`)
+	return b.String()
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/laWiki/entry/config"
+	"github.com/laWiki/entry/database"
+	"github.com/laWiki/entry/dto"
+	"github.com/laWiki/entry/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fetchBlame retrieves the blame of an entry from the version service, nil if the entry has no versions
+func fetchBlame(entryID string) (*dto.BlameDTO, error) {
+	blameURL := fmt.Sprintf("%s/api/versions/blame?entryID=%s", config.App.API_GATEWAY_URL, url.QueryEscape(entryID))
+	req, err := http.NewRequest("GET", blameURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Internal-Auth", config.App.JWTSecret)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return nil, nil
+	case http.StatusOK:
+		var blame dto.BlameDTO
+		if err := json.NewDecoder(resp.Body).Decode(&blame); err != nil {
+			return nil, err
+		}
+		return &blame, nil
+	}
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	return nil, fmt.Errorf("GET %s returned %d: %s", blameURL, resp.StatusCode, string(bodyBytes))
+}
+
+// GetEntryBlame godoc
+// @Summary      Blame an entry
+// @Description  Returns each content block of the current version of an entry with the version, editor and timestamp that last introduced it. It is computed by the version service, walking the version chain of the entry.
+// @Tags         Entries
+// @Produce      application/json
+// @Param        id    path      string  true  "Entry ID"
+// @Success      200   {object}  dto.BlameDTO
+// @Failure      400   {string}  string  "Invalid ID"
+// @Failure      404   {string}  string  "Entry not found"
+// @Failure      500   {string}  string  "Internal server error"
+// @Router       /api/entries/{id}/blame [get]
+func GetEntryBlame(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Invalid ID format")
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var entry model.Entry
+	err = database.EntryCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&entry)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Entry not found")
+		http.Error(w, "Entry not found", http.StatusNotFound)
+		return
+	}
+
+	blame, err := fetchBlame(id)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Str("entryID", id).Msg("Failed to fetch the blame of the entry")
+		http.Error(w, "Failed to retrieve versions", http.StatusInternalServerError)
+		return
+	}
+
+	if blame == nil {
+		config.App.Logger.Info().Str("entryID", id).Msg("No versions found")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(blame); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
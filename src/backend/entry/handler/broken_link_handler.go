@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/laWiki/entry/config"
+	"github.com/laWiki/entry/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// SetBrokenLinks godoc
+// @Summary      Flag the entries with broken links
+// @Description  Sets the number of broken external links of the entries, as found by the link checker of the version service. Entries with none lose the flag. Only for internal calls.
+// @Tags         Entries
+// @Accept       application/json
+// @Param        counts  body      map[string]int  true  "Number of broken links by entry ID"
+// @Success      204     {string}  string  "No Content"
+// @Failure      400     {string}  string  "Invalid request body"
+// @Failure      403     {string}  string  "Forbidden"
+// @Failure      500     {string}  string  "Internal server error"
+// @Router       /api/entries/broken-links [put]
+func SetBrokenLinks(w http.ResponseWriter, r *http.Request) {
+	req := getRequester(r)
+	if !req.Internal {
+		config.App.Logger.Warn().Str("userID", req.ID).Str("role", req.Role).Msg("Broken links flag set without privileges")
+		http.Error(w, "Forbidden: insufficient privileges", http.StatusForbidden)
+		return
+	}
+
+	var counts map[string]int
+	if err := json.NewDecoder(r.Body).Decode(&counts); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode provided request body")
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var models []mongo.WriteModel
+	for id, count := range counts {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			// entries deleted meanwhile, or not entries at all, are skipped
+			continue
+		}
+		update := bson.M{"$unset": bson.M{"broken_links": ""}}
+		if count > 0 {
+			update = bson.M{"$set": bson.M{"broken_links": count}}
+		}
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": objID}).SetUpdate(update))
+	}
+	if len(models) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := database.EntryCollection.BulkWrite(ctx, models); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	config.App.Logger.Info().Int("entries", len(models)).Msg("Broken links flags updated")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,499 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/laWiki/entry/config"
+	"github.com/laWiki/entry/database"
+	"github.com/laWiki/entry/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errCategoryCycle = errors.New("category cycle")
+
+// normalizeCategories cleans the categories of an entry, dropping empty ones and duplicates
+func normalizeCategories(categories []string) []string {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, category := range categories {
+		category = normalizeTitle(category)
+		if category == "" || seen[category] {
+			continue
+		}
+		seen[category] = true
+		normalized = append(normalized, category)
+	}
+	return normalized
+}
+
+// canCategorize reports whether the requester can change the hierarchy of the categories or rename them
+func (req requester) canCategorize() bool {
+	return req.Internal || req.Role == "editor" || req.Role == "admin"
+}
+
+// categoryParents returns the parent of every category of a wiki that has one
+func categoryParents(ctx context.Context, wikiID string) (map[string]string, error) {
+	cursor, err := database.CategoryCollection.Find(ctx, bson.M{"wiki_id": wikiID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var categories []model.Category
+	if err := cursor.All(ctx, &categories); err != nil {
+		return nil, err
+	}
+	parents := map[string]string{}
+	for _, category := range categories {
+		if category.Parent != "" {
+			parents[category.Name] = category.Parent
+		}
+	}
+	return parents, nil
+}
+
+// withSubcategories returns a category with all the categories under it
+func withSubcategories(parents map[string]string, category string) []string {
+	children := map[string][]string{}
+	for child, parent := range parents {
+		children[parent] = append(children[parent], child)
+	}
+
+	names := []string{category}
+	seen := map[string]bool{category: true}
+	for i := 0; i < len(names); i++ {
+		for _, child := range children[names[i]] {
+			if !seen[child] {
+				seen[child] = true
+				names = append(names, child)
+			}
+		}
+	}
+	return names
+}
+
+// categoryFilter is the search filter of the tag parameters: entries in every tag, or in a subcategory of
+// it when recursive
+func categoryFilter(ctx context.Context, tags []string, wikiID string, recursive bool) (bson.M, error) {
+	var parents map[string]string
+	if recursive && wikiID != "" {
+		var err error
+		if parents, err = categoryParents(ctx, wikiID); err != nil {
+			return nil, err
+		}
+	}
+
+	var conditions bson.A
+	for _, tag := range normalizeCategories(tags) {
+		names := []string{tag}
+		if parents != nil {
+			names = withSubcategories(parents, tag)
+		}
+		conditions = append(conditions, bson.M{"categories": bson.M{"$in": names}})
+	}
+	if len(conditions) == 0 {
+		return bson.M{}, nil
+	}
+	return bson.M{"$and": conditions}, nil
+}
+
+// CategoryCount is a category of a wiki with the number of entries in it
+type CategoryCount struct {
+	Name          string   `json:"name"`
+	Parent        string   `json:"parent,omitempty"`
+	Subcategories []string `json:"subcategories,omitempty"`
+	Count         int      `json:"count"`
+}
+
+// GetCategories godoc
+// @Summary      List the categories of a wiki
+// @Description  Lists the categories of the entries of a wiki with the number of entries in each, their parent and subcategories. Categories with subcategories are listed even if no entry is directly in them.
+// @Tags         Categories
+// @Produce      application/json
+// @Param        wikiID  query     string  true  "Wiki ID"
+// @Success      200     {array}   CategoryCount
+// @Success      204     {string}  string  "No Content"
+// @Failure      400     {string}  string  "WikiID is required"
+// @Failure      500     {string}  string  "Internal server error"
+// @Router       /api/entries/categories [get]
+func GetCategories(w http.ResponseWriter, r *http.Request) {
+	wikiID := r.URL.Query().Get("wikiID")
+	if wikiID == "" {
+		config.App.Logger.Warn().Msg("Missing wikiID parameter")
+		http.Error(w, "WikiID is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"wiki_id": wikiID, "redirect_to": bson.M{"$in": bson.A{"", nil}}}}},
+		{{Key: "$unwind", Value: "$categories"}},
+		{{Key: "$group", Value: bson.M{"_id": "$categories", "count": bson.M{"$sum": 1}}}},
+	}
+	cursor, err := database.EntryCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var counts []struct {
+		Name  string `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+	if err := cursor.All(ctx, &counts); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode categories")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	parents, err := categoryParents(ctx, wikiID)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	categories := map[string]*CategoryCount{}
+	category := func(name string) *CategoryCount {
+		if categories[name] == nil {
+			categories[name] = &CategoryCount{Name: name, Parent: parents[name]}
+		}
+		return categories[name]
+	}
+	for _, count := range counts {
+		category(count.Name).Count = count.Count
+	}
+	for child, parent := range parents {
+		category(child)
+		category(parent).Subcategories = append(category(parent).Subcategories, child)
+	}
+
+	if len(categories) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	list := make([]CategoryCount, 0, len(categories))
+	for _, c := range categories {
+		sort.Strings(c.Subcategories)
+		list = append(list, *c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// CategoryParentRequest is the body used to place a category under another
+type CategoryParentRequest struct {
+	Name   string `json:"name"`
+	Parent string `json:"parent"`
+}
+
+// SetCategoryParent godoc
+// @Summary      Set the parent of a category
+// @Description  Makes a category of a wiki a subcategory of another, or a top-level category with an empty parent. A category can't end up under itself. Only editors and admins can change the hierarchy.
+// @Tags         Categories
+// @Accept       application/json
+// @Produce      application/json
+// @Param        wikiID    query     string                 true  "Wiki ID"
+// @Param        category  body      CategoryParentRequest  true  "Category and its parent"
+// @Success      200       {object}  model.Category
+// @Failure      400       {string}  string  "Invalid request body"
+// @Failure      403       {string}  string  "Forbidden"
+// @Failure      409       {string}  string  "The category would be under itself"
+// @Failure      500       {string}  string  "Internal server error"
+// @Router       /api/entries/categories/parent [put]
+func SetCategoryParent(w http.ResponseWriter, r *http.Request) {
+	wikiID := r.URL.Query().Get("wikiID")
+
+	req := getRequester(r)
+	if !req.canCategorize() {
+		config.App.Logger.Warn().Str("userID", req.ID).Str("role", req.Role).Msg("Category change without privileges")
+		http.Error(w, "Forbidden: insufficient privileges", http.StatusForbidden)
+		return
+	}
+
+	var body CategoryParentRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode provided request body")
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	body.Name = normalizeTitle(body.Name)
+	body.Parent = normalizeTitle(body.Parent)
+	if wikiID == "" || body.Name == "" {
+		config.App.Logger.Error().Msg("Missing required fields")
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	parents, err := categoryParents(ctx, wikiID)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	// the new parent can't be the category or one of its subcategories
+	if body.Parent != "" {
+		for _, name := range withSubcategories(parents, body.Name) {
+			if name == body.Parent {
+				config.App.Logger.Warn().Str("category", body.Name).Str("parent", body.Parent).Msg("Category cycle")
+				http.Error(w, "The category would be under itself", http.StatusConflict)
+				return
+			}
+		}
+	}
+
+	category := model.Category{WikiID: wikiID, Name: body.Name, Parent: body.Parent, UpdatedAt: time.Now().UTC()}
+	if body.Parent == "" {
+		_, err = database.CategoryCollection.DeleteOne(ctx, bson.M{"wiki_id": wikiID, "name": body.Name})
+	} else {
+		_, err = database.CategoryCollection.ReplaceOne(ctx, bson.M{"wiki_id": wikiID, "name": body.Name}, category, options.Replace().SetUpsert(true))
+	}
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(category); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// CategoryMergeRequest is the body used to rename a category, or merge several into one
+type CategoryMergeRequest struct {
+	From []string `json:"from"`
+	To   string   `json:"to"`
+}
+
+// CategoryMergeReport is the result of renaming or merging categories
+type CategoryMergeReport struct {
+	From    []string `json:"from"`
+	To      string   `json:"to"`
+	Entries int64    `json:"entries"`
+}
+
+// RenameCategory godoc
+// @Summary      Rename a category
+// @Description  Renames a category of a wiki in every entry in it, keeping its place in the hierarchy and its subcategories. The new name can't be in use; merge the categories instead. All the entries are updated in a single transaction. Only editors and admins can rename categories.
+// @Tags         Categories
+// @Accept       application/json
+// @Produce      application/json
+// @Param        wikiID    query     string                true  "Wiki ID"
+// @Param        category  body      CategoryMergeRequest  true  "Category to rename, as the only element of from, and its new name"
+// @Success      200       {object}  CategoryMergeReport
+// @Failure      400       {string}  string  "Invalid request body"
+// @Failure      403       {string}  string  "Forbidden"
+// @Failure      409       {string}  string  "Category already exists"
+// @Failure      500       {string}  string  "Internal server error"
+// @Router       /api/entries/categories/rename [post]
+func RenameCategory(w http.ResponseWriter, r *http.Request) {
+	mergeCategories(w, r, false)
+}
+
+// MergeCategories godoc
+// @Summary      Merge categories
+// @Description  Moves every entry of a wiki in one of the from categories to the to category, which may already exist. The subcategories of the merged categories go under the to category. All the entries are updated in a single transaction. Only editors and admins can merge categories.
+// @Tags         Categories
+// @Accept       application/json
+// @Produce      application/json
+// @Param        wikiID      query     string                true  "Wiki ID"
+// @Param        categories  body      CategoryMergeRequest  true  "Categories to merge and the one they are merged into"
+// @Success      200         {object}  CategoryMergeReport
+// @Failure      400         {string}  string  "Invalid request body"
+// @Failure      403         {string}  string  "Forbidden"
+// @Failure      409         {string}  string  "The category would be under itself"
+// @Failure      500         {string}  string  "Internal server error"
+// @Router       /api/entries/categories/merge [post]
+func MergeCategories(w http.ResponseWriter, r *http.Request) {
+	mergeCategories(w, r, true)
+}
+
+// mergeCategories renames a category, or merges several, in every entry and in the hierarchy
+func mergeCategories(w http.ResponseWriter, r *http.Request, merge bool) {
+	wikiID := r.URL.Query().Get("wikiID")
+
+	req := getRequester(r)
+	if !req.canCategorize() {
+		config.App.Logger.Warn().Str("userID", req.ID).Str("role", req.Role).Msg("Category change without privileges")
+		http.Error(w, "Forbidden: insufficient privileges", http.StatusForbidden)
+		return
+	}
+
+	var body CategoryMergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode provided request body")
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	body.To = normalizeTitle(body.To)
+	var from []string
+	for _, name := range normalizeCategories(body.From) {
+		if name != body.To {
+			from = append(from, name)
+		}
+	}
+	if wikiID == "" || body.To == "" || len(from) == 0 || (!merge && len(from) != 1) {
+		config.App.Logger.Error().Msg("Missing required fields")
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	parents, err := categoryParents(ctx, wikiID)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !merge {
+		count, err := database.EntryCollection.CountDocuments(ctx, bson.M{"wiki_id": wikiID, "categories": body.To})
+		if err != nil {
+			config.App.Logger.Error().Err(err).Msg("Database error")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if _, hasParent := parents[body.To]; count > 0 || hasParent || len(withSubcategories(parents, body.To)) > 1 {
+			config.App.Logger.Warn().Str("category", body.To).Msg("Rename to an existing category")
+			http.Error(w, "Category already exists", http.StatusConflict)
+			return
+		}
+	}
+
+	report := CategoryMergeReport{From: from, To: body.To}
+	session, err := database.Client.StartSession()
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to start a database session")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		modified, err := moveEntries(sc, wikiID, from, body.To)
+		if err != nil {
+			return nil, err
+		}
+		report.Entries = modified
+		return nil, moveCategories(sc, wikiID, parents, from, body.To)
+	})
+	if errors.Is(err, errCategoryCycle) {
+		config.App.Logger.Warn().Strs("from", from).Str("to", body.To).Msg("Category cycle")
+		http.Error(w, "The category would be under itself", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		config.App.Logger.Error().Err(err).Strs("from", from).Str("to", body.To).Msg("Failed to move the categories")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	config.App.Logger.Info().Strs("from", from).Str("to", body.To).Int64("entries", report.Entries).Msg("Categories moved")
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// moveEntries replaces the from categories with the to category in the entries of a wiki, keeping the order
+// of their categories and dropping the duplicates left
+func moveEntries(ctx context.Context, wikiID string, from []string, to string) (int64, error) {
+	renamed := bson.M{"$map": bson.M{
+		"input": "$categories",
+		"in":    bson.M{"$cond": bson.A{bson.M{"$in": bson.A{"$$this", from}}, to, "$$this"}},
+	}}
+	deduplicated := bson.M{"$reduce": bson.M{
+		"input":        renamed,
+		"initialValue": bson.A{},
+		"in": bson.M{"$cond": bson.A{
+			bson.M{"$in": bson.A{"$$this", "$$value"}},
+			"$$value",
+			bson.M{"$concatArrays": bson.A{"$$value", bson.A{"$$this"}}},
+		}},
+	}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{"categories": deduplicated}}}}
+
+	result, err := database.EntryCollection.UpdateMany(ctx, bson.M{"wiki_id": wikiID, "categories": bson.M{"$in": from}}, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// moveCategories moves the subcategories of the from categories under the to category, which takes the place
+// of a from category it was directly under. A renamed category keeps its parent; merged categories are
+// dropped from the hierarchy.
+func moveCategories(ctx context.Context, wikiID string, parents map[string]string, from []string, to string) error {
+	merged := map[string]bool{}
+	for _, name := range from {
+		merged[name] = true
+	}
+
+	// the to category can't end up under one of its own subcategories
+	parent := parents[to]
+	for merged[parent] {
+		parent = parents[parent]
+	}
+	for _, name := range from {
+		for _, sub := range withSubcategories(parents, name)[1:] {
+			if sub == to && !merged[parents[to]] {
+				return errCategoryCycle
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	if _, err := database.CategoryCollection.UpdateMany(ctx,
+		bson.M{"wiki_id": wikiID, "parent": bson.M{"$in": from}, "name": bson.M{"$ne": to}},
+		bson.M{"$set": bson.M{"parent": to, "updated_at": now}}); err != nil {
+		return err
+	}
+
+	// a rename keeps the place of the category in the hierarchy
+	if _, hasParent := parents[to]; !hasParent && len(from) == 1 {
+		parent = parents[from[0]]
+	}
+	if parent != parents[to] {
+		var err error
+		if parent == "" {
+			_, err = database.CategoryCollection.DeleteOne(ctx, bson.M{"wiki_id": wikiID, "name": to})
+		} else {
+			category := model.Category{WikiID: wikiID, Name: to, Parent: parent, UpdatedAt: now}
+			_, err = database.CategoryCollection.ReplaceOne(ctx, bson.M{"wiki_id": wikiID, "name": to}, category, options.Replace().SetUpsert(true))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := database.CategoryCollection.DeleteMany(ctx, bson.M{"wiki_id": wikiID, "name": bson.M{"$in": from}})
+	return err
+}
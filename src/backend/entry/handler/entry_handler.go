@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
 	"strings"
 	"time"
 
@@ -15,6 +17,7 @@ import (
 	"github.com/laWiki/entry/database"
 	"github.com/laWiki/entry/dto"
 	"github.com/laWiki/entry/model"
+	"github.com/laWiki/entry/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
@@ -35,19 +38,33 @@ func HealthCheck(w http.ResponseWriter, r *http.Request) {
 
 // GetEntries godoc
 // @Summary      Get all entries
-// @Description  Retrieves the list of all entries from the database.
+// @Description  Retrieves the list of all entries from the database. With asOf, only the entries that existed at that moment are listed, with the titles they had then.
 // @Tags         Entries
 // @Produce      application/json
+// @Param        asOf  query     string  false  "Moment to list the entries at (RFC3339)"
 // @Success      200  {array}   model.Entry
+// @Failure      400  {string}  string  "Invalid asOf date"
 // @Failure      500  {string}  string  "Internal server error"
 // @Router       /api/entries/ [get]
 func GetEntries(w http.ResponseWriter, r *http.Request) {
 	var entries []model.Entry
 
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Invalid 'asOf' date format. Expected ISO8601 format.")
+		http.Error(w, "Invalid 'asOf' date format. Expected ISO8601 format.", http.StatusBadRequest)
+		return
+	}
+
+	filter := bson.M{}
+	if !asOf.IsZero() {
+		filter["created_at"] = bson.M{"$lte": asOf}
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	cursor, err := database.EntryCollection.Find(ctx, bson.M{})
+	cursor, err := database.EntryCollection.Find(ctx, filter)
 	if err != nil {
 		config.App.Logger.Error().Err(err).Msg("Database error")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -62,6 +79,9 @@ func GetEntries(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
+		if !asOf.IsZero() {
+			entry, _ = entryAt(entry, asOf)
+		}
 		entries = append(entries, entry)
 	}
 
@@ -81,12 +101,13 @@ func GetEntries(w http.ResponseWriter, r *http.Request) {
 
 // GetEntryByID godoc
 // @Summary      Get an entry by ID
-// @Description  Retrieves an entry by its ID.
+// @Description  Retrieves an entry by its ID. With asOf, returns the title the entry had at that moment together with the version that was current then.
 // @Tags         Entries
 // @Produce      application/json
 // @Param        id    query     string  true  "Entry ID"
+// @Param        asOf  query     string  false  "Moment to read the entry at (RFC3339)"
 // @Success      200   {object}  model.Entry
-// @Failure      400   {string}  string  "Invalid ID"
+// @Failure      400   {string}  string  "Invalid ID or asOf date"
 // @Failure      404   {string}  string  "Entry not found"
 // @Failure      500   {string}  string  "Internal server error"
 // @Router       /api/entries/{id} [get]
@@ -100,6 +121,13 @@ func GetEntryByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Invalid 'asOf' date format. Expected ISO8601 format.")
+		http.Error(w, "Invalid 'asOf' date format. Expected ISO8601 format.", http.StatusBadRequest)
+		return
+	}
+
 	var entry model.Entry
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -112,8 +140,26 @@ func GetEntryByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var response interface{} = entry
+	if !asOf.IsZero() {
+		past, existed := entryAt(entry, asOf)
+		if !existed {
+			config.App.Logger.Info().Str("id", id).Time("asOf", asOf).Msg("Entry created after the requested moment")
+			http.Error(w, "Entry not found", http.StatusNotFound)
+			return
+		}
+
+		version, err := fetchVersionAsOf(id, asOf)
+		if err != nil {
+			config.App.Logger.Error().Err(err).Str("entryID", id).Msg("Failed to fetch the version current at the requested moment")
+			http.Error(w, "Failed to retrieve versions", http.StatusInternalServerError)
+			return
+		}
+		response = EntryAsOf{Entry: past, AsOf: asOf, Version: version}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(entry); err != nil {
+	if err := json.NewEncoder(w).Encode(response); err != nil {
 		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
@@ -122,7 +168,7 @@ func GetEntryByID(w http.ResponseWriter, r *http.Request) {
 
 // SearchEntries godoc
 // @Summary      Search entries
-// @Description  Search for entries using various query parameters. You can search by title, exact_title, author, createdAt, or wikiID. All parameters are optional and can be combined.
+// @Description  Search for entries using various query parameters. You can search by title, exact_title, author, createdAt, or wikiID. All parameters are optional and can be combined. Attributes are searched with conditions like attr.year>1900 or attr.country=Spain, using =, !=, >, >=, < or <=; with wikiID they must be in the schema of the wiki. With tag, only the entries in every given category are returned; with recursive and wikiID, entries in their subcategories count too. With asOf, the search runs over the entries that existed at that moment and the titles they had then.
 // @Tags         Entries
 // @Produce      application/json
 // @Param        title        query     string  false  "Partial title to search for (case-insensitive)"
@@ -130,6 +176,11 @@ func GetEntryByID(w http.ResponseWriter, r *http.Request) {
 // @Param        author       query     string  false  "Author to search for"
 // @Param        createdAt    query     string  false  "Creation date (YYYY-MM-DD)"
 // @Param        wikiID       query     string  false  "Wiki ID to search for"
+// @Param        asOf         query     string  false  "Moment to search the entries at (RFC3339)"
+// @Param        attr.name    query     string  false  "Condition on an attribute, like attr.year>1900"
+// @Param        tag          query     string  false  "Category the entries are in (can be repeated)"
+// @Param        recursive    query     bool    false  "Include the entries in subcategories of the tags (needs wikiID)"
+// @Param        brokenLinks  query     bool    false  "Only the entries with broken external links"
 // @Success      200          {array}   model.Entry
 // @Failure      400          {string}  string  "Bad Request"
 // @Failure      500          {string}  string  "Internal Server Error"
@@ -143,6 +194,13 @@ func SearchEntries(w http.ResponseWriter, r *http.Request) {
 	createdAtToString := r.URL.Query().Get("createdAtTo")
 	wikiID := r.URL.Query().Get("wikiID")
 
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Invalid 'asOf' date format. Expected ISO8601 format.")
+		http.Error(w, "Invalid 'asOf' date format. Expected ISO8601 format.", http.StatusBadRequest)
+		return
+	}
+
 	// Build the MongoDB filter dynamically
 	filter := bson.M{}
 	// Handle 'author' parameter (multiple IDs as strings)
@@ -186,9 +244,52 @@ func SearchEntries(w http.ResponseWriter, r *http.Request) {
 		filter["created_at"] = dateFilter
 	}
 
+	// past titles are matched once the entries are rewound
+	var titleMatch *regexp.Regexp
+	if !asOf.IsZero() {
+		dateFilter, _ := filter["created_at"].(bson.M)
+		if dateFilter == nil {
+			dateFilter = bson.M{}
+		}
+		if to, ok := dateFilter["$lte"].(time.Time); !ok || to.After(asOf) {
+			dateFilter["$lte"] = asOf
+		}
+		filter["created_at"] = dateFilter
+
+		delete(filter, "title")
+		if exactTitle != "" {
+			titleMatch = regexp.MustCompile("^" + regexp.QuoteMeta(exactTitle) + "$")
+		} else if title != "" {
+			titleMatch, err = regexp.Compile("(?i)" + title)
+			if err != nil {
+				config.App.Logger.Error().Err(err).Msg("Invalid title pattern")
+				http.Error(w, "Invalid title pattern", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
 	if wikiID != "" {
 		filter["wiki_id"] = wikiID
 	}
+	if r.URL.Query().Get("brokenLinks") == "true" {
+		filter["broken_links"] = bson.M{"$gt": 0}
+	}
+
+	conditions, err := utils.ParseAttributeConditions(r.URL.RawQuery)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Invalid attribute condition")
+		http.Error(w, "Invalid attribute condition", http.StatusBadRequest)
+		return
+	}
+	attrFilter, err := attributeFilter(conditions, wikiID)
+	if err != nil {
+		writeAttributesError(w, err)
+		return
+	}
+	for field, cond := range attrFilter {
+		filter[field] = cond
+	}
 
 	// Query the database
 	var entries []model.Entry
@@ -196,6 +297,18 @@ func SearchEntries(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	if tags := r.URL.Query()["tag"]; len(tags) > 0 {
+		tagFilter, err := categoryFilter(ctx, tags, wikiID, r.URL.Query().Get("recursive") == "true")
+		if err != nil {
+			config.App.Logger.Error().Err(err).Msg("Database error")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		for field, cond := range tagFilter {
+			filter[field] = cond
+		}
+	}
+
 	cursor, err := database.EntryCollection.Find(ctx, filter)
 	if err != nil {
 		config.App.Logger.Error().Err(err).Msg("Database error")
@@ -211,6 +324,12 @@ func SearchEntries(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
+		if !asOf.IsZero() {
+			entry, _ = entryAt(entry, asOf)
+			if titleMatch != nil && !titleMatch.MatchString(entry.Title) {
+				continue
+			}
+		}
 		entries = append(entries, entry)
 	}
 
@@ -236,13 +355,13 @@ func SearchEntries(w http.ResponseWriter, r *http.Request) {
 
 // PostEntry godoc
 // @Summary      Create a new entry
-// @Description  Creates a new entry. Expects a JSON object in the request body.
+// @Description  Creates a new entry. Expects a JSON object in the request body. An entry with redirect_to is a redirect to another entry of the same wiki, and one of kind template holds content other entries include. With parent_entry_id, the entry goes under another entry of the same wiki.
 // @Tags         Entries
 // @Accept       application/json
 // @Produce      application/json
 // @Param        entry  body      model.Entry  true  "Entry information"
 // @Success      201    {object}  model.Entry
-// @Failure      400    {string}  string  "Invalid request body"
+// @Failure      400    {string}  string  "Invalid request body, kind, redirect target or parent entry"
 // @Failure      500    {string}  string  "Internal server error"
 // @Router       /api/entries/ [post]
 
@@ -261,11 +380,69 @@ func PostEntry(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if entry.Kind != "" && entry.Kind != model.KindTemplate {
+		config.App.Logger.Error().Str("kind", entry.Kind).Msg("Invalid entry kind")
+		http.Error(w, "Invalid entry kind", http.StatusBadRequest)
+		return
+	}
+
 	entry.CreatedAt = time.Now().UTC()
+	entry.Title = normalizeTitle(entry.Title)
+	entry.Aliases = normalizeAliases(entry.Aliases, entry.Title)
+	if entry.Categories != nil {
+		entry.Categories = normalizeCategories(entry.Categories)
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	if entry.IsRedirect() {
+		if _, err := checkRedirectTarget(ctx, entry, entry.RedirectTo); err != nil {
+			config.App.Logger.Error().Err(err).Str("redirectTo", entry.RedirectTo).Msg("Invalid redirect target")
+			http.Error(w, "Invalid redirect target", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// redirects have no place in the hierarchy
+	if entry.ParentEntryID != "" {
+		if entry.IsRedirect() {
+			config.App.Logger.Error().Msg("Parent set on a redirect")
+			http.Error(w, "Redirects can't be placed under an entry", http.StatusBadRequest)
+			return
+		}
+		if _, err := checkParent(ctx, entry, entry.ParentEntryID); err != nil {
+			writeParentError(w, err, entry.ParentEntryID)
+			return
+		}
+	}
+
+	// only articles have attributes, checked against the infobox schema of their wiki when given
+	if entry.Attributes != nil && (entry.IsRedirect() || entry.Kind != "") {
+		config.App.Logger.Error().Msg("Attributes on an entry that isn't an article")
+		http.Error(w, "Only articles have attributes", http.StatusBadRequest)
+		return
+	}
+	if entry.Attributes != nil {
+		attributes, err := checkAttributes(ctx, entry)
+		if err != nil {
+			writeAttributesError(w, err)
+			return
+		}
+		entry.Attributes = attributes
+	}
+
+	slug, err := entrySlug(ctx, entry.WikiID, entry.Title, primitive.NilObjectID)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	entry.Slug = slug
+	entry.OldSlugs = nil
+	// only the link checker of the version service counts the broken links
+	entry.BrokenLinks = 0
+
 	result, err := database.EntryCollection.InsertOne(ctx, entry)
 	if err != nil {
 		config.App.Logger.Error().Err(err).Msg("Database error")
@@ -288,18 +465,23 @@ func PostEntry(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+
+	// red links to the new titles now lead somewhere
+	retargetLinks(entry.WikiID, "", append([]string{entry.Title}, entry.Aliases...)...)
 }
 
 // PutEntry godoc
 // @Summary      Update an entry by ID
-// @Description  Updates an entry by its ID. Expects a JSON object in the request body.
+// @Description  Updates an entry by its ID. Expects a JSON object in the request body. Protected entries can only be updated by the roles allowed by their protection. Renaming an entry leaves a redirect from its old title, unless redirect is false. Aliases and the target of a redirect are only changed when given.
 // @Tags         Entries
 // @Accept       application/json
 // @Produce      application/json
-// @Param        id     query     string      true  "Entry ID"
-// @Param        entry  body      model.Entry true  "Updated entry information"
+// @Param        id        query     string      true   "Entry ID"
+// @Param        redirect  query     bool        false  "Leave a redirect from the old title (default true)"
+// @Param        entry     body      model.Entry true   "Updated entry information"
 // @Success      200    {object}  model.Entry
 // @Failure      400    {string}  string  "Invalid ID or request body"
+// @Failure      403    {string}  string  "Forbidden: the entry is protected"
 // @Failure      404    {string}  string  "Entry not found"
 // @Failure      500    {string}  string  "Internal server error"
 // @Router       /api/entries/{id} [put]
@@ -326,6 +508,26 @@ func PutEntry(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	var current model.Entry
+	err = database.EntryCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&current)
+	if err != nil {
+		config.App.Logger.Warn().Str("id", id).Msg("Entry not found for update")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	req := getRequester(r)
+	if !req.canEdit(current) {
+		config.App.Logger.Warn().Str("id", id).Str("userID", req.ID).Str("level", current.Protection.Level).Msg("Edit of a protected entry")
+		http.Error(w, "Forbidden: the entry is protected", http.StatusForbidden)
+		return
+	}
+
+	// the title is kept when not given, so updates of other fields don't rename the entry
+	entry.Title = normalizeTitle(entry.Title)
+	if entry.Title == "" {
+		entry.Title = current.Title
+	}
 	update := bson.M{
 		"$set": bson.M{
 			"title":      entry.Title,
@@ -333,6 +535,64 @@ func PutEntry(w http.ResponseWriter, r *http.Request) {
 			"updated_at": entry.UpdatedAt,
 		},
 	}
+	// aliases and redirect targets are only changed when given
+	aliasesChanged := entry.Aliases != nil
+	if aliasesChanged {
+		entry.Aliases = normalizeAliases(entry.Aliases, entry.Title)
+		update["$set"].(bson.M)["aliases"] = entry.Aliases
+	}
+	if entry.RedirectTo != "" && entry.RedirectTo != current.RedirectTo {
+		if !current.IsRedirect() {
+			config.App.Logger.Warn().Str("id", id).Msg("Redirect target set on an entry with content")
+			http.Error(w, "Only redirects can change their target", http.StatusBadRequest)
+			return
+		}
+		if _, err := checkRedirectTarget(ctx, current, entry.RedirectTo); err != nil {
+			config.App.Logger.Error().Err(err).Str("redirectTo", entry.RedirectTo).Msg("Invalid redirect target")
+			http.Error(w, "Invalid redirect target", http.StatusBadRequest)
+			return
+		}
+		update["$set"].(bson.M)["redirect_to"] = entry.RedirectTo
+	}
+	// categories are only changed when given
+	if entry.Categories != nil {
+		update["$set"].(bson.M)["categories"] = normalizeCategories(entry.Categories)
+	}
+	// attributes are only changed when given
+	if entry.Attributes != nil {
+		if current.IsRedirect() || current.Kind != "" {
+			config.App.Logger.Warn().Str("id", id).Msg("Attributes on an entry that isn't an article")
+			http.Error(w, "Only articles have attributes", http.StatusBadRequest)
+			return
+		}
+		entry.ID, entry.WikiID = current.ID, current.WikiID
+		attributes, err := checkAttributes(ctx, entry)
+		if err != nil {
+			writeAttributesError(w, err)
+			return
+		}
+		update["$set"].(bson.M)["attributes"] = attributes
+	}
+	// a renamed entry gets a new slug, the old one keeps leading to it
+	if entry.Title != current.Title && (current.Slug != "" || !current.IsRedirect()) {
+		slug, oldSlugs, err := renamedSlugs(ctx, current, entry.Title, objID)
+		if err != nil {
+			config.App.Logger.Error().Err(err).Msg("Database error")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		update["$set"].(bson.M)["slug"] = slug
+		update["$set"].(bson.M)["old_slugs"] = oldSlugs
+	}
+	// the title history is kept for the point-in-time reads
+	if entry.Title != current.Title {
+		update["$push"] = bson.M{"title_history": model.TitleChange{
+			Title:     entry.Title,
+			Previous:  current.Title,
+			ChangedBy: req.ID,
+			ChangedAt: entry.UpdatedAt,
+		}}
+	}
 
 	result, err := database.EntryCollection.UpdateOne(ctx, bson.M{"_id": objID}, update)
 	if err != nil {
@@ -354,23 +614,40 @@ func PutEntry(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// a renamed entry leaves its old title redirecting to it, unless asked not to
+	renamed := entry.Title != current.Title
+	if renamed && !current.IsRedirect() && r.URL.Query().Get("redirect") != "false" {
+		if err := leaveRedirect(ctx, entry, current.Title, req.ID); err != nil {
+			config.App.Logger.Error().Err(err).Str("id", id).Str("title", current.Title).Msg("Failed to leave a redirect")
+			http.Error(w, "Failed to leave a redirect", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(entry); err != nil {
 		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+
+	if renamed || aliasesChanged || entry.RedirectTo != current.RedirectTo {
+		titles := append([]string{current.Title, entry.Title}, current.Aliases...)
+		retargetLinks(entry.WikiID, entry.ID, append(titles, entry.Aliases...)...)
+	}
 }
 
 // DeleteEntry godoc
 // @Summary      Delete an entry by ID
-// @Description  Deletes an entry by its ID.
+// @Description  Deletes an entry by its ID. The entries under it move under its parent, or with children=cascade are deleted too, with all the entries under them. Entries with tagged versions aren't deleted. When some entries under it can't be deleted the entry is kept and the response lists the deleted and failed ones; repeating the request resumes the deletion.
 // @Tags         Entries
-// @Param        id    query     string  true  "Entry ID"
+// @Param        id        query     string  true   "Entry ID"
+// @Param        children  query     string  false  "What to do with the entries under it: rehome (default) or cascade"
 // @Success      204   {string}  string  "No Content"
 // @Failure      400   {string}  string  "Invalid ID"
 // @Failure      404   {string}  string  "Entry not found"
-// @Failure      500   {string}  string  "Internal server error"
+// @Failure      409   {string}  string  "Entries with tagged versions can't be deleted"
+// @Failure      500   {object}  CascadeReport  "Entries under it that couldn't be deleted"
 // @Router       /api/entries/{id} [delete]
 func DeleteEntry(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
@@ -378,37 +655,45 @@ func DeleteEntry(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Delete associated versions first
-	versionServiceURL := fmt.Sprintf("%s/api/versions/entry?entryID=%s", config.App.API_GATEWAY_URL, id)
-	config.App.Logger.Info().Str("url", versionServiceURL).Msg("Preparing to delete associated versions")
-
-	req, err := http.NewRequest("DELETE", versionServiceURL, nil)
-	if err != nil {
-		config.App.Logger.Error().Err(err).Msg("Failed to create request to version service")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	children, ok := childrenMode(r)
+	if !ok {
+		config.App.Logger.Error().Str("children", r.URL.Query().Get("children")).Msg("Invalid children parameter")
+		http.Error(w, "Invalid children parameter, use 'rehome' or 'cascade'", http.StatusBadRequest)
 		return
 	}
 
-	config.App.Logger.Info().Str("url", versionServiceURL).Msg("Sending request to delete associated versions")
-	client := &http.Client{
-		Timeout: 5 * time.Second,
+	// tagged versions belong to the snapshots of the wiki, the entries going are checked before anything goes
+	toDelete := []string{id}
+	if children == childrenCascade {
+		descendants, err := entryDescendants(ctx, id)
+		if err != nil {
+			config.App.Logger.Error().Err(err).Msg("Database error")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		toDelete = append(toDelete, descendants...)
 	}
-	req.Header.Set("X-Internal-Auth", config.App.JWTSecret)
-	resp, err := client.Do(req)
-	if err != nil {
-		config.App.Logger.Error().Err(err).Msg("Failed to send request to version service")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+	for _, entryID := range toDelete {
+		tagged, err := hasTaggedVersions(entryID)
+		if err != nil {
+			config.App.Logger.Error().Err(err).Str("entryID", entryID).Msg("Failed to retrieve the tags of the entry")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if tagged {
+			config.App.Logger.Warn().Str("entryID", entryID).Msg("Deletion of an entry with tagged versions")
+			http.Error(w, "Entries with tagged versions can't be deleted, untag them first", http.StatusConflict)
+			return
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		config.App.Logger.Error().
-			Int("status", resp.StatusCode).
-			Str("body", bodyString).
-			Msg("version service returned error")
+	// Delete associated versions first
+	if err := deleteEntryVersions(id); err == errTaggedVersions {
+		config.App.Logger.Warn().Str("entryID", id).Msg("Deletion of an entry with tagged versions")
+		http.Error(w, "Entries with tagged versions can't be deleted, untag them first", http.StatusConflict)
+		return
+	} else if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to delete associated versions")
 		http.Error(w, "Failed to delete associated versions", http.StatusInternalServerError)
 		return
 	}
@@ -431,6 +716,27 @@ func DeleteEntry(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the children move up or go with the entry
+	report, err := removeFromHierarchy(ctx, entry, children)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Str("entryID", id).Str("children", children).Msg("Failed to remove the entry from the hierarchy")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(report.Failed) > 0 {
+		config.App.Logger.Error().Str("entryID", id).Int("deleted", len(report.Deleted)).Int("failed", len(report.Failed)).Msg("Failed to delete some entries under the entry")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		}
+		return
+	}
+
+	// the cascade can take longer than the entry itself
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
 	// Now proceed to delete the entry document
 
 	result, err := database.EntryCollection.DeleteOne(ctx, bson.M{"_id": objID})
@@ -445,20 +751,29 @@ func DeleteEntry(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the redirects to the entry would lead nowhere
+	if _, err := database.EntryCollection.DeleteMany(ctx, bson.M{"redirect_to": id}); err != nil {
+		config.App.Logger.Error().Err(err).Str("entryID", id).Msg("Failed to delete the redirects to the entry")
+	}
+
 	config.App.Logger.Info().Str("entryID", id).Msg("Version and associated versions deleted successfully")
 	w.WriteHeader(http.StatusNoContent)
+	retargetLinks(entry.WikiID, id, append([]string{entry.Title}, entry.Aliases...)...)
 
 	// Retrieve the user from the user service with the author ID from the entry
 	userServiceURL := fmt.Sprintf("%s/api/auth/user?id=%s", config.App.API_GATEWAY_URL, entry.Author)
 	config.App.Logger.Info().Str("url", userServiceURL).Msg("Sending request to user service")
 
-	req, err = http.NewRequest("GET", userServiceURL, nil)
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	req, err := http.NewRequest("GET", userServiceURL, nil)
 	if err != nil {
 		config.App.Logger.Error().Err(err).Msg("Failed to create request to user service")
 		return
 	}
 	req.Header.Set("X-Internal-Auth", config.App.JWTSecret)
-	resp, err = client.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		config.App.Logger.Error().Err(err).Msg("Failed to send request to user service")
 		return
@@ -550,7 +865,8 @@ func DeleteEntriesByWikiID(w http.ResponseWriter, r *http.Request) {
 		entryID := entry.ID
 
 		// Send request to version service to delete versions associated with entryID
-		versionServiceURL := fmt.Sprintf("%s/api/versions/entry?entryID=%s", config.App.API_GATEWAY_URL, entryID)
+		// the snapshots of the wiki go with it, so tagged versions are deleted too
+		versionServiceURL := fmt.Sprintf("%s/api/versions/entry?entryID=%s&tagged=delete", config.App.API_GATEWAY_URL, entryID)
 		config.App.Logger.Info().Str("url", versionServiceURL).Msg("Preparing to delete associated versions")
 
 		req, err := http.NewRequest("DELETE", versionServiceURL, nil)
@@ -883,6 +1199,65 @@ type SearchVersionsResponse struct {
 	Versions []dto.VersionDTO `json:"versions"`
 }
 
+// errTaggedVersions is returned by deleteEntryVersions when the version service keeps the versions of an entry
+// because some are tagged
+var errTaggedVersions = errors.New("entry with tagged versions")
+
+// hasTaggedVersions reports whether some version of an entry is tagged
+func hasTaggedVersions(entryID string) (bool, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/versions/tags?entryID=%s", config.App.API_GATEWAY_URL, entryID), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("X-Internal-Auth", config.App.JWTSecret)
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNoContent:
+		return false, nil
+	}
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	return false, fmt.Errorf("version service returned %d: %s", resp.StatusCode, string(bodyBytes))
+}
+
+// deleteEntryVersions deletes the Versions associated with an Entry via HTTP.
+func deleteEntryVersions(entryID string) error {
+	versionServiceURL := fmt.Sprintf("%s/api/versions/entry?entryID=%s", config.App.API_GATEWAY_URL, entryID)
+	config.App.Logger.Info().Str("url", versionServiceURL).Msg("Sending request to delete associated versions")
+
+	req, err := http.NewRequest("DELETE", versionServiceURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Internal-Auth", config.App.JWTSecret)
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict {
+		return errTaggedVersions
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("version service returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+	return nil
+}
+
 // fetchVersions retrieves Versions associated with an Entry via HTTP.
 func fetchVersions(entryID string) ([]dto.VersionDTO, error) {
 	url := fmt.Sprintf("%s/api/versions/search?entryID=%s", config.App.API_GATEWAY_URL, entryID)
@@ -892,6 +1267,10 @@ func fetchVersions(entryID string) ([]dto.VersionDTO, error) {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("failed to fetch versions: %s", resp.Status)
 	}
@@ -0,0 +1,525 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/laWiki/entry/config"
+	"github.com/laWiki/entry/database"
+	"github.com/laWiki/entry/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// maxHierarchyDepth is the deepest an entry can be nested, so a corrupted hierarchy can't be walked forever
+	maxHierarchyDepth = 100
+	// maxTreeDepth is the most levels of the tree returned at once
+	maxTreeDepth = 10
+)
+
+// What DeleteEntry does with the children of the entry
+const (
+	childrenRehome  = "rehome"  // the children move under the parent of the entry
+	childrenCascade = "cascade" // the children and all their descendants are deleted too
+)
+
+var (
+	errParentLoop   = errors.New("entry under itself")
+	errParentBroken = errors.New("parent entry missing or in another wiki")
+)
+
+// checkParent checks that an entry can be placed under the given parent: an entry of the same wiki with
+// content that isn't the entry or one of its descendants
+func checkParent(ctx context.Context, entry model.Entry, parentID string) (*model.Entry, error) {
+	if parentID == entry.ID {
+		return nil, errParentLoop
+	}
+	objID, err := primitive.ObjectIDFromHex(parentID)
+	if err != nil {
+		return nil, errParentBroken
+	}
+	var parent model.Entry
+	if err := database.EntryCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&parent); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errParentBroken
+		}
+		return nil, err
+	}
+	if parent.WikiID != entry.WikiID || parent.IsRedirect() {
+		return nil, errParentBroken
+	}
+
+	ancestors, err := entryAncestors(ctx, parent)
+	if err != nil {
+		return nil, err
+	}
+	if len(ancestors) >= maxHierarchyDepth {
+		return nil, errParentLoop
+	}
+	for _, ancestor := range ancestors {
+		if ancestor.ID == entry.ID {
+			return nil, errParentLoop
+		}
+	}
+	return &parent, nil
+}
+
+// writeParentError writes the response for an error of checkParent
+func writeParentError(w http.ResponseWriter, err error, parentID string) {
+	switch {
+	case errors.Is(err, errParentLoop):
+		config.App.Logger.Warn().Str("parentEntryID", parentID).Msg("Entry placed under itself")
+		http.Error(w, "The entry would be under itself", http.StatusConflict)
+	case errors.Is(err, errParentBroken):
+		config.App.Logger.Warn().Str("parentEntryID", parentID).Msg("Invalid parent entry")
+		http.Error(w, "Invalid parent entry", http.StatusBadRequest)
+	default:
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// entryAncestors returns the ancestors of an entry, from the root of its hierarchy to its parent. A missing
+// parent ends the hierarchy there.
+func entryAncestors(ctx context.Context, entry model.Entry) ([]model.Entry, error) {
+	var ancestors []model.Entry
+	seen := map[string]bool{entry.ID: true}
+	for current := entry; current.ParentEntryID != "" && len(ancestors) < maxHierarchyDepth; {
+		if seen[current.ParentEntryID] {
+			break
+		}
+		objID, err := primitive.ObjectIDFromHex(current.ParentEntryID)
+		if err != nil {
+			break
+		}
+		var parent model.Entry
+		if err := database.EntryCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&parent); err != nil {
+			if err == mongo.ErrNoDocuments {
+				break
+			}
+			return nil, err
+		}
+		seen[parent.ID] = true
+		ancestors = append([]model.Entry{parent}, ancestors...)
+		current = parent
+	}
+	return ancestors, nil
+}
+
+// entryChildren returns the children of the given entries, sorted by title
+func entryChildren(ctx context.Context, parentIDs []string) ([]model.Entry, error) {
+	opts := options.Find().SetSort(bson.M{"title": 1})
+	cursor, err := database.EntryCollection.Find(ctx, bson.M{"parent_entry_id": bson.M{"$in": parentIDs}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var children []model.Entry
+	if err := cursor.All(ctx, &children); err != nil {
+		return nil, err
+	}
+	return children, nil
+}
+
+// entryDescendants returns the IDs of all the entries under an entry, closest first
+func entryDescendants(ctx context.Context, entryID string) ([]string, error) {
+	descendants, _, err := descendantParents(ctx, entryID)
+	return descendants, err
+}
+
+// descendantParents returns the IDs of all the entries under an entry, closest first, with the parent of each
+func descendantParents(ctx context.Context, entryID string) ([]string, map[string]string, error) {
+	var descendants []string
+	parents := map[string]string{}
+	seen := map[string]bool{entryID: true}
+	level := []string{entryID}
+	for depth := 0; len(level) > 0 && depth < maxHierarchyDepth; depth++ {
+		children, err := entryChildren(ctx, level)
+		if err != nil {
+			return nil, nil, err
+		}
+		level = nil
+		for _, child := range children {
+			if !seen[child.ID] {
+				seen[child.ID] = true
+				parents[child.ID] = child.ParentEntryID
+				level = append(level, child.ID)
+			}
+		}
+		descendants = append(descendants, level...)
+	}
+	return descendants, parents, nil
+}
+
+// EntryNode is an entry in the tree of a wiki, with the entries under it
+type EntryNode struct {
+	ID          string       `json:"id"`
+	Title       string       `json:"title"`
+	Slug        string       `json:"slug,omitempty"`
+	Kind        string       `json:"kind,omitempty"`
+	HasChildren bool         `json:"has_children"`
+	Children    []*EntryNode `json:"children,omitempty"`
+}
+
+func newEntryNode(entry model.Entry) *EntryNode {
+	return &EntryNode{ID: entry.ID, Title: entry.Title, Slug: entry.Slug, Kind: entry.Kind}
+}
+
+// buildTree fills in the children of the nodes, depth levels down. The nodes of the last level only tell
+// whether they have children.
+func buildTree(ctx context.Context, nodes []*EntryNode, depth int) error {
+	for level := 0; level <= depth && len(nodes) > 0; level++ {
+		byID := map[string]*EntryNode{}
+		ids := make([]string, 0, len(nodes))
+		for _, node := range nodes {
+			byID[node.ID] = node
+			ids = append(ids, node.ID)
+		}
+
+		children, err := entryChildren(ctx, ids)
+		if err != nil {
+			return err
+		}
+		nodes = nil
+		for _, child := range children {
+			parent := byID[child.ParentEntryID]
+			parent.HasChildren = true
+			if level < depth {
+				node := newEntryNode(child)
+				parent.Children = append(parent.Children, node)
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	return nil
+}
+
+// treeDepth parses the depth parameter, 1 by default
+func treeDepth(r *http.Request) (int, error) {
+	depthParam := r.URL.Query().Get("depth")
+	if depthParam == "" {
+		return 1, nil
+	}
+	depth, err := strconv.Atoi(depthParam)
+	if err != nil || depth < 1 {
+		return 0, errors.New("invalid depth")
+	}
+	if depth > maxTreeDepth {
+		depth = maxTreeDepth
+	}
+	return depth, nil
+}
+
+// writeJSON encodes a value as the response
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetEntryTree godoc
+// @Summary      Get the entries under an entry
+// @Description  Returns an entry with the tree of entries under it, sorted by title, depth levels down. The entries of the last level tell whether they have children, to be fetched with another request.
+// @Tags         Entries
+// @Produce      application/json
+// @Param        id     path      string  true   "Entry ID"
+// @Param        depth  query     int     false  "Levels of the tree to return (default 1, max 10)"
+// @Success      200    {object}  EntryNode
+// @Failure      400    {string}  string  "Invalid ID or depth"
+// @Failure      404    {string}  string  "Entry not found"
+// @Failure      500    {string}  string  "Internal server error"
+// @Router       /api/entries/{id}/tree [get]
+func GetEntryTree(w http.ResponseWriter, r *http.Request) {
+	depth, err := treeDepth(r)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Invalid depth")
+		http.Error(w, "Invalid depth", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, entry, ok := loadEntry(w, r, ctx)
+	if !ok {
+		return
+	}
+
+	root := newEntryNode(*entry)
+	if err := buildTree(ctx, []*EntryNode{root}, depth); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, root)
+}
+
+// GetWikiTree godoc
+// @Summary      Get the tree of entries of a wiki
+// @Description  Returns the top-level entries of a wiki, those without parent, with the tree of entries under them, sorted by title, depth levels down. Redirects are left out.
+// @Tags         Entries
+// @Produce      application/json
+// @Param        wikiID  query     string  true   "Wiki ID"
+// @Param        depth   query     int     false  "Levels of the tree under the top-level entries to return (default 1, max 10)"
+// @Success      200     {array}   EntryNode
+// @Success      204     {string}  string  "No Content"
+// @Failure      400     {string}  string  "WikiID is required or invalid depth"
+// @Failure      500     {string}  string  "Internal server error"
+// @Router       /api/entries/tree [get]
+func GetWikiTree(w http.ResponseWriter, r *http.Request) {
+	wikiID := r.URL.Query().Get("wikiID")
+	if wikiID == "" {
+		config.App.Logger.Warn().Msg("Missing wikiID parameter")
+		http.Error(w, "WikiID is required", http.StatusBadRequest)
+		return
+	}
+	depth, err := treeDepth(r)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Invalid depth")
+		http.Error(w, "Invalid depth", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"wiki_id":         wikiID,
+		"parent_entry_id": bson.M{"$in": bson.A{"", nil}},
+		"redirect_to":     bson.M{"$in": bson.A{"", nil}},
+	}
+	cursor, err := database.EntryCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"title": 1}))
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var entries []model.Entry
+	if err := cursor.All(ctx, &entries); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode entries")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(entries) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	roots := make([]*EntryNode, 0, len(entries))
+	for _, entry := range entries {
+		roots = append(roots, newEntryNode(entry))
+	}
+	if err := buildTree(ctx, roots, depth-1); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, roots)
+}
+
+// Breadcrumb is an entry on the path from the top of the hierarchy of a wiki to an entry
+type Breadcrumb struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Slug  string `json:"slug,omitempty"`
+}
+
+// GetEntryBreadcrumbs godoc
+// @Summary      Get the breadcrumbs of an entry
+// @Description  Returns the entries from the top of the hierarchy of the wiki down to the entry, the entry included.
+// @Tags         Entries
+// @Produce      application/json
+// @Param        id   path      string  true  "Entry ID"
+// @Success      200  {array}   Breadcrumb
+// @Failure      400  {string}  string  "Invalid ID"
+// @Failure      404  {string}  string  "Entry not found"
+// @Failure      500  {string}  string  "Internal server error"
+// @Router       /api/entries/{id}/breadcrumbs [get]
+func GetEntryBreadcrumbs(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, entry, ok := loadEntry(w, r, ctx)
+	if !ok {
+		return
+	}
+
+	ancestors, err := entryAncestors(ctx, *entry)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	breadcrumbs := make([]Breadcrumb, 0, len(ancestors)+1)
+	for _, ancestor := range append(ancestors, *entry) {
+		breadcrumbs = append(breadcrumbs, Breadcrumb{ID: ancestor.ID, Title: ancestor.Title, Slug: ancestor.Slug})
+	}
+	writeJSON(w, breadcrumbs)
+}
+
+// EntryParentRequest is the body used to move an entry under another
+type EntryParentRequest struct {
+	ParentEntryID string `json:"parent_entry_id"`
+}
+
+// SetEntryParent godoc
+// @Summary      Move an entry under another
+// @Description  Moves an entry, with the entries under it, under another entry of the same wiki, or to the top of the hierarchy with an empty parent_entry_id. An entry can't be moved under itself or one of its descendants. Redirects have no place in the hierarchy.
+// @Tags         Entries
+// @Accept       application/json
+// @Produce      application/json
+// @Param        id      path      string              true  "Entry ID"
+// @Param        parent  body      EntryParentRequest  true  "New parent of the entry"
+// @Success      200     {object}  model.Entry
+// @Failure      400     {string}  string  "Invalid ID, request body or parent entry"
+// @Failure      403     {string}  string  "Forbidden: the entry is protected"
+// @Failure      404     {string}  string  "Entry not found"
+// @Failure      409     {string}  string  "The entry would be under itself"
+// @Failure      500     {string}  string  "Internal server error"
+// @Router       /api/entries/{id}/parent [put]
+func SetEntryParent(w http.ResponseWriter, r *http.Request) {
+	var body EntryParentRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode provided request body")
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objID, entry, ok := loadEntry(w, r, ctx)
+	if !ok {
+		return
+	}
+
+	req := getRequester(r)
+	if !req.canEdit(*entry) {
+		config.App.Logger.Warn().Str("id", entry.ID).Str("userID", req.ID).Str("level", entry.Protection.Level).Msg("Move of a protected entry")
+		http.Error(w, "Forbidden: the entry is protected", http.StatusForbidden)
+		return
+	}
+
+	update := bson.M{"$unset": bson.M{"parent_entry_id": ""}}
+	if body.ParentEntryID != "" {
+		if entry.IsRedirect() {
+			config.App.Logger.Warn().Str("id", entry.ID).Msg("Parent set on a redirect")
+			http.Error(w, "Redirects can't be placed under an entry", http.StatusBadRequest)
+			return
+		}
+		if _, err := checkParent(ctx, *entry, body.ParentEntryID); err != nil {
+			writeParentError(w, err, body.ParentEntryID)
+			return
+		}
+		update = bson.M{"$set": bson.M{"parent_entry_id": body.ParentEntryID}}
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var updated model.Entry
+	err := database.EntryCollection.FindOneAndUpdate(ctx, bson.M{"_id": objID}, update, opts).Decode(&updated)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	config.App.Logger.Info().Str("entryID", updated.ID).Str("parentEntryID", updated.ParentEntryID).Str("userID", req.ID).Msg("Entry moved")
+	writeEntry(w, updated)
+}
+
+// CascadeReport lists the entries under a deleted entry that were deleted with it and the ones that couldn't be.
+// The entry is kept while entries under it remain, so repeating the deletion resumes it.
+type CascadeReport struct {
+	Deleted []string `json:"deleted"`
+	Failed  []string `json:"failed"`
+}
+
+// removeFromHierarchy deals with the children of an entry being deleted: they move under the parent of the
+// entry, or they are deleted with all their descendants. Each descendant is deleted on its own, so a failure
+// only keeps it and the entries above it.
+func removeFromHierarchy(ctx context.Context, entry model.Entry, children string) (CascadeReport, error) {
+	report := CascadeReport{Deleted: []string{}, Failed: []string{}}
+	if children == childrenRehome {
+		update := bson.M{"$unset": bson.M{"parent_entry_id": ""}}
+		if entry.ParentEntryID != "" {
+			update = bson.M{"$set": bson.M{"parent_entry_id": entry.ParentEntryID}}
+		}
+		_, err := database.EntryCollection.UpdateMany(ctx, bson.M{"parent_entry_id": entry.ID}, update)
+		return report, err
+	}
+
+	descendants, parents, err := descendantParents(ctx, entry.ID)
+	if err != nil {
+		return report, err
+	}
+	// the deepest go first, so a failure never leaves entries without their parent
+	kept := map[string]bool{}
+	for i := len(descendants) - 1; i >= 0; i-- {
+		id := descendants[i]
+		if !kept[id] {
+			err := deleteDescendant(id)
+			if err == nil {
+				report.Deleted = append(report.Deleted, id)
+				continue
+			}
+			config.App.Logger.Error().Err(err).Str("entryID", id).Msg("Failed to delete a descendant entry")
+		}
+		report.Failed = append(report.Failed, id)
+		for parent := parents[id]; parent != "" && parent != entry.ID; parent = parents[parent] {
+			kept[parent] = true
+		}
+	}
+	return report, nil
+}
+
+// deleteDescendant deletes an entry under a deleted one, with its versions and the redirects to it
+func deleteDescendant(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	var entry model.Entry
+	if err := database.EntryCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&entry); err != nil {
+		return err
+	}
+	if err := deleteEntryVersions(id); err != nil {
+		return err
+	}
+	if _, err := database.EntryCollection.DeleteOne(ctx, bson.M{"_id": objID}); err != nil {
+		return err
+	}
+	if _, err := database.EntryCollection.DeleteMany(ctx, bson.M{"redirect_to": id}); err != nil {
+		config.App.Logger.Error().Err(err).Str("entryID", id).Msg("Failed to delete the redirects to the entry")
+	}
+	config.App.Logger.Info().Str("entryID", id).Msg("Descendant entry deleted")
+	retargetLinks(entry.WikiID, id, append([]string{entry.Title}, entry.Aliases...)...)
+	return nil
+}
+
+// childrenMode parses the children parameter of DeleteEntry, rehome by default
+func childrenMode(r *http.Request) (string, bool) {
+	switch r.URL.Query().Get("children") {
+	case "", childrenRehome:
+		return childrenRehome, true
+	case childrenCascade:
+		return childrenCascade, true
+	}
+	return "", false
+}
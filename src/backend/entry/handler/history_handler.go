@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/laWiki/entry/config"
+	"github.com/laWiki/entry/dto"
+	"github.com/laWiki/entry/model"
+)
+
+// EntryAsOf is an entry as it was at a given moment, with the version that was current then
+type EntryAsOf struct {
+	model.Entry
+	AsOf    time.Time       `json:"as_of"`
+	Version *dto.VersionDTO `json:"version"`
+}
+
+// parseAsOf reads the optional asOf query parameter. The zero time means the present.
+func parseAsOf(r *http.Request) (time.Time, error) {
+	asOf := r.URL.Query().Get("asOf")
+	if asOf == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, asOf)
+}
+
+// entryAt rewinds the title of an entry to the given moment. Nothing is known of entries created later.
+func entryAt(entry model.Entry, asOf time.Time) (model.Entry, bool) {
+	if entry.CreatedAt.After(asOf) {
+		return entry, false
+	}
+
+	entry.Title = entry.TitleAt(asOf)
+	var history []model.TitleChange
+	for _, change := range entry.TitleHistory {
+		if !change.ChangedAt.After(asOf) {
+			history = append(history, change)
+		}
+	}
+	entry.TitleHistory = history
+	return entry, true
+}
+
+// fetchVersionAsOf retrieves the version of an entry that was current at the given moment, nil if there was none
+func fetchVersionAsOf(entryID string, asOf time.Time) (*dto.VersionDTO, error) {
+	versionServiceURL := fmt.Sprintf("%s/api/versions/current?entryID=%s&asOf=%s",
+		config.App.API_GATEWAY_URL, url.QueryEscape(entryID), url.QueryEscape(asOf.Format(time.RFC3339)))
+
+	req, err := http.NewRequest("GET", versionServiceURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Internal-Auth", config.App.JWTSecret)
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("version service returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var version dto.VersionDTO
+	if err := json.NewDecoder(resp.Body).Decode(&version); err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/laWiki/entry/config"
+	"github.com/laWiki/entry/database"
+	"github.com/laWiki/entry/dto"
+	"github.com/laWiki/entry/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fetchBacklinks retrieves from the version service the links of a kind leading to an entry
+func fetchBacklinks(entryID string, kind string) ([]dto.LinkDTO, error) {
+	versionServiceURL := fmt.Sprintf("%s/api/versions/links?targetEntryID=%s&kind=%s",
+		config.App.API_GATEWAY_URL, url.QueryEscape(entryID), url.QueryEscape(kind))
+
+	req, err := http.NewRequest("GET", versionServiceURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Internal-Auth", config.App.JWTSecret)
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("version service returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var links []dto.LinkDTO
+	if err := json.NewDecoder(resp.Body).Decode(&links); err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// GetEntryBacklinks godoc
+// @Summary      Get the entries linking to an entry
+// @Description  Returns the entries of the same wiki whose current version links to the entry, by its title, one of its aliases or a redirect to it.
+// @Tags         Entries
+// @Produce      application/json
+// @Param        id    path      string  true  "Entry ID"
+// @Success      200   {array}   model.Entry
+// @Success      204   {string}  string  "No Content"
+// @Failure      400   {string}  string  "Invalid ID"
+// @Failure      404   {string}  string  "Entry not found"
+// @Failure      500   {string}  string  "Internal server error"
+// @Router       /api/entries/{id}/backlinks [get]
+func GetEntryBacklinks(w http.ResponseWriter, r *http.Request) {
+	writeLinkingEntries(w, r, "link")
+}
+
+// GetTemplateUses godoc
+// @Summary      Get the entries using a template
+// @Description  Returns the entries of the same wiki whose current version includes the template.
+// @Tags         Entries
+// @Produce      application/json
+// @Param        id    path      string  true  "Template entry ID"
+// @Success      200   {array}   model.Entry
+// @Success      204   {string}  string  "No Content"
+// @Failure      400   {string}  string  "Invalid ID"
+// @Failure      404   {string}  string  "Entry not found"
+// @Failure      500   {string}  string  "Internal server error"
+// @Router       /api/entries/{id}/used-by [get]
+func GetTemplateUses(w http.ResponseWriter, r *http.Request) {
+	writeLinkingEntries(w, r, model.KindTemplate)
+}
+
+// writeLinkingEntries writes the entries with links of a kind to the entry of the route as the response
+func writeLinkingEntries(w http.ResponseWriter, r *http.Request, kind string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, entry, ok := loadEntry(w, r, ctx)
+	if !ok {
+		return
+	}
+
+	links, err := fetchBacklinks(entry.ID, kind)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Str("entryID", entry.ID).Msg("Failed to retrieve backlinks")
+		http.Error(w, "Failed to retrieve backlinks", http.StatusInternalServerError)
+		return
+	}
+
+	var sourceIDs []primitive.ObjectID
+	for _, link := range links {
+		// links of an entry to itself aren't backlinks
+		if link.SourceEntryID == entry.ID {
+			continue
+		}
+		objID, err := primitive.ObjectIDFromHex(link.SourceEntryID)
+		if err != nil {
+			config.App.Logger.Warn().Str("entryID", link.SourceEntryID).Msg("Invalid ID in backlink")
+			continue
+		}
+		sourceIDs = append(sourceIDs, objID)
+	}
+	if len(sourceIDs) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	cursor, err := database.EntryCollection.Find(ctx, bson.M{"_id": bson.M{"$in": sourceIDs}})
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var entries []model.Entry
+	if err := cursor.All(ctx, &entries); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode entries")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if len(entries) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
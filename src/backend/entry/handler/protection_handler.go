@@ -0,0 +1,406 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/laWiki/entry/config"
+	"github.com/laWiki/entry/database"
+	"github.com/laWiki/entry/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Headers set by the gateway with the user making the request
+const (
+	userIDHeader   = "X-User-ID"
+	userRoleHeader = "X-User-Role"
+)
+
+// requester is the user making a request, as forwarded by the gateway
+type requester struct {
+	ID       string
+	Role     string
+	Internal bool
+}
+
+func getRequester(r *http.Request) requester {
+	return requester{
+		ID:       r.Header.Get(userIDHeader),
+		Role:     r.Header.Get(userRoleHeader),
+		Internal: r.Header.Get("X-Internal-Auth") == config.App.JWTSecret,
+	}
+}
+
+// canEdit reports whether the requester can edit an entry under its current protection
+func (req requester) canEdit(entry model.Entry) bool {
+	return req.Internal || entry.Protection.AllowsRole(req.Role)
+}
+
+// canProtect reports whether the requester can set or lift a protection of the given level
+func (req requester) canProtect(level string) bool {
+	if req.Internal || req.Role == "admin" {
+		return true
+	}
+	return req.Role == "editor" && level == model.ProtectionSemi
+}
+
+// ProtectionRequest is the body used to protect an entry
+type ProtectionRequest struct {
+	Level     string    `json:"level"`
+	Reason    string    `json:"reason"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// EditLockStatus is an edit lock together with the time it has left
+type EditLockStatus struct {
+	model.EditLock
+	RemainingSeconds int64 `json:"remaining_seconds"`
+}
+
+func newEditLockStatus(lock model.EditLock) EditLockStatus {
+	remaining := time.Until(lock.ExpiresAt)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return EditLockStatus{EditLock: lock, RemainingSeconds: int64(remaining.Seconds())}
+}
+
+// loadEntry parses the ID of the route and retrieves the entry, writing the error response on failure
+func loadEntry(w http.ResponseWriter, r *http.Request, ctx context.Context) (primitive.ObjectID, *model.Entry, bool) {
+	id := chi.URLParam(r, "id")
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Invalid ID format")
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return objID, nil, false
+	}
+
+	var entry model.Entry
+	err = database.EntryCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&entry)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Entry not found")
+		http.Error(w, "Entry not found", http.StatusNotFound)
+		return objID, nil, false
+	}
+	return objID, &entry, true
+}
+
+// writeEntry encodes an entry as the response
+func writeEntry(w http.ResponseWriter, entry model.Entry) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(entry); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// ProtectEntry godoc
+// @Summary      Protect an entry
+// @Description  Restricts who can add versions to an entry or edit it. Semi-protected entries can only be edited by editors and admins, fully protected entries only by admins. Editors can only set semi-protection. The protection lasts until expires_at, or forever if it is not set.
+// @Tags         Entries
+// @Accept       application/json
+// @Produce      application/json
+// @Param        id          path      string             true  "Entry ID"
+// @Param        protection  body      ProtectionRequest  true  "Protection level, reason and expiry"
+// @Success      200         {object}  model.Entry
+// @Failure      400         {string}  string  "Invalid ID or request body"
+// @Failure      403         {string}  string  "Forbidden"
+// @Failure      404         {string}  string  "Entry not found"
+// @Failure      500         {string}  string  "Internal server error"
+// @Router       /api/entries/{id}/protection [put]
+func ProtectEntry(w http.ResponseWriter, r *http.Request) {
+	var body ProtectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode provided request body")
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if body.Level != model.ProtectionSemi && body.Level != model.ProtectionFull {
+		config.App.Logger.Error().Str("level", body.Level).Msg("Invalid protection level")
+		http.Error(w, "Invalid protection level, use 'semi' or 'full'", http.StatusBadRequest)
+		return
+	}
+	if body.Reason == "" {
+		config.App.Logger.Error().Msg("Missing protection reason")
+		http.Error(w, "A reason is required", http.StatusBadRequest)
+		return
+	}
+	if !body.ExpiresAt.IsZero() && body.ExpiresAt.Before(time.Now()) {
+		config.App.Logger.Error().Time("expiresAt", body.ExpiresAt).Msg("Protection expiry in the past")
+		http.Error(w, "The expiry must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objID, entry, ok := loadEntry(w, r, ctx)
+	if !ok {
+		return
+	}
+
+	// an editor can't override a full protection set by an admin
+	req := getRequester(r)
+	if !req.canProtect(body.Level) || (entry.Protection.Active() && !req.canProtect(entry.Protection.Level)) {
+		config.App.Logger.Warn().Str("userID", req.ID).Str("role", req.Role).Str("level", body.Level).Msg("Protection without privileges")
+		http.Error(w, "Forbidden: insufficient privileges", http.StatusForbidden)
+		return
+	}
+
+	protection := model.Protection{
+		Level:     body.Level,
+		Reason:    body.Reason,
+		ExpiresAt: body.ExpiresAt.UTC(),
+		SetBy:     req.ID,
+		SetAt:     time.Now().UTC(),
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var updated model.Entry
+	err := database.EntryCollection.FindOneAndUpdate(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"protection": protection}}, opts).Decode(&updated)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	config.App.Logger.Info().Str("entryID", updated.ID).Str("level", protection.Level).Str("userID", req.ID).Msg("Entry protected")
+	writeEntry(w, updated)
+}
+
+// UnprotectEntry godoc
+// @Summary      Remove the protection of an entry
+// @Description  Lifts the protection of an entry. Only admins can lift a full protection.
+// @Tags         Entries
+// @Produce      application/json
+// @Param        id   path      string  true  "Entry ID"
+// @Success      200  {object}  model.Entry
+// @Failure      400  {string}  string  "Invalid ID"
+// @Failure      403  {string}  string  "Forbidden"
+// @Failure      404  {string}  string  "Entry not found"
+// @Failure      500  {string}  string  "Internal server error"
+// @Router       /api/entries/{id}/protection [delete]
+func UnprotectEntry(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objID, entry, ok := loadEntry(w, r, ctx)
+	if !ok {
+		return
+	}
+
+	req := getRequester(r)
+	if entry.Protection != nil && !req.canProtect(entry.Protection.Level) {
+		config.App.Logger.Warn().Str("userID", req.ID).Str("role", req.Role).Msg("Unprotection without privileges")
+		http.Error(w, "Forbidden: insufficient privileges", http.StatusForbidden)
+		return
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var updated model.Entry
+	err := database.EntryCollection.FindOneAndUpdate(ctx, bson.M{"_id": objID}, bson.M{"$unset": bson.M{"protection": ""}}, opts).Decode(&updated)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	config.App.Logger.Info().Str("entryID", updated.ID).Str("userID", req.ID).Msg("Entry unprotected")
+	writeEntry(w, updated)
+}
+
+// GetEditLock godoc
+// @Summary      Get the edit lock of an entry
+// @Description  Returns who is editing the entry and how long the lock has left.
+// @Tags         Entries
+// @Produce      application/json
+// @Param        id   path      string  true  "Entry ID"
+// @Success      200  {object}  EditLockStatus
+// @Success      204  {string}  string  "No Content"
+// @Failure      400  {string}  string  "Invalid ID"
+// @Failure      404  {string}  string  "Entry not found"
+// @Router       /api/entries/{id}/edit-lock [get]
+func GetEditLock(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, entry, ok := loadEntry(w, r, ctx)
+	if !ok {
+		return
+	}
+
+	if !entry.EditLock.Active() {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeEditLock(w, http.StatusOK, *entry.EditLock)
+}
+
+// EditLockRequest is the optional body used to acquire an edit lock
+type EditLockRequest struct {
+	UserName string `json:"user_name"`
+}
+
+// AcquireEditLock godoc
+// @Summary      Acquire or renew the edit lock of an entry
+// @Description  Tells other users that the requester is editing the entry. The lock is advisory and expires after the configured time; calling again renews it. If someone else holds the lock, their lock is returned with a 409.
+// @Tags         Entries
+// @Accept       application/json
+// @Produce      application/json
+// @Param        id    path      string           true   "Entry ID"
+// @Param        lock  body      EditLockRequest  false  "Name to show to other users"
+// @Success      200   {object}  EditLockStatus
+// @Failure      400   {string}  string  "Invalid ID"
+// @Failure      401   {string}  string  "Unauthorized"
+// @Failure      403   {string}  string  "Forbidden"
+// @Failure      404   {string}  string  "Entry not found"
+// @Failure      409   {object}  EditLockStatus
+// @Failure      500   {string}  string  "Internal server error"
+// @Router       /api/entries/{id}/edit-lock [post]
+func AcquireEditLock(w http.ResponseWriter, r *http.Request) {
+	req := getRequester(r)
+	if req.ID == "" {
+		config.App.Logger.Warn().Msg("Edit lock without identity")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// the body is optional
+	var body EditLockRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			config.App.Logger.Error().Err(err).Msg("Failed to decode provided request body")
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objID, entry, ok := loadEntry(w, r, ctx)
+	if !ok {
+		return
+	}
+
+	// no point in locking an entry the requester can't edit
+	if !req.canEdit(*entry) {
+		config.App.Logger.Warn().Str("entryID", entry.ID).Str("userID", req.ID).Msg("Edit lock on a protected entry")
+		http.Error(w, "Forbidden: the entry is protected", http.StatusForbidden)
+		return
+	}
+
+	now := time.Now().UTC()
+	lock := model.EditLock{
+		UserID:     req.ID,
+		UserName:   body.UserName,
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(config.App.EditLockTTL),
+	}
+	// renewing keeps the original acquisition time
+	if entry.EditLock.Active() && entry.EditLock.UserID == req.ID {
+		lock.AcquiredAt = entry.EditLock.AcquiredAt
+	}
+
+	// the filter makes acquiring atomic: it only matches if the lock is free, expired or already ours
+	filter := bson.M{
+		"_id": objID,
+		"$or": bson.A{
+			bson.M{"edit_lock": nil},
+			bson.M{"edit_lock.user_id": req.ID},
+			bson.M{"edit_lock.expires_at": bson.M{"$lte": now}},
+		},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var updated model.Entry
+	err := database.EntryCollection.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"edit_lock": lock}}, opts).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		var current model.Entry
+		if err := database.EntryCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&current); err != nil || current.EditLock == nil {
+			config.App.Logger.Error().Err(err).Msg("Failed to retrieve the edit lock")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		config.App.Logger.Info().Str("entryID", current.ID).Str("lockedBy", current.EditLock.UserID).Msg("Entry locked by another user")
+		writeEditLock(w, http.StatusConflict, *current.EditLock)
+		return
+	}
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeEditLock(w, http.StatusOK, *updated.EditLock)
+}
+
+// ReleaseEditLock godoc
+// @Summary      Release the edit lock of an entry
+// @Description  Releases the edit lock held by the requester. Admins can release any lock.
+// @Tags         Entries
+// @Param        id   path      string  true  "Entry ID"
+// @Success      204  {string}  string  "No Content"
+// @Failure      400  {string}  string  "Invalid ID"
+// @Failure      403  {string}  string  "Forbidden"
+// @Failure      404  {string}  string  "Entry not found"
+// @Failure      409  {string}  string  "The entry was locked by another user"
+// @Failure      500  {string}  string  "Internal server error"
+// @Router       /api/entries/{id}/edit-lock/release [post]
+func ReleaseEditLock(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objID, entry, ok := loadEntry(w, r, ctx)
+	if !ok {
+		return
+	}
+
+	req := getRequester(r)
+	if entry.EditLock.Active() && entry.EditLock.UserID != req.ID && !req.Internal && req.Role != "admin" {
+		config.App.Logger.Warn().Str("entryID", entry.ID).Str("userID", req.ID).Msg("Release of someone else's edit lock")
+		http.Error(w, "Forbidden: the entry is locked by another user", http.StatusForbidden)
+		return
+	}
+
+	// the filter makes releasing atomic: a lock taken by someone else since it was read isn't released
+	filter := bson.M{"_id": objID}
+	if !req.Internal && req.Role != "admin" {
+		filter["$or"] = bson.A{
+			bson.M{"edit_lock": nil},
+			bson.M{"edit_lock.user_id": req.ID},
+			bson.M{"edit_lock.expires_at": bson.M{"$lte": time.Now().UTC()}},
+		}
+	}
+	result, err := database.EntryCollection.UpdateOne(ctx, filter, bson.M{"$unset": bson.M{"edit_lock": ""}})
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		config.App.Logger.Info().Str("entryID", entry.ID).Str("userID", req.ID).Msg("Entry locked by another user before the release")
+		http.Error(w, "The entry was locked by another user", http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeEditLock(w http.ResponseWriter, status int, lock model.EditLock) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(newEditLockStatus(lock)); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		return
+	}
+}
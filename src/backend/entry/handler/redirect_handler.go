@@ -0,0 +1,286 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/laWiki/entry/config"
+	"github.com/laWiki/entry/database"
+	"github.com/laWiki/entry/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxRedirects is the longest chain of redirects followed before giving up
+const maxRedirects = 10
+
+var (
+	errRedirectLoop   = errors.New("redirect loop")
+	errRedirectBroken = errors.New("redirect to a missing entry")
+)
+
+// ResolvedEntry is the entry a title or slug leads to, with the redirects followed to reach it
+type ResolvedEntry struct {
+	model.Entry
+	Redirected     bool     `json:"redirected"`
+	RedirectedFrom []string `json:"redirected_from,omitempty"`
+	MatchedAlias   string   `json:"matched_alias,omitempty"`
+	MatchedSlug    string   `json:"matched_slug,omitempty"`
+}
+
+// normalizeTitle collapses the whitespace of a title, like the wiki links do
+func normalizeTitle(title string) string {
+	return strings.Join(strings.Fields(title), " ")
+}
+
+// normalizeAliases cleans the aliases of an entry, dropping empty ones, duplicates and its own title
+func normalizeAliases(aliases []string, title string) []string {
+	seen := map[string]bool{normalizeTitle(title): true}
+	normalized := []string{}
+	for _, alias := range aliases {
+		alias = normalizeTitle(alias)
+		if alias == "" || seen[alias] {
+			continue
+		}
+		seen[alias] = true
+		normalized = append(normalized, alias)
+	}
+	return normalized
+}
+
+// findByTitle retrieves the entry of a wiki with the given title, or with the title as an alias
+func findByTitle(ctx context.Context, wikiID string, title string) (*model.Entry, bool, error) {
+	var entry model.Entry
+	err := database.EntryCollection.FindOne(ctx, bson.M{"wiki_id": wikiID, "title": title}).Decode(&entry)
+	if err == nil {
+		return &entry, false, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, false, err
+	}
+
+	err = database.EntryCollection.FindOne(ctx, bson.M{"wiki_id": wikiID, "aliases": title}).Decode(&entry)
+	if err != nil {
+		return nil, false, err
+	}
+	return &entry, true, nil
+}
+
+// followRedirects follows the redirects starting at an entry, returning the entry they lead to and the
+// titles of the redirects followed
+func followRedirects(ctx context.Context, entry model.Entry) (model.Entry, []string, error) {
+	return followChain(entry, func(id string) (*model.Entry, error) {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, nil
+		}
+		var target model.Entry
+		err = database.EntryCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&target)
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &target, nil
+	})
+}
+
+// followChain follows the redirects starting at an entry with find, which returns nil for missing entries.
+// Chains that come back to an entry or are longer than maxRedirects are loops.
+func followChain(entry model.Entry, find func(id string) (*model.Entry, error)) (model.Entry, []string, error) {
+	var chain []string
+	visited := map[string]bool{entry.ID: true}
+	for entry.IsRedirect() {
+		if len(chain) >= maxRedirects {
+			return entry, chain, errRedirectLoop
+		}
+		chain = append(chain, entry.Title)
+
+		target, err := find(entry.RedirectTo)
+		if err != nil {
+			return entry, chain, err
+		}
+		if target == nil {
+			return entry, chain, errRedirectBroken
+		}
+
+		if visited[target.ID] {
+			return *target, chain, errRedirectLoop
+		}
+		visited[target.ID] = true
+		entry = *target
+	}
+	return entry, chain, nil
+}
+
+// checkRedirectTarget verifies that an entry can redirect to the given entry, returning the target
+func checkRedirectTarget(ctx context.Context, entry model.Entry, targetID string) (*model.Entry, error) {
+	if targetID == entry.ID {
+		return nil, errRedirectLoop
+	}
+	objID, err := primitive.ObjectIDFromHex(targetID)
+	if err != nil {
+		return nil, errRedirectBroken
+	}
+	var target model.Entry
+	if err := database.EntryCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&target); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errRedirectBroken
+		}
+		return nil, err
+	}
+	if entry.WikiID != "" && target.WikiID != entry.WikiID {
+		return nil, errRedirectBroken
+	}
+	return &target, nil
+}
+
+// leaveRedirect keeps the old title of a renamed entry pointing to it. A redirect the entry is renamed back
+// to is removed, and no redirect is left if another entry already has the old title.
+func leaveRedirect(ctx context.Context, entry model.Entry, oldTitle string, userID string) error {
+	_, err := database.EntryCollection.DeleteMany(ctx, bson.M{
+		"wiki_id":     entry.WikiID,
+		"title":       entry.Title,
+		"redirect_to": entry.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	count, err := database.EntryCollection.CountDocuments(ctx, bson.M{"wiki_id": entry.WikiID, "title": oldTitle})
+	if err != nil || count > 0 {
+		return err
+	}
+
+	author := userID
+	if author == "" {
+		author = entry.Author
+	}
+	_, err = database.EntryCollection.InsertOne(ctx, model.Entry{
+		Title:      oldTitle,
+		Author:     author,
+		CreatedAt:  time.Now().UTC(),
+		WikiID:     entry.WikiID,
+		RedirectTo: entry.ID,
+	})
+	return err
+}
+
+// retargetLinks asks the version service to resolve again the links to the given titles, and those that led
+// to the entry, once what they lead to has changed. It is best effort: failures are logged.
+func retargetLinks(wikiID string, entryID string, titles ...string) {
+	query := url.Values{}
+	query.Set("wikiID", wikiID)
+	if entryID != "" {
+		query.Set("entryID", entryID)
+	}
+	for _, title := range titles {
+		if title != "" {
+			query.Add("title", title)
+		}
+	}
+	versionServiceURL := fmt.Sprintf("%s/api/versions/links/retarget?%s", config.App.API_GATEWAY_URL, query.Encode())
+
+	req, err := http.NewRequest("POST", versionServiceURL, nil)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to create request to version service")
+		return
+	}
+	req.Header.Set("X-Internal-Auth", config.App.JWTSecret)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to send request to version service")
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		config.App.Logger.Error().Int("status", resp.StatusCode).Str("body", string(bodyBytes)).Msg("Version service failed to retarget the links")
+	}
+}
+
+// ResolveEntry godoc
+// @Summary      Resolve a title
+// @Description  Finds the entry of a wiki with the given title or alias, following redirects. The response tells whether a redirect was followed and the titles it came through.
+// @Tags         Entries
+// @Produce      application/json
+// @Param        wikiID  query     string  true  "Wiki ID"
+// @Param        title   query     string  true  "Title or alias"
+// @Success      200     {object}  ResolvedEntry
+// @Failure      400     {string}  string  "WikiID and title are required"
+// @Failure      404     {string}  string  "Entry not found"
+// @Failure      409     {string}  string  "Redirect loop"
+// @Failure      500     {string}  string  "Internal server error"
+// @Router       /api/entries/resolve [get]
+func ResolveEntry(w http.ResponseWriter, r *http.Request) {
+	wikiID := r.URL.Query().Get("wikiID")
+	title := normalizeTitle(r.URL.Query().Get("title"))
+	if wikiID == "" || title == "" {
+		config.App.Logger.Warn().Msg("Missing wikiID or title parameter")
+		http.Error(w, "WikiID and title are required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	entry, alias, err := findByTitle(ctx, wikiID, title)
+	if err == mongo.ErrNoDocuments {
+		config.App.Logger.Info().Str("wikiID", wikiID).Str("title", title).Msg("Entry not found")
+		http.Error(w, "Entry not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resolved := ResolvedEntry{}
+	if alias {
+		resolved.MatchedAlias = title
+	}
+	writeResolvedEntry(w, ctx, *entry, resolved)
+}
+
+// writeResolvedEntry follows the redirects from the entry found and writes the entry they lead to as the response
+func writeResolvedEntry(w http.ResponseWriter, ctx context.Context, found model.Entry, resolved ResolvedEntry) {
+	target, chain, err := followRedirects(ctx, found)
+	switch {
+	case errors.Is(err, errRedirectLoop):
+		config.App.Logger.Warn().Str("entryID", found.ID).Strs("chain", chain).Msg("Redirect loop")
+		http.Error(w, "Redirect loop", http.StatusConflict)
+		return
+	case errors.Is(err, errRedirectBroken):
+		config.App.Logger.Warn().Str("entryID", found.ID).Strs("chain", chain).Msg("Broken redirect")
+		http.Error(w, "Entry not found", http.StatusNotFound)
+		return
+	case err != nil:
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resolved.Entry = target
+	resolved.RedirectedFrom = chain
+	resolved.Redirected = resolved.Redirected || len(chain) > 0
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resolved); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
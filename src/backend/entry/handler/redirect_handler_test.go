@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/laWiki/entry/model"
+)
+
+func TestFollowChain(t *testing.T) {
+	errLookup := errors.New("lookup failed")
+
+	long := map[string]model.Entry{}
+	for i := 0; i <= maxRedirects+1; i++ {
+		long[fmt.Sprint(i)] = model.Entry{ID: fmt.Sprint(i), Title: fmt.Sprint("R", i), RedirectTo: fmt.Sprint(i + 1)}
+	}
+	long[fmt.Sprint(maxRedirects+2)] = model.Entry{ID: fmt.Sprint(maxRedirects + 2), Title: "Final"}
+
+	tests := []struct {
+		name      string
+		entries   map[string]model.Entry
+		start     string
+		wantID    string
+		wantChain []string
+		wantErr   error
+	}{
+		{
+			name:    "not a redirect",
+			entries: map[string]model.Entry{"a": {ID: "a", Title: "A"}},
+			start:   "a", wantID: "a",
+		},
+		{
+			name: "single redirect",
+			entries: map[string]model.Entry{
+				"a": {ID: "a", Title: "A", RedirectTo: "b"},
+				"b": {ID: "b", Title: "B"},
+			},
+			start: "a", wantID: "b", wantChain: []string{"A"},
+		},
+		{
+			name: "chain",
+			entries: map[string]model.Entry{
+				"a": {ID: "a", Title: "A", RedirectTo: "b"},
+				"b": {ID: "b", Title: "B", RedirectTo: "c"},
+				"c": {ID: "c", Title: "C"},
+			},
+			start: "a", wantID: "c", wantChain: []string{"A", "B"},
+		},
+		{
+			name:    "self redirect",
+			entries: map[string]model.Entry{"a": {ID: "a", Title: "A", RedirectTo: "a"}},
+			start:   "a", wantID: "a", wantChain: []string{"A"}, wantErr: errRedirectLoop,
+		},
+		{
+			name: "loop",
+			entries: map[string]model.Entry{
+				"a": {ID: "a", Title: "A", RedirectTo: "b"},
+				"b": {ID: "b", Title: "B", RedirectTo: "c"},
+				"c": {ID: "c", Title: "C", RedirectTo: "b"},
+			},
+			start: "a", wantID: "b", wantChain: []string{"A", "B", "C"}, wantErr: errRedirectLoop,
+		},
+		{
+			name:    "too long",
+			entries: long,
+			start:   "0", wantID: fmt.Sprint(maxRedirects), wantErr: errRedirectLoop,
+			wantChain: func() []string {
+				var chain []string
+				for i := 0; i < maxRedirects; i++ {
+					chain = append(chain, fmt.Sprint("R", i))
+				}
+				return chain
+			}(),
+		},
+		{
+			name:    "broken",
+			entries: map[string]model.Entry{"a": {ID: "a", Title: "A", RedirectTo: "missing"}},
+			start:   "a", wantID: "a", wantChain: []string{"A"}, wantErr: errRedirectBroken,
+		},
+		{
+			name:    "lookup error",
+			entries: map[string]model.Entry{"a": {ID: "a", Title: "A", RedirectTo: "error"}},
+			start:   "a", wantID: "a", wantChain: []string{"A"}, wantErr: errLookup,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, chain, err := followChain(tt.entries[tt.start], func(id string) (*model.Entry, error) {
+				if id == "error" {
+					return nil, errLookup
+				}
+				entry, ok := tt.entries[id]
+				if !ok {
+					return nil, nil
+				}
+				return &entry, nil
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("followChain() error = %v, want %v", err, tt.wantErr)
+			}
+			if got.ID != tt.wantID {
+				t.Errorf("followChain() = %q, want %q", got.ID, tt.wantID)
+			}
+			if !reflect.DeepEqual(chain, tt.wantChain) {
+				t.Errorf("followChain() chain = %v, want %v", chain, tt.wantChain)
+			}
+		})
+	}
+}
+
+func TestNormalizeAliases(t *testing.T) {
+	tests := []struct {
+		name    string
+		aliases []string
+		title   string
+		want    []string
+	}{
+		{"none", nil, "Madrid", []string{}},
+		{"whitespace", []string{"  Villa   y Corte "}, "Madrid", []string{"Villa y Corte"}},
+		{"empty and duplicates", []string{"", "  ", "Foro", "Foro "}, "Madrid", []string{"Foro"}},
+		{"own title", []string{"Madrid", " Madrid", "Foro"}, "Madrid ", []string{"Foro"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeAliases(tt.aliases, tt.title); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("normalizeAliases() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
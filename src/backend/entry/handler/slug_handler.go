@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/laWiki/entry/config"
+	"github.com/laWiki/entry/database"
+	"github.com/laWiki/entry/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// slugify asks the wiki service, which owns the slug rules, for the slug of a title
+func slugify(title string) (string, error) {
+	var result struct {
+		Slug string `json:"slug"`
+	}
+	err := fetchJSON(fmt.Sprintf("%s/api/wikis/slugify?title=%s", config.App.API_GATEWAY_URL, url.QueryEscape(title)), &result)
+	return result.Slug, err
+}
+
+// entrySlug returns a slug for the title of an entry that no other entry of its wiki uses, now or as an old
+// slug. The slug is numbered with the first suffix that isn't taken.
+func entrySlug(ctx context.Context, wikiID string, title string, entryID primitive.ObjectID) (string, error) {
+	base, err := slugify(title)
+	if err != nil {
+		return "", err
+	}
+	if base == "" {
+		base = "entrada"
+	}
+	slug := base
+	for i := 2; ; i++ {
+		count, err := database.EntryCollection.CountDocuments(ctx, bson.M{
+			"_id":     bson.M{"$ne": entryID},
+			"wiki_id": wikiID,
+			"$or":     bson.A{bson.M{"slug": slug}, bson.M{"old_slugs": slug}},
+		})
+		if err != nil || count == 0 {
+			return slug, err
+		}
+		slug = base + "-" + strconv.Itoa(i)
+	}
+}
+
+// renamedSlugs returns the slug of a renamed entry and its old slugs, which keep leading to it
+func renamedSlugs(ctx context.Context, current model.Entry, title string, entryID primitive.ObjectID) (string, []string, error) {
+	old := append([]string{}, current.OldSlugs...)
+	if current.Slug != "" {
+		old = append(old, current.Slug)
+	}
+
+	slug, err := slugify(title)
+	if err != nil {
+		return "", nil, err
+	}
+	// the entry takes back one of its old slugs
+	for i, oldSlug := range old {
+		if oldSlug == slug {
+			return slug, append(old[:i], old[i+1:]...), nil
+		}
+	}
+	slug, err = entrySlug(ctx, current.WikiID, title, entryID)
+	return slug, old, err
+}
+
+// GetEntryBySlug godoc
+// @Summary      Get an entry by slug
+// @Description  Finds the entry of a wiki with the given slug, following redirects. Old slugs left behind by renames still find the entry, which is reported as a redirect.
+// @Tags         Entries
+// @Produce      application/json
+// @Param        wikiID  query     string  true  "Wiki ID"
+// @Param        slug    query     string  true  "Entry slug"
+// @Success      200     {object}  ResolvedEntry
+// @Failure      400     {string}  string  "WikiID and slug are required"
+// @Failure      404     {string}  string  "Entry not found"
+// @Failure      409     {string}  string  "Redirect loop"
+// @Failure      500     {string}  string  "Internal server error"
+// @Router       /api/entries/slug [get]
+func GetEntryBySlug(w http.ResponseWriter, r *http.Request) {
+	wikiID := r.URL.Query().Get("wikiID")
+	slug := r.URL.Query().Get("slug")
+	if wikiID == "" || slug == "" {
+		config.App.Logger.Warn().Msg("Missing wikiID or slug parameter")
+		http.Error(w, "WikiID and slug are required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resolved := ResolvedEntry{}
+	var entry model.Entry
+	err := database.EntryCollection.FindOne(ctx, bson.M{"wiki_id": wikiID, "slug": slug}).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		err = database.EntryCollection.FindOne(ctx, bson.M{"wiki_id": wikiID, "old_slugs": slug}).Decode(&entry)
+		resolved.Redirected = true
+		resolved.MatchedSlug = slug
+	}
+	if err == mongo.ErrNoDocuments {
+		config.App.Logger.Info().Str("wikiID", wikiID).Str("slug", slug).Msg("Entry not found")
+		http.Error(w, "Entry not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeResolvedEntry(w, ctx, entry, resolved)
+}
+
+// SlugBackfillReport is the result of giving slugs to the entries created before them
+type SlugBackfillReport struct {
+	Entries int      `json:"entries"`
+	Slugs   []string `json:"slugs"`
+}
+
+// BackfillEntrySlugs godoc
+// @Summary      Give slugs to the entries without one
+// @Description  One-off migration that generates the slug of the entries created before slugs, or of those of a wiki. Redirects left by renames don't get one. Only admins can run it.
+// @Tags         Entries
+// @Produce      application/json
+// @Param        wikiID  query     string  false  "Wiki ID to limit the migration to"
+// @Success      200     {object}  SlugBackfillReport
+// @Failure      403     {string}  string  "Forbidden"
+// @Failure      500     {string}  string  "Internal server error"
+// @Router       /api/entries/slugs [post]
+func BackfillEntrySlugs(w http.ResponseWriter, r *http.Request) {
+	req := getRequester(r)
+	if !req.Internal && req.Role != "admin" {
+		config.App.Logger.Warn().Str("userID", req.ID).Str("role", req.Role).Msg("Slug backfill without privileges")
+		http.Error(w, "Forbidden: insufficient privileges", http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	filter := bson.M{"slug": bson.M{"$in": bson.A{"", nil}}, "redirect_to": bson.M{"$in": bson.A{"", nil}}}
+	if wikiID := r.URL.Query().Get("wikiID"); wikiID != "" {
+		filter["wiki_id"] = wikiID
+	}
+	cursor, err := database.EntryCollection.Find(ctx, filter)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var entries []model.Entry
+	if err := cursor.All(ctx, &entries); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode entries")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	report := SlugBackfillReport{Slugs: []string{}}
+	for _, entry := range entries {
+		objID, err := primitive.ObjectIDFromHex(entry.ID)
+		if err != nil {
+			continue
+		}
+		slug, err := entrySlug(ctx, entry.WikiID, entry.Title, objID)
+		if err == nil {
+			_, err = database.EntryCollection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"slug": slug}})
+		}
+		if err != nil {
+			config.App.Logger.Error().Err(err).Str("entryID", entry.ID).Msg("Failed to set the slug of the entry")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		report.Entries++
+		report.Slugs = append(report.Slugs, slug)
+	}
+
+	config.App.Logger.Info().Int("entries", report.Entries).Msg("Entry slugs backfilled")
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// Category places a category of the entries of a wiki in the hierarchy, under its parent. Entries list the
+// names of their categories; categories without parent need no document.
+type Category struct {
+	ID        string    `json:"-" bson:"_id,omitempty"`
+	WikiID    string    `json:"wiki_id" bson:"wiki_id"`
+	Name      string    `json:"name" bson:"name"`
+	Parent    string    `json:"parent,omitempty" bson:"parent,omitempty"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
@@ -4,13 +4,102 @@ import (
 	"time"
 )
 
+// Kinds of entries. Entries without kind are articles.
+const (
+	KindTemplate = "template" // content other entries include with {{Title|param=value}}
+)
+
+// Protection levels of an entry
+const (
+	ProtectionSemi = "semi" // only editors and admins can edit
+	ProtectionFull = "full" // only admins can edit
+)
+
 type Entry struct {
 	ID               string                       `json:"id" bson:"_id,omitempty"`
 	Title            string                       `json:"title" bson:"title"`
+	Slug             string                       `json:"slug,omitempty" bson:"slug,omitempty"`
+	OldSlugs         []string                     `json:"old_slugs,omitempty" bson:"old_slugs,omitempty"`
 	Author           string                       `json:"author" bson:"author"`
 	CreatedAt        time.Time                    `json:"created_at" bson:"created_at"`
 	UpdatedAt        time.Time                    `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
 	WikiID           string                       `bson:"wiki_id" json:"wiki_id"`
 	TranslatedFields map[string]map[string]string `json:"translatedFields,omitempty" bson:"translatedFields,omitempty"`
 	SourceLang       string                       `json:"sourceLang,omitempty" bson:"sourceLang,omitempty"`
+	Protection       *Protection                  `json:"protection,omitempty" bson:"protection,omitempty"`
+	EditLock         *EditLock                    `json:"edit_lock,omitempty" bson:"edit_lock,omitempty"`
+	TitleHistory     []TitleChange                `json:"title_history,omitempty" bson:"title_history,omitempty"`
+	Aliases          []string                     `json:"aliases,omitempty" bson:"aliases,omitempty"`
+	RedirectTo       string                       `json:"redirect_to,omitempty" bson:"redirect_to,omitempty"`
+	Kind             string                       `json:"kind,omitempty" bson:"kind,omitempty"`
+	Attributes       map[string]interface{}       `json:"attributes,omitempty" bson:"attributes,omitempty"`
+	Categories       []string                     `json:"categories,omitempty" bson:"categories,omitempty"`
+	ParentEntryID    string                       `json:"parent_entry_id,omitempty" bson:"parent_entry_id,omitempty"`
+	BrokenLinks      int                          `json:"broken_links,omitempty" bson:"broken_links,omitempty"`
+}
+
+// IsRedirect reports whether the entry only redirects to another entry
+func (e Entry) IsRedirect() bool {
+	return e.RedirectTo != ""
+}
+
+// TitleChange records a change of the title of an entry, oldest first in the history
+type TitleChange struct {
+	Title     string    `json:"title" bson:"title"`
+	Previous  string    `json:"previous" bson:"previous"`
+	ChangedBy string    `json:"changed_by,omitempty" bson:"changed_by,omitempty"`
+	ChangedAt time.Time `json:"changed_at" bson:"changed_at"`
+}
+
+// TitleAt returns the title the entry had at the given time
+func (e Entry) TitleAt(t time.Time) string {
+	title := e.Title
+	for i := len(e.TitleHistory) - 1; i >= 0; i-- {
+		if !e.TitleHistory[i].ChangedAt.After(t) {
+			break
+		}
+		title = e.TitleHistory[i].Previous
+	}
+	return title
+}
+
+// Protection restricts who can edit an entry until it expires
+type Protection struct {
+	Level     string    `json:"level" bson:"level"`
+	Reason    string    `json:"reason" bson:"reason"`
+	ExpiresAt time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	SetBy     string    `json:"set_by" bson:"set_by"`
+	SetAt     time.Time `json:"set_at" bson:"set_at"`
+}
+
+// Active reports whether the protection is in force. Protections without expiry never expire.
+func (p *Protection) Active() bool {
+	return p != nil && (p.ExpiresAt.IsZero() || p.ExpiresAt.After(time.Now()))
+}
+
+// AllowsRole reports whether a user with the given role can edit under the protection
+func (p *Protection) AllowsRole(role string) bool {
+	if !p.Active() {
+		return true
+	}
+	switch p.Level {
+	case ProtectionSemi:
+		return role == "editor" || role == "admin"
+	case ProtectionFull:
+		return role == "admin"
+	}
+	return true
+}
+
+// EditLock is an advisory lock telling other users that someone is editing the entry
+type EditLock struct {
+	UserID     string    `json:"user_id" bson:"user_id"`
+	UserName   string    `json:"user_name,omitempty" bson:"user_name,omitempty"`
+	AcquiredAt time.Time `json:"acquired_at" bson:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at" bson:"expires_at"`
+}
+
+// Active reports whether the lock has not expired yet
+func (l *EditLock) Active() bool {
+	return l != nil && l.ExpiresAt.After(time.Now())
 }
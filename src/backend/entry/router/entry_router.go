@@ -15,6 +15,15 @@ func NewRouter() http.Handler {
 		r.Get("/", handler.GetEntries)
 		r.Post("/", handler.PostEntry)
 		r.Get("/search", handler.SearchEntries)
+		r.Get("/resolve", handler.ResolveEntry)
+		r.Get("/slug", handler.GetEntryBySlug)
+		r.Post("/slugs", handler.BackfillEntrySlugs)
+		r.Get("/tree", handler.GetWikiTree)
+		r.Get("/categories", handler.GetCategories)
+		r.Put("/categories/parent", handler.SetCategoryParent)
+		r.Post("/categories/rename", handler.RenameCategory)
+		r.Post("/categories/merge", handler.MergeCategories)
+		r.Put("/broken-links", handler.SetBrokenLinks)
 
 		r.Delete("/wiki", handler.DeleteEntriesByWikiID)
 
@@ -23,6 +32,18 @@ func NewRouter() http.Handler {
 			r.Put("/", handler.PutEntry)
 			r.Delete("/", handler.DeleteEntry)
 			r.Post("/translate", handler.TranslateEntry)
+			r.Get("/blame", handler.GetEntryBlame)
+			r.Get("/backlinks", handler.GetEntryBacklinks)
+			r.Get("/used-by", handler.GetTemplateUses)
+			r.Get("/infobox", handler.GetEntryInfobox)
+			r.Get("/tree", handler.GetEntryTree)
+			r.Get("/breadcrumbs", handler.GetEntryBreadcrumbs)
+			r.Put("/parent", handler.SetEntryParent)
+			r.Put("/protection", handler.ProtectEntry)
+			r.Delete("/protection", handler.UnprotectEntry)
+			r.Get("/edit-lock", handler.GetEditLock)
+			r.Post("/edit-lock", handler.AcquireEditLock)
+			r.Post("/edit-lock/release", handler.ReleaseEditLock)
 		})
 	})
 
@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Types of the attributes of the entries, as in the infobox schema of the wikis
+const (
+	AttributeText   = "text"
+	AttributeNumber = "number"
+	AttributeDate   = "date"  // YYYY-MM-DD
+	AttributeEntry  = "entry" // ID of another entry of the wiki
+	AttributeMedia  = "media" // ID of a media file
+)
+
+// dateLayout is how dates are stored, so they sort as text
+const dateLayout = "2006-01-02"
+
+// AttributeDef is an attribute of the infobox schema of a wiki
+type AttributeDef struct {
+	Name     string `json:"name"`
+	Label    string `json:"label,omitempty"`
+	Type     string `json:"type"`
+	Required bool   `json:"required,omitempty"`
+}
+
+// ParseAttributes checks the attributes of an entry against the schema of its wiki and converts them to the
+// values stored: numbers as float64 and the rest as strings. Empty values are dropped.
+func ParseAttributes(schema []AttributeDef, attributes map[string]interface{}) (map[string]interface{}, error) {
+	defs := map[string]AttributeDef{}
+	for _, def := range schema {
+		defs[def.Name] = def
+	}
+
+	parsed := map[string]interface{}{}
+	for name, raw := range attributes {
+		def, ok := defs[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown attribute %q", name)
+		}
+		if raw == nil || raw == "" {
+			continue
+		}
+		value, err := ParseAttributeValue(def, raw)
+		if err != nil {
+			return nil, err
+		}
+		parsed[name] = value
+	}
+	for _, def := range schema {
+		if _, ok := parsed[def.Name]; def.Required && !ok {
+			return nil, fmt.Errorf("missing attribute %q", def.Name)
+		}
+	}
+	return parsed, nil
+}
+
+// ParseAttributeValue converts a value, as decoded from JSON or read from a query, to the type of an attribute
+func ParseAttributeValue(def AttributeDef, raw interface{}) (interface{}, error) {
+	switch def.Type {
+	case AttributeNumber:
+		switch v := raw.(type) {
+		case float64:
+			return v, nil
+		case json.Number:
+			return v.Float64()
+		case string:
+			if n, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				return n, nil
+			}
+		}
+		return nil, fmt.Errorf("attribute %q must be a number", def.Name)
+	}
+
+	text, ok := raw.(string)
+	if !ok {
+		return nil, fmt.Errorf("attribute %q must be a string", def.Name)
+	}
+	text = strings.TrimSpace(text)
+	switch def.Type {
+	case AttributeText:
+		return text, nil
+	case AttributeDate:
+		date, err := time.Parse(dateLayout, text)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %q must be a date (YYYY-MM-DD)", def.Name)
+		}
+		return date.Format(dateLayout), nil
+	case AttributeEntry, AttributeMedia:
+		if !primitive.IsValidObjectID(text) {
+			return nil, fmt.Errorf("attribute %q must be an ID", def.Name)
+		}
+		return text, nil
+	}
+	return nil, fmt.Errorf("attribute %q has an unknown type", def.Name)
+}
+
+// AttributeCondition is a condition on an attribute in a search, like attr.year>1900
+type AttributeCondition struct {
+	Name     string
+	Operator string
+	Value    string
+}
+
+var attributeCondition = regexp.MustCompile(`^attr\.([a-z][a-z0-9_]*)(>=|<=|!=|>|<|=)(.*)$`)
+
+// ParseAttributeConditions reads the attribute conditions of a raw query string. They are kept apart from the
+// other parameters because attr.year>1900 has no = for url.ParseQuery to split on.
+func ParseAttributeConditions(rawQuery string) ([]AttributeCondition, error) {
+	var conditions []AttributeCondition
+	for _, param := range strings.Split(rawQuery, "&") {
+		param, err := url.QueryUnescape(param)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(param, "attr.") {
+			continue
+		}
+		match := attributeCondition.FindStringSubmatch(param)
+		if match == nil {
+			return nil, fmt.Errorf("invalid attribute condition %q", param)
+		}
+		conditions = append(conditions, AttributeCondition{Name: match[1], Operator: match[2], Value: match[3]})
+	}
+	return conditions, nil
+}
+
+// GuessAttributeValue types the value of a condition on an attribute not in a schema: numbers are numbers and
+// everything else, dates included, is compared as text
+func GuessAttributeValue(value string) interface{} {
+	if n, err := strconv.ParseFloat(value, 64); err == nil {
+		return n
+	}
+	return value
+}
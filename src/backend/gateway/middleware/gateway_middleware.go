@@ -2,11 +2,14 @@ package middleware
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
 	"runtime/debug"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5/middleware"
@@ -21,6 +24,25 @@ type key int
 
 const requestIDKey key = 0
 
+// Headers set by the gateway so the services know who is calling
+const (
+	UserIDHeader   = "X-User-ID"
+	UserRoleHeader = "X-User-Role"
+)
+
+const jwksURL = "https://www.googleapis.com/oauth2/v3/certs" // URL del JWKS de Google, cambia si es otro proveedor
+
+var (
+	jwksCache     *jwk.Cache
+	jwksCacheOnce sync.Once
+)
+
+// authUser is the part of the auth service user that the gateway needs
+type authUser struct {
+	ID   string `json:"id"`
+	Role string `json:"role"`
+}
+
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Skip authentication for auth routes and health check
@@ -36,6 +58,10 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// identity headers can only be set by the gateway
+		r.Header.Del(UserIDHeader)
+		r.Header.Del(UserRoleHeader)
+
 		config.App.Logger.Debug().Msgf("Authenticating request to: %s", r.URL.Path)
 
 		if strings.Contains(r.URL.Path, "/api/auth") && r.Method == http.MethodPost {
@@ -49,6 +75,12 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			if r.URL.Path != "/api/auth" { // Para no poder ver todos los usuarios sin autenticacion
 				config.App.Logger.Debug().Msg(r.URL.Path)
 				config.App.Logger.Debug().Msg("Request to auth, health or a GET request. Passing without authentication.")
+				// best effort: if the user is logged in, let the services know who is reading
+				if r.Method == http.MethodGet {
+					if user, err := identify(r); err == nil {
+						setIdentity(r, user)
+					}
+				}
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -74,34 +106,7 @@ func AuthMiddleware(next http.Handler) http.Handler {
 		tokenString := cookie.Value
 
 		// Parse and validate the token using RS256 and public key
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Ensure the signing method is RS256
-			if token.Method.Alg() != jwt.SigningMethodRS256.Alg() {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Method.Alg())
-			}
-
-			// Get the public key from JWKS
-			jwksURL := "https://www.googleapis.com/oauth2/v3/certs" // URL del JWKS de Google, cambia si es otro proveedor
-			keySet, err := jwk.Fetch(r.Context(), jwksURL)
-			if err != nil {
-				return nil, fmt.Errorf("failed to fetch public keys: %v", err)
-			}
-
-			// Find the key with the appropriate kid (Key ID) from the token header
-			kid := token.Header["kid"].(string)
-			key, ok := keySet.LookupKeyID(kid)
-			if !ok {
-				return nil, fmt.Errorf("unable to find appropriate key")
-			}
-
-			// Return the public key to verify the token
-			var pubKey interface{}
-			err = key.Raw(&pubKey)
-			if err != nil {
-				return nil, fmt.Errorf("failed to extract key: %v", err)
-			}
-			return pubKey, nil
-		})
+		token, err := parseToken(r.Context(), tokenString)
 		if err != nil {
 			http.Error(w, "Unauthorized: invalid token", http.StatusUnauthorized)
 			return
@@ -120,44 +125,16 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			email := claims["email"].(string)
 
 			// Check if the user has the correct role for the route, calling auth service
-			url := fmt.Sprintf("%s/api/auth/role?email=%s", config.App.ApiGatewayURL, email)
-
-			req, err := http.NewRequest(http.MethodGet, url, nil)
-
-			if err != nil {
-				http.Error(w, "Unauthorized: invalid token claims", http.StatusUnauthorized)
-				return
-			}
-
-			req.Header.Set("X-Internal-Auth", config.App.JWTSecret)
-			req.Header.Set("Content-Type", "application/json")
-
-			client := &http.Client{
-				Timeout: 10 * time.Second,
-			}
-			resp, err := client.Do(req)
-
+			user, err := lookupUser(email)
 			if err != nil {
 				http.Error(w, "Unauthorized: invalid token claims", http.StatusUnauthorized)
 				// debug this more
 				config.App.Logger.Error().Err(err).Msg("Error calling auth service")
 				return
 			}
+			setIdentity(r, user)
 
-			defer resp.Body.Close()
-
-			if resp.StatusCode != http.StatusOK {
-				http.Error(w, "Unauthorized: invalid token claims", http.StatusUnauthorized)
-				return
-			}
-			roleBytes, err := io.ReadAll(resp.Body)
-			if err != nil {
-				http.Error(w, "Error reading response from auth service", http.StatusInternalServerError)
-				return
-			}
-
-			role := string(roleBytes)
-			role = strings.TrimSpace(role)
+			role := user.Role
 
 			if role == "redactor" {
 				if strings.Contains(r.URL.Path, "/api/entries") || strings.Contains(r.URL.Path, "/api/comments") || strings.Contains(r.URL.Path, "/api/media") || strings.Contains(r.URL.Path, "/api/versions") || strings.Contains(r.URL.Path, "/api/auth") {
@@ -204,6 +181,106 @@ func AuthMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// parseToken parses and validates a Google JWT using RS256 and the public keys of the JWKS
+func parseToken(ctx context.Context, tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		// Ensure the signing method is RS256
+		if token.Method.Alg() != jwt.SigningMethodRS256.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Method.Alg())
+		}
+
+		// Get the public key from JWKS, the key set is cached and refreshed in the background
+		jwksCacheOnce.Do(func() {
+			jwksCache = jwk.NewCache(context.Background())
+			jwksCache.Register(jwksURL)
+		})
+		keySet, err := jwksCache.Get(ctx, jwksURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch public keys: %v", err)
+		}
+
+		// Find the key with the appropriate kid (Key ID) from the token header
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keySet.LookupKeyID(kid)
+		if !ok {
+			return nil, fmt.Errorf("unable to find appropriate key")
+		}
+
+		// Return the public key to verify the token
+		var pubKey interface{}
+		err = key.Raw(&pubKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract key: %v", err)
+		}
+		return pubKey, nil
+	})
+}
+
+// lookupUser gets the ID and role of a user from the auth service
+func lookupUser(email string) (*authUser, error) {
+	url := fmt.Sprintf("%s/api/auth/user/email?email=%s", config.App.ApiGatewayURL, neturl.QueryEscape(email))
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("X-Internal-Auth", config.App.JWTSecret)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("auth service returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var user authUser
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return nil, err
+	}
+	user.Role = strings.TrimSpace(user.Role)
+	return &user, nil
+}
+
+// identify returns the logged in user of the request, if any
+func identify(r *http.Request) (*authUser, error) {
+	cookie, err := r.Cookie("jwt_token")
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := parseToken(r.Context(), cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+
+	email, ok := claims["email"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing email claim")
+	}
+
+	return lookupUser(email)
+}
+
+// setIdentity forwards the ID and role of the user to the services
+func setIdentity(r *http.Request, user *authUser) {
+	r.Header.Set(UserIDHeader, user.ID)
+	r.Header.Set(UserRoleHeader, user.Role)
+}
+
 // RequestID is a middleware that injects a request ID into the context
 func RequestID(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
@@ -26,8 +26,24 @@ type GlobalConfig struct {
 
 // VersionConfig holds the configuration specific to the version service
 type VersionConfig struct {
-	Port             int    `toml:"PORT"`
-	DBCollectionName string `toml:"DB_COLLECTION_NAME"`
+	Port                       int    `toml:"PORT"`
+	DBCollectionName           string `toml:"DB_COLLECTION_NAME"`
+	LinkCollectionName         string `toml:"LINK_COLLECTION_NAME"`
+	ReviewSLAHours             int    `toml:"REVIEW_SLA_HOURS"`
+	ReviewClaimMinutes         int    `toml:"REVIEW_CLAIM_MINUTES"`
+	SnapshotInterval           int    `toml:"SNAPSHOT_INTERVAL"`
+	PruneIntervalHours         int    `toml:"PRUNE_INTERVAL_HOURS"`
+	PruneDryRun                *bool  `toml:"PRUNE_DRY_RUN"`
+	RenderCacheSize            int    `toml:"RENDER_CACHE_SIZE"`
+	PlaceCollectionName        string `toml:"PLACE_COLLECTION_NAME"`
+	Geocoder                   string `toml:"GEOCODER"`
+	GazetteerPath              string `toml:"GAZETTEER_PATH"`
+	GeocoderURL                string `toml:"GEOCODER_URL"`
+	CitationCollectionName     string `toml:"CITATION_COLLECTION_NAME"`
+	ExternalLinkCollectionName string `toml:"EXTERNAL_LINK_COLLECTION_NAME"`
+	LinkCheckIntervalHours     int    `toml:"LINK_CHECK_INTERVAL_HOURS"`
+	LinkCheckWorkers           int    `toml:"LINK_CHECK_WORKERS"`
+	LinkCheckHostDelayMs       int    `toml:"LINK_CHECK_HOST_DELAY_MS"`
 }
 
 // Config represents the structure of the config.toml file
@@ -37,19 +53,35 @@ type Config struct {
 }
 
 type AppConfig struct {
-	Logger           *zerolog.Logger
-	Port             string
-	PrettyLogs       bool
-	Debug            bool
-	MongoDBURI       string
-	DBCollectionName string
-	DBName           string
-	API_GATEWAY_URL  string
-	DeepLKey         string
-	JWTSecret        string
-	MailSenderAPIKey string
-	MailSenderDomain string
-	MailSenderName   string
+	Logger                 *zerolog.Logger
+	Port                   string
+	PrettyLogs             bool
+	Debug                  bool
+	MongoDBURI             string
+	DBCollectionName       string
+	LinkCollection         string
+	PlaceCollection        string
+	CitationCollection     string
+	ExternalLinkCollection string
+	DBName                 string
+	API_GATEWAY_URL        string
+	DeepLKey               string
+	JWTSecret              string
+	MailSenderAPIKey       string
+	MailSenderDomain       string
+	MailSenderName         string
+	ReviewSLA              time.Duration
+	ReviewClaimTTL         time.Duration
+	SnapshotInterval       int
+	PruneInterval          time.Duration
+	PruneDryRun            bool
+	RenderCacheSize        int
+	Geocoder               string
+	GazetteerPath          string
+	GeocoderURL            string
+	LinkCheckInterval      time.Duration
+	LinkCheckWorkers       int
+	LinkCheckHostDelay     time.Duration
 }
 
 // App holds app configuration
@@ -116,6 +148,130 @@ func (cfg *AppConfig) LoadConfig(configPath string) {
 		cfg.DBCollectionName = "versiones" // Default to "wikis"
 		log.Warn().Msg("DBCOLLECTIONNAME not set in config file. Using default 'wiki'.")
 	}
+	// LINK_COLLECTION_NAME with default value
+	if config.Version.LinkCollectionName != "" {
+		cfg.LinkCollection = config.Version.LinkCollectionName
+	} else {
+		cfg.LinkCollection = "enlaces" // Default to "enlaces"
+		log.Warn().Msg("LINK_COLLECTION_NAME not set in config file. Using default 'enlaces'.")
+	}
+
+	// PLACE_COLLECTION_NAME with default value
+	if config.Version.PlaceCollectionName != "" {
+		cfg.PlaceCollection = config.Version.PlaceCollectionName
+	} else {
+		cfg.PlaceCollection = "lugares" // Default to "lugares"
+		log.Warn().Msg("PLACE_COLLECTION_NAME not set in config file. Using default 'lugares'.")
+	}
+
+	// CITATION_COLLECTION_NAME with default value
+	if config.Version.CitationCollectionName != "" {
+		cfg.CitationCollection = config.Version.CitationCollectionName
+	} else {
+		cfg.CitationCollection = "citas" // Default to "citas"
+		log.Warn().Msg("CITATION_COLLECTION_NAME not set in config file. Using default 'citas'.")
+	}
+
+	// EXTERNAL_LINK_COLLECTION_NAME with default value
+	if config.Version.ExternalLinkCollectionName != "" {
+		cfg.ExternalLinkCollection = config.Version.ExternalLinkCollectionName
+	} else {
+		cfg.ExternalLinkCollection = "enlaces_externos" // Default to "enlaces_externos"
+		log.Warn().Msg("EXTERNAL_LINK_COLLECTION_NAME not set in config file. Using default 'enlaces_externos'.")
+	}
+
+	// REVIEW_SLA_HOURS with default value
+	if config.Version.ReviewSLAHours > 0 {
+		cfg.ReviewSLA = time.Duration(config.Version.ReviewSLAHours) * time.Hour
+	} else {
+		cfg.ReviewSLA = 48 * time.Hour // Default to two days
+		log.Warn().Msg("REVIEW_SLA_HOURS not set in config file. Using default '48'.")
+	}
+
+	// REVIEW_CLAIM_MINUTES with default value
+	if config.Version.ReviewClaimMinutes > 0 {
+		cfg.ReviewClaimTTL = time.Duration(config.Version.ReviewClaimMinutes) * time.Minute
+	} else {
+		cfg.ReviewClaimTTL = 30 * time.Minute // Default to half an hour
+		log.Warn().Msg("REVIEW_CLAIM_MINUTES not set in config file. Using default '30'.")
+	}
+
+	// SNAPSHOT_INTERVAL with default value
+	if config.Version.SnapshotInterval > 0 {
+		cfg.SnapshotInterval = config.Version.SnapshotInterval
+	} else {
+		cfg.SnapshotInterval = 20 // Default to a full snapshot every 20 versions
+		log.Warn().Msg("SNAPSHOT_INTERVAL not set in config file. Using default '20'.")
+	}
+
+	// PRUNE_INTERVAL_HOURS with default value
+	if config.Version.PruneIntervalHours > 0 {
+		cfg.PruneInterval = time.Duration(config.Version.PruneIntervalHours) * time.Hour
+	} else {
+		cfg.PruneInterval = 24 * time.Hour // Default to once a day
+		log.Warn().Msg("PRUNE_INTERVAL_HOURS not set in config file. Using default '24'.")
+	}
+
+	// PRUNE_DRY_RUN with default value
+	if config.Version.PruneDryRun != nil {
+		cfg.PruneDryRun = *config.Version.PruneDryRun
+	} else {
+		cfg.PruneDryRun = true // Default to only reporting what would be pruned
+		log.Warn().Msg("PRUNE_DRY_RUN not set in config file. Using default 'true'.")
+	}
+
+	// RENDER_CACHE_SIZE with default value
+	if config.Version.RenderCacheSize > 0 {
+		cfg.RenderCacheSize = config.Version.RenderCacheSize
+	} else {
+		cfg.RenderCacheSize = 1000 // Default to 1000 rendered contents
+		log.Warn().Msg("RENDER_CACHE_SIZE not set in config file. Using default '1000'.")
+	}
+
+	// LINK_CHECK_INTERVAL_HOURS with default value
+	if config.Version.LinkCheckIntervalHours > 0 {
+		cfg.LinkCheckInterval = time.Duration(config.Version.LinkCheckIntervalHours) * time.Hour
+	} else {
+		cfg.LinkCheckInterval = 24 * time.Hour // Default to once a day
+		log.Warn().Msg("LINK_CHECK_INTERVAL_HOURS not set in config file. Using default '24'.")
+	}
+
+	// LINK_CHECK_WORKERS with default value
+	if config.Version.LinkCheckWorkers > 0 {
+		cfg.LinkCheckWorkers = config.Version.LinkCheckWorkers
+	} else {
+		cfg.LinkCheckWorkers = 8 // Default to 8 links probed at once
+		log.Warn().Msg("LINK_CHECK_WORKERS not set in config file. Using default '8'.")
+	}
+
+	// LINK_CHECK_HOST_DELAY_MS with default value
+	if config.Version.LinkCheckHostDelayMs > 0 {
+		cfg.LinkCheckHostDelay = time.Duration(config.Version.LinkCheckHostDelayMs) * time.Millisecond
+	} else {
+		cfg.LinkCheckHostDelay = time.Second // Default to a request per second to each host
+		log.Warn().Msg("LINK_CHECK_HOST_DELAY_MS not set in config file. Using default '1000'.")
+	}
+
+	// GEOCODER with default value
+	switch config.Version.Geocoder {
+	case "gazetteer":
+		if config.Version.GazetteerPath == "" {
+			missingVars = append(missingVars, "GAZETTEER_PATH")
+		}
+	case "nominatim":
+		if config.Version.GeocoderURL == "" {
+			missingVars = append(missingVars, "GEOCODER_URL")
+		}
+	case "", "none":
+		config.Version.Geocoder = "none" // Default to no geocoding
+		log.Warn().Msg("GEOCODER not set in config file. Using default 'none'.")
+	default:
+		log.Error().Msgf("Unknown GEOCODER '%s'. Use 'none', 'gazetteer' or 'nominatim'.", config.Version.Geocoder)
+		os.Exit(1)
+	}
+	cfg.Geocoder = config.Version.Geocoder
+	cfg.GazetteerPath = config.Version.GazetteerPath
+	cfg.GeocoderURL = config.Version.GeocoderURL
 
 	// MONGODB_URI is required
 	if config.Global.MongoDBURI != "" {
@@ -5,13 +5,18 @@ import (
 	"time"
 
 	"github.com/laWiki/version/config"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var (
-	Client            *mongo.Client
-	VersionCollection *mongo.Collection
+	Client                 *mongo.Client
+	VersionCollection      *mongo.Collection
+	LinkCollection         *mongo.Collection
+	PlaceCollection        *mongo.Collection
+	CitationCollection     *mongo.Collection
+	ExternalLinkCollection *mongo.Collection
 )
 
 func Connect() {
@@ -32,5 +37,24 @@ func Connect() {
 
 	Client = client
 	VersionCollection = client.Database(config.App.DBName).Collection(config.App.DBCollectionName)
+	LinkCollection = client.Database(config.App.DBName).Collection(config.App.LinkCollection)
+	PlaceCollection = client.Database(config.App.DBName).Collection(config.App.PlaceCollection)
+	CitationCollection = client.Database(config.App.DBName).Collection(config.App.CitationCollection)
+	ExternalLinkCollection = client.Database(config.App.DBName).Collection(config.App.ExternalLinkCollection)
+
+	// near and bounding box queries need a geospatial index
+	_, err = PlaceCollection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "location", Value: "2dsphere"}}})
+	if err != nil {
+		config.App.Logger.Fatal().Err(err).Msg("Failed to create the geospatial index")
+	}
+	// tag names are unique among the versions of an entry, the index makes tagging atomic
+	_, err = VersionCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "entry_id", Value: 1}, {Key: "tags.name", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"tags.name": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to create the index of the tags, tag names aren't enforced unique")
+	}
 	config.App.Logger.Info().Msg("Connected to mongoDB")
 }
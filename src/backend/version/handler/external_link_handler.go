@@ -0,0 +1,391 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/laWiki/version/config"
+	"github.com/laWiki/version/database"
+	"github.com/laWiki/version/model"
+	"github.com/laWiki/version/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// linkHistorySize is the number of checks kept in the history of an external link
+const linkHistorySize = 10
+
+// linkCheckClient is the client the link checker probes with. It only reaches public addresses.
+var linkCheckClient = utils.NewPublicClient(10 * time.Second)
+
+// refreshExternalLinks records the external links of the current version of an entry, from its rendered
+// content and its references, and updates the broken link flag of the entry
+func refreshExternalLinks(ctx context.Context, entryID string) error {
+	if _, err := database.ExternalLinkCollection.UpdateMany(ctx, bson.M{"entry_ids": entryID}, bson.M{"$pull": bson.M{"entry_ids": entryID}}); err != nil {
+		return err
+	}
+
+	version, err := currentVersion(ctx, entryID)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	if version != nil {
+		wikiID, err := versionWikiID(*version)
+		if err != nil {
+			return err
+		}
+		content := version.HTML
+		if content == "" {
+			content = version.Content
+		}
+		links := utils.ExternalLinks(content)
+		for _, ref := range version.References {
+			if link, ok := utils.ExternalLink(ref.URL); ok {
+				links = append(links, link)
+			}
+		}
+
+		now := time.Now().UTC()
+		opts := options.Update().SetUpsert(true)
+		for _, link := range links {
+			update := bson.M{"$addToSet": bson.M{"entry_ids": entryID}, "$setOnInsert": bson.M{"found_at": now}}
+			if _, err := database.ExternalLinkCollection.UpdateOne(ctx, bson.M{"wiki_id": wikiID, "url": link}, update, opts); err != nil {
+				return err
+			}
+		}
+	}
+
+	// links no entry leads to anymore aren't checked
+	if _, err := database.ExternalLinkCollection.DeleteMany(ctx, bson.M{"entry_ids": bson.M{"$size": 0}}); err != nil {
+		return err
+	}
+	return updateBrokenLinkFlags(ctx, []string{entryID})
+}
+
+// updateBrokenLinkFlags sends the entry service the number of broken external links of the entries
+func updateBrokenLinkFlags(ctx context.Context, entryIDs []string) error {
+	if len(entryIDs) == 0 {
+		return nil
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"entry_ids": bson.M{"$in": entryIDs}, "status.broken": true}}},
+		{{Key: "$unwind", Value: "$entry_ids"}},
+		{{Key: "$match", Value: bson.M{"entry_ids": bson.M{"$in": entryIDs}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$entry_ids", "broken": bson.M{"$sum": 1}}}},
+	}
+	cursor, err := database.ExternalLinkCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		EntryID string `bson:"_id"`
+		Broken  int    `bson:"broken"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return err
+	}
+	counts := map[string]int{}
+	for _, entryID := range entryIDs {
+		counts[entryID] = 0
+	}
+	for _, group := range groups {
+		counts[group.EntryID] = group.Broken
+	}
+
+	body, err := json.Marshal(counts)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/api/entries/broken-links", config.App.API_GATEWAY_URL)
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Internal-Auth", config.App.JWTSecret)
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("PUT %s returned %d: %s", url, resp.StatusCode, string(bodyBytes))
+	}
+	return nil
+}
+
+// LinkCheckReport is the result of a run of the link checker
+type LinkCheckReport struct {
+	WikiID     string    `json:"wiki_id,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Links      int       `json:"links"`
+	Checked    int       `json:"checked"`
+	Broken     int       `json:"broken"`
+	Entries    int       `json:"entries"`
+	Errors     []string  `json:"errors,omitempty"`
+}
+
+var (
+	linkCheckMutex sync.Mutex
+	lastLinkCheck  *LinkCheckReport
+)
+
+// RunLinkChecker checks the external links periodically until the context is done
+func RunLinkChecker(ctx context.Context) {
+	ticker := time.NewTicker(config.App.LinkCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checker := utils.NewLinkChecker(linkCheckClient, config.App.LinkCheckWorkers, config.App.LinkCheckHostDelay)
+			report := checkExternalLinks(ctx, checker, "")
+			config.App.Logger.Info().Int("links", report.Links).Int("checked", report.Checked).Int("broken", report.Broken).Int("errors", len(report.Errors)).Msg("External links checked")
+		}
+	}
+}
+
+// checkExternalLinks probes the external links of every wiki, or only of the given one, and records their
+// status. Each URL is probed once even if several wikis link to it. Runs never overlap.
+func checkExternalLinks(ctx context.Context, checker *utils.LinkChecker, wikiID string) LinkCheckReport {
+	linkCheckMutex.Lock()
+	defer linkCheckMutex.Unlock()
+
+	report := LinkCheckReport{WikiID: wikiID, StartedAt: time.Now().UTC()}
+	defer func() {
+		report.FinishedAt = time.Now().UTC()
+		lastLinkCheck = &report
+	}()
+
+	filter := bson.M{}
+	if wikiID != "" {
+		filter["wiki_id"] = wikiID
+	}
+	findCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	cursor, err := database.ExternalLinkCollection.Find(findCtx, filter, options.Find().SetProjection(bson.M{"url": 1, "entry_ids": 1}))
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return report
+	}
+	var links []model.ExternalLink
+	err = cursor.All(findCtx, &links)
+	cursor.Close(findCtx)
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return report
+	}
+
+	var urls []string
+	seen := map[string]bool{}
+	entries := map[string]bool{}
+	for _, link := range links {
+		if !seen[link.URL] {
+			seen[link.URL] = true
+			urls = append(urls, link.URL)
+		}
+		for _, entryID := range link.EntryIDs {
+			entries[entryID] = true
+		}
+	}
+	report.Links = len(urls)
+
+	for _, status := range checker.Check(ctx, urls) {
+		// links left unchecked when the run is stopped keep their status
+		if ctx.Err() != nil && status.StatusCode == 0 && !status.Broken {
+			continue
+		}
+		report.Checked++
+		if status.Broken {
+			report.Broken++
+		}
+		if err := recordLinkCheck(status, wikiID); err != nil {
+			config.App.Logger.Error().Err(err).Str("url", status.URL).Msg("Failed to record the status of the link")
+			report.Errors = append(report.Errors, fmt.Sprintf("link %s: %v", status.URL, err))
+		}
+	}
+
+	entryIDs := make([]string, 0, len(entries))
+	for entryID := range entries {
+		entryIDs = append(entryIDs, entryID)
+	}
+	report.Entries = len(entryIDs)
+	flagCtx, cancelFlags := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelFlags()
+	if err := updateBrokenLinkFlags(flagCtx, entryIDs); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to update the broken link flags of the entries")
+		report.Errors = append(report.Errors, fmt.Sprintf("flags: %v", err))
+	}
+	return report
+}
+
+// recordLinkCheck stores the result of a check of a link in the links to the URL, of a wiki or of all
+func recordLinkCheck(status utils.LinkStatus, wikiID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"url": status.URL}
+	if wikiID != "" {
+		filter["wiki_id"] = wikiID
+	}
+	check := model.LinkCheck{StatusCode: status.StatusCode, Error: status.Error, Broken: status.Broken, CheckedAt: status.CheckedAt}
+	update := bson.M{
+		"$set":  bson.M{"status": check},
+		"$push": bson.M{"history": bson.M{"$each": bson.A{check}, "$slice": -linkHistorySize}},
+	}
+	if !status.Broken {
+		update["$unset"] = bson.M{"broken_since": ""}
+	}
+	if _, err := database.ExternalLinkCollection.UpdateMany(ctx, filter, update); err != nil {
+		return err
+	}
+	if status.Broken {
+		filter["broken_since"] = bson.M{"$exists": false}
+		if _, err := database.ExternalLinkCollection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"broken_since": status.CheckedAt}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckExternalLinks godoc
+// @Summary      Check the external links
+// @Description  Probes now the external links of the current versions of every entry, or of the entries of a wiki, and records their status. Only admins can run it.
+// @Tags         Links
+// @Produce      application/json
+// @Param        wikiID  query     string  false  "Wiki ID to limit the check to"
+// @Success      200     {object}  LinkCheckReport
+// @Failure      403     {string}  string  "Forbidden"
+// @Router       /api/versions/external-links/check [post]
+func CheckExternalLinks(w http.ResponseWriter, r *http.Request) {
+	wikiID := r.URL.Query().Get("wikiID")
+
+	req := getRequester(r)
+	if !req.Internal && req.Role != "admin" {
+		config.App.Logger.Warn().Str("userID", req.ID).Str("role", req.Role).Msg("Link check without privileges")
+		http.Error(w, "Forbidden: insufficient privileges", http.StatusForbidden)
+		return
+	}
+
+	checker := utils.NewLinkChecker(linkCheckClient, config.App.LinkCheckWorkers, config.App.LinkCheckHostDelay)
+	report := checkExternalLinks(r.Context(), checker, wikiID)
+	config.App.Logger.Info().Str("wikiID", wikiID).Int("links", report.Links).Int("checked", report.Checked).Int("broken", report.Broken).Int("errors", len(report.Errors)).Msg("External links checked")
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetLastLinkCheck godoc
+// @Summary      Last link check report
+// @Description  Returns the report of the last run of the link checker, scheduled or manual.
+// @Tags         Links
+// @Produce      application/json
+// @Success      200  {object}  LinkCheckReport
+// @Success      204  {string}  string  "No Content"
+// @Router       /api/versions/external-links/check/last [get]
+func GetLastLinkCheck(w http.ResponseWriter, r *http.Request) {
+	linkCheckMutex.Lock()
+	report := lastLinkCheck
+	linkCheckMutex.Unlock()
+
+	if report == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetExternalLinks godoc
+// @Summary      External links of a wiki
+// @Description  Lists the external links of the current versions of the entries of a wiki with their status and the history of their checks, broken ones first. With broken, only the broken links; with entryID, only the links of an entry. Links not checked yet have no status.
+// @Tags         Links
+// @Produce      application/json
+// @Param        wikiID   query     string  true   "Wiki ID"
+// @Param        broken   query     bool    false  "Only the broken links"
+// @Param        entryID  query     string  false  "Only the links of an entry"
+// @Success      200      {array}   model.ExternalLink
+// @Success      204      {string}  string  "No Content"
+// @Failure      400      {string}  string  "WikiID is required"
+// @Failure      500      {string}  string  "Internal server error"
+// @Router       /api/versions/external-links [get]
+func GetExternalLinks(w http.ResponseWriter, r *http.Request) {
+	wikiID := r.URL.Query().Get("wikiID")
+	if wikiID == "" {
+		config.App.Logger.Warn().Msg("Missing wikiID parameter")
+		http.Error(w, "WikiID is required", http.StatusBadRequest)
+		return
+	}
+
+	filter := bson.M{"wiki_id": wikiID}
+	if r.URL.Query().Get("broken") == "true" {
+		filter["status.broken"] = true
+	}
+	if entryID := r.URL.Query().Get("entryID"); entryID != "" {
+		filter["entry_ids"] = entryID
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := database.ExternalLinkCollection.Find(ctx, filter)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var links []model.ExternalLink
+	if err := cursor.All(ctx, &links); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode external links")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if len(links) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	broken := func(link model.ExternalLink) bool { return link.Status != nil && link.Status.Broken }
+	sort.Slice(links, func(i, j int) bool {
+		if broken(links[i]) != broken(links[j]) {
+			return broken(links[i])
+		}
+		return links[i].URL < links[j].URL
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(links); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,479 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/laWiki/version/config"
+	"github.com/laWiki/version/database"
+	"github.com/laWiki/version/model"
+	"github.com/laWiki/version/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	geocoder     utils.Geocoder
+	geocoderOnce sync.Once
+)
+
+// getGeocoder returns the geocoder of the configuration, nil if addresses aren't geocoded
+func getGeocoder() utils.Geocoder {
+	geocoderOnce.Do(func() {
+		switch config.App.Geocoder {
+		case "gazetteer":
+			gazetteer, err := utils.LoadGazetteer(config.App.GazetteerPath)
+			if err != nil {
+				config.App.Logger.Error().Err(err).Str("path", config.App.GazetteerPath).Msg("Failed to load the gazetteer, addresses won't be geocoded")
+				return
+			}
+			config.App.Logger.Info().Int("places", gazetteer.Len()).Msg("Gazetteer loaded")
+			geocoder = gazetteer
+		case "nominatim":
+			geocoder = &utils.Nominatim{
+				BaseURL:   config.App.GeocoderURL,
+				UserAgent: "laWiki version service",
+				Client:    &http.Client{Timeout: 5 * time.Second},
+			}
+		}
+	})
+	return geocoder
+}
+
+// geocodeAddress returns the location of an address, nil if it can't be placed. It is best effort: failures
+// are logged, and RebuildPlaces geocodes the versions again.
+func geocodeAddress(address string) *model.GeoPoint {
+	g := getGeocoder()
+	if g == nil || strings.TrimSpace(address) == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	coordinates, err := g.Geocode(ctx, address)
+	if errors.Is(err, utils.ErrAddressNotFound) {
+		config.App.Logger.Info().Str("address", address).Msg("Address not found by the geocoder")
+		return nil
+	}
+	if err != nil {
+		config.App.Logger.Error().Err(err).Str("address", address).Msg("Failed to geocode the address")
+		return nil
+	}
+	return model.NewGeoPoint(coordinates.Lat, coordinates.Lon)
+}
+
+// refreshPlace replaces the place of an entry with the location of its current version
+func refreshPlace(ctx context.Context, entryID string) error {
+	version, err := currentSource(ctx, entryID)
+	if err == mongo.ErrNoDocuments || (err == nil && version.Location == nil) {
+		_, err = database.PlaceCollection.DeleteMany(ctx, bson.M{"entry_id": entryID})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	wikiID, err := versionWikiID(*version)
+	if err != nil {
+		return err
+	}
+
+	place := model.Place{
+		EntryID:   entryID,
+		WikiID:    wikiID,
+		VersionID: version.ID,
+		Address:   version.Address,
+		Location:  *version.Location,
+		UpdatedAt: time.Now().UTC(),
+	}
+	_, err = database.PlaceCollection.ReplaceOne(ctx, bson.M{"entry_id": entryID}, place, options.Replace().SetUpsert(true))
+	return err
+}
+
+// parseCoordinate reads a coordinate query parameter within the given bound
+func parseCoordinate(r *http.Request, name string, bound float64) (float64, error) {
+	value, err := strconv.ParseFloat(r.URL.Query().Get(name), 64)
+	if err != nil || value < -bound || value > bound {
+		return 0, fmt.Errorf("invalid %s", name)
+	}
+	return value, nil
+}
+
+// NearPlace is a place found around a point, with its distance to the point in meters
+type NearPlace struct {
+	model.Place `bson:",inline"`
+	Distance    float64 `json:"distance" bson:"distance"`
+}
+
+// GetPlacesNear godoc
+// @Summary      Get the entries near a point
+// @Description  Lists the entries whose current version is located within radius meters of a point, nearest first.
+// @Tags         Places
+// @Produce      application/json
+// @Param        lat     query     number  true   "Latitude"
+// @Param        lon     query     number  true   "Longitude"
+// @Param        radius  query     number  false  "Radius in meters (default 1000)"
+// @Param        wikiID  query     string  false  "Wiki ID"
+// @Param        limit   query     int     false  "Maximum number of entries (default 50)"
+// @Success      200     {array}   NearPlace
+// @Success      204     {string}  string  "No Content"
+// @Failure      400     {string}  string  "Invalid coordinates, radius or limit"
+// @Failure      500     {string}  string  "Internal server error"
+// @Router       /api/versions/places/near [get]
+func GetPlacesNear(w http.ResponseWriter, r *http.Request) {
+	lat, errLat := parseCoordinate(r, "lat", 90)
+	lon, errLon := parseCoordinate(r, "lon", 180)
+	if errLat != nil || errLon != nil {
+		config.App.Logger.Warn().Msg("Invalid coordinates")
+		http.Error(w, "Invalid coordinates", http.StatusBadRequest)
+		return
+	}
+
+	radius := 1000.0
+	if value := r.URL.Query().Get("radius"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed <= 0 {
+			config.App.Logger.Warn().Str("radius", value).Msg("Invalid radius")
+			http.Error(w, "Invalid radius", http.StatusBadRequest)
+			return
+		}
+		radius = parsed
+	}
+
+	limit := 50
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			config.App.Logger.Warn().Str("limit", value).Msg("Invalid limit")
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	query := bson.M{}
+	if wikiID := r.URL.Query().Get("wikiID"); wikiID != "" {
+		query["wiki_id"] = wikiID
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$geoNear", Value: bson.M{
+			"near":          model.NewGeoPoint(lat, lon),
+			"distanceField": "distance",
+			"maxDistance":   radius,
+			"spherical":     true,
+			"query":         query,
+		}}},
+		{{Key: "$limit", Value: limit}},
+	}
+	cursor, err := database.PlaceCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var places []NearPlace
+	if err := cursor.All(ctx, &places); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode places")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if len(places) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(places); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetPlacesWithin godoc
+// @Summary      Get the entries within a bounding box
+// @Description  Lists the entries whose current version is located within a bounding box, given as minLon,minLat,maxLon,maxLat.
+// @Tags         Places
+// @Produce      application/json
+// @Param        bbox    query     string  true   "Bounding box: minLon,minLat,maxLon,maxLat"
+// @Param        wikiID  query     string  false  "Wiki ID"
+// @Success      200     {array}   model.Place
+// @Success      204     {string}  string  "No Content"
+// @Failure      400     {string}  string  "Invalid bounding box"
+// @Failure      500     {string}  string  "Internal server error"
+// @Router       /api/versions/places/within [get]
+func GetPlacesWithin(w http.ResponseWriter, r *http.Request) {
+	box, err := parseBBox(r.URL.Query().Get("bbox"))
+	if err != nil {
+		config.App.Logger.Warn().Err(err).Msg("Invalid bounding box")
+		http.Error(w, "Invalid bounding box", http.StatusBadRequest)
+		return
+	}
+
+	minLon, minLat, maxLon, maxLat := box[0], box[1], box[2], box[3]
+	filter := bson.M{"location": bson.M{"$geoWithin": bson.M{"$geometry": bson.M{
+		"type": "Polygon",
+		"coordinates": bson.A{bson.A{
+			bson.A{minLon, minLat}, bson.A{maxLon, minLat}, bson.A{maxLon, maxLat}, bson.A{minLon, maxLat}, bson.A{minLon, minLat},
+		}},
+	}}}}
+	if wikiID := r.URL.Query().Get("wikiID"); wikiID != "" {
+		filter["wiki_id"] = wikiID
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	places, err := findPlaces(ctx, filter)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if len(places) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(places); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// parseBBox reads a minLon,minLat,maxLon,maxLat bounding box
+func parseBBox(value string) ([4]float64, error) {
+	var box [4]float64
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return box, errors.New("expected minLon,minLat,maxLon,maxLat")
+	}
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return box, err
+		}
+		box[i] = n
+	}
+	if box[0] < -180 || box[2] > 180 || box[1] < -90 || box[3] > 90 || box[0] >= box[2] || box[1] >= box[3] {
+		return box, errors.New("coordinates out of range")
+	}
+	return box, nil
+}
+
+// findPlaces retrieves the places matching a filter
+func findPlaces(ctx context.Context, filter interface{}) ([]model.Place, error) {
+	cursor, err := database.PlaceCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var places []model.Place
+	if err := cursor.All(ctx, &places); err != nil {
+		return nil, err
+	}
+	return places, nil
+}
+
+// FeatureCollection is a GeoJSON feature collection
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+// Feature is a GeoJSON feature: an entry located at the address of its current version
+type Feature struct {
+	Type       string                 `json:"type"`
+	Geometry   model.GeoPoint         `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// GetPlacesGeoJSON godoc
+// @Summary      Export the entries of a wiki as GeoJSON
+// @Description  Returns the located entries of a wiki as a GeoJSON FeatureCollection for maps. Each feature has the ID, title and address of the entry and the ID of its current version as properties.
+// @Tags         Places
+// @Produce      application/geo+json
+// @Param        wikiID  query     string  true  "Wiki ID"
+// @Success      200     {object}  FeatureCollection
+// @Failure      400     {string}  string  "WikiID is required"
+// @Failure      500     {string}  string  "Internal server error"
+// @Router       /api/versions/places/geojson [get]
+func GetPlacesGeoJSON(w http.ResponseWriter, r *http.Request) {
+	wikiID := r.URL.Query().Get("wikiID")
+	if wikiID == "" {
+		config.App.Logger.Warn().Msg("Missing wikiID parameter")
+		http.Error(w, "WikiID is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	places, err := findPlaces(ctx, bson.M{"wiki_id": wikiID})
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	titles := map[string]string{}
+	if len(places) > 0 {
+		entries, err := wikiEntries(wikiID)
+		if err != nil {
+			config.App.Logger.Error().Err(err).Str("wikiID", wikiID).Msg("Failed to retrieve the entries of the wiki")
+			http.Error(w, "Failed to retrieve the entries of the wiki", http.StatusInternalServerError)
+			return
+		}
+		for _, entry := range entries {
+			titles[entry.ID] = entry.Title
+		}
+	}
+
+	// an empty collection is still a valid map layer
+	collection := FeatureCollection{Type: "FeatureCollection", Features: []Feature{}}
+	for _, place := range places {
+		collection.Features = append(collection.Features, Feature{
+			Type:     "Feature",
+			Geometry: place.Location,
+			Properties: map[string]interface{}{
+				"entry_id":   place.EntryID,
+				"title":      titles[place.EntryID],
+				"address":    place.Address,
+				"version_id": place.VersionID,
+			},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	if err := json.NewEncoder(w).Encode(collection); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// PlaceRebuildReport is the result of geocoding the current versions again
+type PlaceRebuildReport struct {
+	Entries  int      `json:"entries"`
+	Geocoded int      `json:"geocoded"`
+	Places   int64    `json:"places"`
+	NotFound []string `json:"not_found,omitempty"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// RebuildPlaces godoc
+// @Summary      Rebuild the places of the entries
+// @Description  Geocodes the address of the current version of every entry, or of the entries of a wiki, when it has no location yet, and rebuilds their places. With force, the locations are geocoded again. Only admins can run it.
+// @Tags         Places
+// @Produce      application/json
+// @Param        wikiID  query     string  false  "Wiki ID to limit the rebuild to"
+// @Param        force   query     bool    false  "Geocode the addresses that already have a location"
+// @Success      200     {object}  PlaceRebuildReport
+// @Failure      403     {string}  string  "Forbidden"
+// @Failure      500     {string}  string  "Internal server error"
+// @Router       /api/versions/places/rebuild [post]
+func RebuildPlaces(w http.ResponseWriter, r *http.Request) {
+	wikiID := r.URL.Query().Get("wikiID")
+	force := r.URL.Query().Get("force") == "true"
+
+	req := getRequester(r)
+	if !req.Internal && req.Role != "admin" {
+		config.App.Logger.Warn().Str("userID", req.ID).Str("role", req.Role).Msg("Place rebuild without privileges")
+		http.Error(w, "Forbidden: insufficient privileges", http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	filter := bson.M{}
+	if wikiID != "" {
+		filter["wiki_id"] = wikiID
+	}
+	ids, err := database.VersionCollection.Distinct(ctx, "entry_id", filter)
+	cancel()
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	report := PlaceRebuildReport{}
+	for _, id := range ids {
+		entryID, ok := id.(string)
+		if !ok {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := rebuildPlace(ctx, entryID, force, &report)
+		cancel()
+		if err != nil {
+			config.App.Logger.Error().Err(err).Str("entryID", entryID).Msg("Failed to rebuild the place of the entry")
+			report.Errors = append(report.Errors, fmt.Sprintf("entry %s: %v", entryID, err))
+			continue
+		}
+		report.Entries++
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	count, err := database.PlaceCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	report.Places = count
+
+	config.App.Logger.Info().Int("entries", report.Entries).Int("geocoded", report.Geocoded).Int64("places", report.Places).Int("errors", len(report.Errors)).Msg("Places rebuilt")
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// rebuildPlace geocodes the current version of an entry if needed and refreshes its place
+func rebuildPlace(ctx context.Context, entryID string, force bool, report *PlaceRebuildReport) error {
+	version, err := currentSource(ctx, entryID)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	// without geocoder the locations stored are kept as they are
+	if err == nil && version.Address != "" && (version.Location == nil || force) && getGeocoder() != nil {
+		location := geocodeAddress(version.Address)
+		if location == nil {
+			report.NotFound = append(report.NotFound, version.Address)
+			return refreshPlace(ctx, entryID)
+		}
+		objID, err := primitive.ObjectIDFromHex(version.ID)
+		if err != nil {
+			return err
+		}
+		if _, err := database.VersionCollection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"location": location}}); err != nil {
+			return err
+		}
+		report.Geocoded++
+	}
+	return refreshPlace(ctx, entryID)
+}
@@ -0,0 +1,362 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/laWiki/version/config"
+	"github.com/laWiki/version/database"
+	"github.com/laWiki/version/model"
+	"github.com/laWiki/version/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// VersionRef is the short description of a version shown in diffs and feeds
+type VersionRef struct {
+	ID        string    `json:"id"`
+	Editor    string    `json:"editor"`
+	Summary   string    `json:"summary,omitempty"`
+	Minor     bool      `json:"minor"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// VersionDiff is the block-level difference between two versions of an entry
+type VersionDiff struct {
+	EntryID string           `json:"entry_id"`
+	From    *VersionRef      `json:"from,omitempty"`
+	To      VersionRef       `json:"to"`
+	Added   int              `json:"added"`
+	Removed int              `json:"removed"`
+	Lines   []utils.DiffLine `json:"lines"`
+}
+
+func newVersionRef(version model.Version) VersionRef {
+	return VersionRef{
+		ID:        version.ID,
+		Editor:    version.Editor,
+		Summary:   version.Summary,
+		Minor:     version.Minor,
+		CreatedAt: version.CreatedAt,
+	}
+}
+
+// GetVersionDiff godoc
+// @Summary      Diff a version
+// @Description  Compares a version with another one (by default the previous version of the same entry). The edit summaries of both versions are included.
+// @Tags         Versions
+// @Produce      application/json
+// @Param        id       path      string  true   "Version ID"
+// @Param        against  query     string  false  "Version ID to compare with (defaults to the previous version)"
+// @Success      200      {object}  VersionDiff
+// @Failure      400      {string}  string  "Invalid ID"
+// @Failure      404      {string}  string  "Version not found"
+// @Failure      500      {string}  string  "Internal server error"
+// @Router       /api/versions/{id}/diff [get]
+func GetVersionDiff(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	against := r.URL.Query().Get("against")
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Invalid ID format")
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	version, err := findVersion(ctx, bson.M{"_id": objID})
+	if err != nil || !isVisible(*version, r) {
+		config.App.Logger.Error().Err(err).Msg("Version not found")
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	}
+
+	var previous *model.Version
+	if against != "" {
+		againstID, err := primitive.ObjectIDFromHex(against)
+		if err != nil {
+			config.App.Logger.Error().Err(err).Msg("Invalid 'against' ID format")
+			http.Error(w, "Invalid 'against' ID", http.StatusBadRequest)
+			return
+		}
+		previous, err = findVersion(ctx, bson.M{"_id": againstID})
+		if err != nil || !isVisible(*previous, r) {
+			config.App.Logger.Error().Err(err).Msg("Version to compare with not found")
+			http.Error(w, "Version to compare with not found", http.StatusNotFound)
+			return
+		}
+	} else {
+		// previous version of the same entry
+		opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})
+		filter := bson.M{
+			"entry_id":   version.EntryID,
+			"created_at": bson.M{"$lt": version.CreatedAt},
+		}
+		previous, err = findVersion(ctx, applyVisibility(filter, r), opts)
+		if err != nil && err != mongo.ErrNoDocuments {
+			config.App.Logger.Error().Err(err).Msg("Database error")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	diff := VersionDiff{
+		EntryID: version.EntryID,
+		To:      newVersionRef(*version),
+	}
+	previousContent := ""
+	if previous != nil {
+		ref := newVersionRef(*previous)
+		diff.From = &ref
+		previousContent = previous.Content
+	}
+	diff.Lines = utils.Diff(previousContent, version.Content)
+	diff.Added, diff.Removed = utils.DiffStats(diff.Lines)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(diff); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// BlameBlock is a block of the current content together with the version that introduced it
+type BlameBlock struct {
+	Content   string    `json:"content"`
+	VersionID string    `json:"version_id"`
+	Editor    string    `json:"editor"`
+	Summary   string    `json:"summary,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// EntryBlame is the attribution of every block of the current version of an entry
+type EntryBlame struct {
+	EntryID   string       `json:"entry_id"`
+	VersionID string       `json:"version_id"`
+	Blocks    []BlameBlock `json:"blocks"`
+}
+
+// blame walks the versions, oldest first, and attributes every block of the newest version to the version
+// that last introduced it
+func blame(versions []model.Version) []BlameBlock {
+	var blocks []string
+	var blamed []BlameBlock
+	for _, version := range versions {
+		newBlocks := utils.SplitBlocks(version.Content)
+		newBlamed := make([]BlameBlock, 0, len(newBlocks))
+
+		old := 0
+		for _, line := range utils.DiffBlocks(blocks, newBlocks) {
+			switch line.Op {
+			case utils.OpEqual:
+				newBlamed = append(newBlamed, blamed[old])
+				old++
+			case utils.OpDelete:
+				old++
+			case utils.OpInsert:
+				newBlamed = append(newBlamed, BlameBlock{
+					Content:   line.Text,
+					VersionID: version.ID,
+					Editor:    version.Editor,
+					Summary:   version.Summary,
+					CreatedAt: version.CreatedAt,
+				})
+			}
+		}
+
+		blocks = newBlocks
+		blamed = newBlamed
+	}
+	return blamed
+}
+
+// GetEntryBlame godoc
+// @Summary      Blame an entry
+// @Description  Returns each content block of the current version of an entry with the version, editor and timestamp that last introduced it, walking the published versions of the entry.
+// @Tags         Versions
+// @Produce      application/json
+// @Param        entryID  query     string  true  "Entry ID"
+// @Success      200      {object}  EntryBlame
+// @Success      204      {string}  string  "No Content"
+// @Failure      400      {string}  string  "EntryID is required"
+// @Failure      500      {string}  string  "Internal server error"
+// @Router       /api/versions/blame [get]
+func GetEntryBlame(w http.ResponseWriter, r *http.Request) {
+	entryID := r.URL.Query().Get("entryID")
+	if entryID == "" {
+		config.App.Logger.Warn().Msg("Missing entryID parameter")
+		http.Error(w, "EntryID is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"$and": bson.A{bson.M{"entry_id": entryID}, publishedFilter()}}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	versions, err := findVersions(ctx, filter, opts)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if len(versions) == 0 {
+		config.App.Logger.Info().Str("entryID", entryID).Msg("No versions found")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	result := EntryBlame{
+		EntryID:   entryID,
+		VersionID: versions[len(versions)-1].ID,
+		Blocks:    blame(versions),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// atomFeed is the root element of an Atom feed
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Link    atomLink    `xml:"link"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title   string     `xml:"title"`
+	ID      string     `xml:"id"`
+	Link    atomLink   `xml:"link"`
+	Updated string     `xml:"updated"`
+	Author  atomAuthor `xml:"author"`
+	Summary string     `xml:"summary,omitempty"`
+}
+
+// maxFeedLimit is the largest number of items the feed returns
+const maxFeedLimit = 500
+
+// GetVersionsFeed godoc
+// @Summary      Recent changes feed
+// @Description  Atom feed with the latest versions, optionally limited to an entry. Each item carries the edit summary of the version.
+// @Tags         Versions
+// @Produce      application/atom+xml
+// @Param        entryID    query     string  false  "Entry ID to limit the feed to"
+// @Param        hideMinor  query     bool    false  "Exclude versions flagged as minor edits"
+// @Param        limit      query     int     false  "Maximum number of items (default 50, at most 500)"
+// @Success      200        {string}  string  "Atom feed"
+// @Failure      400        {string}  string  "Bad Request"
+// @Failure      500        {string}  string  "Internal server error"
+// @Router       /api/versions/feed [get]
+func GetVersionsFeed(w http.ResponseWriter, r *http.Request) {
+	entryID := r.URL.Query().Get("entryID")
+	hideMinor := r.URL.Query().Get("hideMinor") == "true"
+	limitString := r.URL.Query().Get("limit")
+
+	limit := int64(50)
+	if limitString != "" {
+		parsed, err := strconv.ParseInt(limitString, 10, 64)
+		if err != nil || parsed <= 0 {
+			config.App.Logger.Error().Str("limit", limitString).Msg("Invalid limit")
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, maxFeedLimit)
+	}
+
+	filter := bson.M{}
+	if entryID != "" {
+		filter["entry_id"] = entryID
+	}
+	if hideMinor {
+		filter["minor"] = bson.M{"$ne": true}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// the feed only needs the metadata of the versions
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(limit).
+		SetProjection(bson.M{"content": 0, "delta": 0, "search_text": 0, "translatedFields": 0})
+	cursor, err := database.VersionCollection.Find(ctx, applyVisibility(filter, r), opts)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var versions []model.Version
+	if err := cursor.All(ctx, &versions); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode versions")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	feedURL := fmt.Sprintf("%s/api/versions/feed", config.App.API_GATEWAY_URL)
+	feed := atomFeed{
+		Title:   "laWiki - cambios recientes",
+		ID:      feedURL,
+		Link:    atomLink{Href: feedURL, Rel: "self"},
+		Updated: time.Now().UTC().Format(time.RFC3339),
+	}
+	if entryID != "" {
+		feed.ID = feedURL + "?entryID=" + entryID
+		feed.Link.Href = feed.ID
+	}
+	if len(versions) > 0 {
+		feed.Updated = versions[0].CreatedAt.UTC().Format(time.RFC3339)
+	}
+
+	for _, version := range versions {
+		versionURL := fmt.Sprintf("%s/api/versions/%s", config.App.API_GATEWAY_URL, version.ID)
+		title := "Nueva versión de la entrada " + version.EntryID
+		if version.Minor {
+			title += " (menor)"
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   title,
+			ID:      versionURL,
+			Link:    atomLink{Href: versionURL},
+			Updated: version.CreatedAt.UTC().Format(time.RFC3339),
+			Author:  atomAuthor{Name: version.Editor},
+			Summary: version.Summary,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(feed); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode feed")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,653 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/laWiki/version/config"
+	"github.com/laWiki/version/database"
+	"github.com/laWiki/version/model"
+	"github.com/laWiki/version/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// errLinksUnresolved is returned by refreshLinks when some targets couldn't be looked up, the links are kept
+var errLinksUnresolved = errors.New("links not resolved")
+
+// refreshLinks replaces the links of an entry with the wiki links and template inclusions of its current version.
+// Links only lead nowhere when their target isn't found: if some can't be looked up the old links are kept.
+func refreshLinks(ctx context.Context, entryID string) error {
+	version, err := currentSource(ctx, entryID)
+	if err == mongo.ErrNoDocuments {
+		_, err := database.LinkCollection.DeleteMany(ctx, bson.M{"source_entry_id": entryID})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	wikiID, err := versionWikiID(*version)
+	if err != nil {
+		return err
+	}
+
+	type target struct{ kind, title string }
+	counts := map[target]int{}
+	var targets []target
+	add := func(t target) {
+		if t.title == "" {
+			return
+		}
+		if counts[t] == 0 {
+			targets = append(targets, t)
+		}
+		counts[t]++
+	}
+	for _, link := range utils.WikiLinks(version.Content) {
+		add(target{title: link.Title})
+	}
+	for _, call := range utils.TemplateCalls(version.Content) {
+		add(target{kind: model.LinkTemplate, title: call.Name})
+	}
+
+	now := time.Now().UTC()
+	docs := make([]interface{}, 0, len(targets))
+	for _, t := range targets {
+		targetID, err := lookupTitle(wikiID, t.title)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", errLinksUnresolved, t.title, err)
+		}
+		docs = append(docs, model.Link{
+			WikiID:          wikiID,
+			SourceEntryID:   entryID,
+			SourceVersionID: version.ID,
+			TargetTitle:     t.title,
+			TargetEntryID:   targetID,
+			Kind:            t.kind,
+			Count:           counts[t],
+			UpdatedAt:       now,
+		})
+	}
+
+	if _, err := database.LinkCollection.DeleteMany(ctx, bson.M{"source_entry_id": entryID}); err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+	_, err = database.LinkCollection.InsertMany(ctx, docs)
+	return err
+}
+
+// Entries whose links couldn't be looked up are refreshed again after refreshRetryDelay, up to refreshRetries times
+const (
+	refreshRetryDelay = time.Minute
+	refreshRetries    = 5
+)
+
+var (
+	refreshOnce    sync.Once
+	refreshMutex   sync.Mutex
+	refreshQueue   []string
+	refreshPending = map[string]bool{}
+	refreshRetried = map[string]int{}
+	refreshSignal  = make(chan struct{}, 1)
+)
+
+// currentVersionChanged is called when the current version of an entry may have changed. The templates
+// rendered with the entry are dropped at once; the rest of the work goes to the background, see refreshEntry,
+// so slow services never hold the write that changed the version.
+func currentVersionChanged(entryID string) {
+	// the entry may be a template other contents were rendered with
+	invalidateTemplate(entryID)
+
+	refreshMutex.Lock()
+	delete(refreshRetried, entryID)
+	refreshMutex.Unlock()
+	queueRefresh(entryID)
+}
+
+// queueRefresh queues an entry for refreshEntry
+func queueRefresh(entryID string) {
+	refreshOnce.Do(func() {
+		go runRefresher()
+	})
+	refreshMutex.Lock()
+	if !refreshPending[entryID] {
+		refreshPending[entryID] = true
+		refreshQueue = append(refreshQueue, entryID)
+	}
+	refreshMutex.Unlock()
+	select {
+	case refreshSignal <- struct{}{}:
+	default:
+	}
+}
+
+// runRefresher refreshes the queued entries one at a time, so two refreshes of an entry never interleave.
+// An entry changed again while it is refreshed is queued once more.
+func runRefresher() {
+	for range refreshSignal {
+		for {
+			refreshMutex.Lock()
+			if len(refreshQueue) == 0 {
+				refreshMutex.Unlock()
+				break
+			}
+			entryID := refreshQueue[0]
+			refreshQueue = refreshQueue[1:]
+			delete(refreshPending, entryID)
+			refreshMutex.Unlock()
+
+			refreshEntry(entryID)
+		}
+	}
+}
+
+// refreshEntry refreshes the links, the place, the citations and the external links of an entry from its
+// current version, and moves the inline comments to it. It is best effort: failures are logged, and
+// RebuildLinks and RebuildPlaces repair the tables. Entries whose links couldn't be looked up are retried.
+func refreshEntry(entryID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := refreshLinks(ctx, entryID); errors.Is(err, errLinksUnresolved) {
+		retryRefresh(entryID, err)
+	} else {
+		if err != nil {
+			config.App.Logger.Error().Err(err).Str("entryID", entryID).Msg("Failed to update the links of the entry")
+		}
+		refreshMutex.Lock()
+		delete(refreshRetried, entryID)
+		refreshMutex.Unlock()
+	}
+	if err := refreshPlace(ctx, entryID); err != nil {
+		config.App.Logger.Error().Err(err).Str("entryID", entryID).Msg("Failed to update the place of the entry")
+	}
+	if err := refreshCitations(ctx, entryID); err != nil {
+		config.App.Logger.Error().Err(err).Str("entryID", entryID).Msg("Failed to update the citations of the entry")
+	}
+	if err := refreshExternalLinks(ctx, entryID); err != nil {
+		config.App.Logger.Error().Err(err).Str("entryID", entryID).Msg("Failed to update the external links of the entry")
+	}
+	if err := reanchorComments(ctx, entryID); err != nil {
+		config.App.Logger.Error().Err(err).Str("entryID", entryID).Msg("Failed to re-anchor the comments of the entry")
+	}
+}
+
+// retryRefresh queues an entry whose links couldn't be looked up again after a while
+func retryRefresh(entryID string, err error) {
+	refreshMutex.Lock()
+	refreshRetried[entryID]++
+	retries := refreshRetried[entryID]
+	if retries > refreshRetries {
+		delete(refreshRetried, entryID)
+	}
+	refreshMutex.Unlock()
+	if retries > refreshRetries {
+		config.App.Logger.Error().Err(err).Str("entryID", entryID).Msg("Failed to update the links of the entry, giving up")
+		return
+	}
+	config.App.Logger.Warn().Err(err).Str("entryID", entryID).Int("retry", retries).Msg("Failed to look up the links of the entry, keeping the old ones")
+	time.AfterFunc(refreshRetryDelay, func() { queueRefresh(entryID) })
+}
+
+// reanchorComments asks the comment service to move the inline comments of an entry to its current version
+func reanchorComments(ctx context.Context, entryID string) error {
+	version, err := currentSource(ctx, entryID)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	return internalPost(client, fmt.Sprintf("%s/api/comments/reanchor?versionID=%s", config.App.API_GATEWAY_URL, url.QueryEscape(version.ID)))
+}
+
+// wikiEntries retrieves the entries of a wiki from the entry service
+func wikiEntries(wikiID string) ([]entryInfo, error) {
+	var entries []entryInfo
+	err := fetchJSON(fmt.Sprintf("%s/api/entries/search?wikiID=%s", config.App.API_GATEWAY_URL, url.QueryEscape(wikiID)), &entries)
+	return entries, err
+}
+
+// GetLinks godoc
+// @Summary      Get links
+// @Description  Lists the wiki links and template inclusions between entries, taken from their current versions. With targetEntryID, the links leading to an entry, through its title, aliases or redirects (what links here); with target, the links written with a title; with source, the links of an entry. kind limits the list to wiki links (link) or template inclusions (template).
+// @Tags         Links
+// @Produce      application/json
+// @Param        wikiID         query     string  false  "Wiki ID"
+// @Param        target         query     string  false  "Title of the linked entry"
+// @Param        targetEntryID  query     string  false  "ID of the linked entry"
+// @Param        source         query     string  false  "ID of the linking entry"
+// @Param        kind           query     string  false  "link or template"
+// @Success      200            {array}   model.Link
+// @Success      204            {string}  string  "No Content"
+// @Failure      400            {string}  string  "A targetEntryID, a wikiID with a target, or a source is required"
+// @Failure      500            {string}  string  "Internal server error"
+// @Router       /api/versions/links [get]
+func GetLinks(w http.ResponseWriter, r *http.Request) {
+	wikiID := r.URL.Query().Get("wikiID")
+	target := r.URL.Query().Get("target")
+	targetEntryID := r.URL.Query().Get("targetEntryID")
+	source := r.URL.Query().Get("source")
+
+	if source == "" && targetEntryID == "" && (wikiID == "" || target == "") {
+		config.App.Logger.Warn().Msg("Links requested without source or target")
+		http.Error(w, "A targetEntryID, a wikiID with a target, or a source is required", http.StatusBadRequest)
+		return
+	}
+	filter := bson.M{}
+	if source != "" {
+		filter["source_entry_id"] = source
+	}
+	if targetEntryID != "" {
+		filter["target_entry_id"] = targetEntryID
+	}
+	if wikiID != "" {
+		filter["wiki_id"] = wikiID
+	}
+	if target != "" {
+		filter["target_title"] = target
+	}
+	switch r.URL.Query().Get("kind") {
+	case model.LinkTemplate:
+		filter["kind"] = model.LinkTemplate
+	case "link":
+		filter["kind"] = bson.M{"$ne": model.LinkTemplate}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := database.LinkCollection.Find(ctx, filter)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var links []model.Link
+	if err := cursor.All(ctx, &links); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode links")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if len(links) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(links); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// OrphanEntry is an entry no other entry of its wiki links to
+type OrphanEntry struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+// GetOrphanEntries godoc
+// @Summary      Orphan entries
+// @Description  Lists the entries of a wiki that no other entry links to, by their title, aliases or redirects. Redirects aren't listed.
+// @Tags         Links
+// @Produce      application/json
+// @Param        wikiID  query     string  true  "Wiki ID"
+// @Success      200     {array}   OrphanEntry
+// @Success      204     {string}  string  "No Content"
+// @Failure      400     {string}  string  "WikiID is required"
+// @Failure      500     {string}  string  "Internal server error"
+// @Router       /api/versions/links/orphans [get]
+func GetOrphanEntries(w http.ResponseWriter, r *http.Request) {
+	wikiID := r.URL.Query().Get("wikiID")
+	if wikiID == "" {
+		config.App.Logger.Warn().Msg("Missing wikiID parameter")
+		http.Error(w, "WikiID is required", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := wikiEntries(wikiID)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Str("wikiID", wikiID).Msg("Failed to retrieve the entries of the wiki")
+		http.Error(w, "Failed to retrieve the entries of the wiki", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := database.LinkCollection.Find(ctx, bson.M{"wiki_id": wikiID})
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var links []model.Link
+	if err := cursor.All(ctx, &links); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode links")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// links of an entry to itself don't count
+	linked := map[string]bool{}
+	for _, link := range links {
+		if link.TargetEntryID != "" && link.TargetEntryID != link.SourceEntryID {
+			linked[link.TargetEntryID] = true
+		}
+	}
+
+	var orphans []OrphanEntry
+	for _, entry := range entries {
+		if entry.RedirectTo == "" && !linked[entry.ID] {
+			orphans = append(orphans, OrphanEntry{ID: entry.ID, Title: entry.Title})
+		}
+	}
+
+	if len(orphans) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(orphans); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// WantedEntry is a title linked to by entries of a wiki that has no entry yet
+type WantedEntry struct {
+	Title   string   `json:"title"`
+	Links   int      `json:"links"`
+	Sources []string `json:"sources"`
+}
+
+// GetWantedEntries godoc
+// @Summary      Wanted entries
+// @Description  Lists the titles of the red links of a wiki, the ones most linked to first.
+// @Tags         Links
+// @Produce      application/json
+// @Param        wikiID  query     string  true   "Wiki ID"
+// @Param        limit   query     int     false  "Maximum number of titles"
+// @Success      200     {array}   WantedEntry
+// @Success      204     {string}  string  "No Content"
+// @Failure      400     {string}  string  "WikiID is required"
+// @Failure      500     {string}  string  "Internal server error"
+// @Router       /api/versions/links/wanted [get]
+func GetWantedEntries(w http.ResponseWriter, r *http.Request) {
+	wikiID := r.URL.Query().Get("wikiID")
+	if wikiID == "" {
+		config.App.Logger.Warn().Msg("Missing wikiID parameter")
+		http.Error(w, "WikiID is required", http.StatusBadRequest)
+		return
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"wiki_id":         wikiID,
+			"target_entry_id": bson.M{"$in": bson.A{"", nil}},
+			"kind":            bson.M{"$ne": model.LinkTemplate},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     "$target_title",
+			"links":   bson.M{"$sum": "$count"},
+			"sources": bson.M{"$addToSet": "$source_entry_id"},
+		}}},
+	}
+	cursor, err := database.LinkCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		Title   string   `bson:"_id"`
+		Links   int      `bson:"links"`
+		Sources []string `bson:"sources"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode links")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var wanted []WantedEntry
+	for _, group := range groups {
+		wanted = append(wanted, WantedEntry{Title: group.Title, Links: group.Links, Sources: group.Sources})
+	}
+	sort.Slice(wanted, func(i, j int) bool {
+		if wanted[i].Links != wanted[j].Links {
+			return wanted[i].Links > wanted[j].Links
+		}
+		return wanted[i].Title < wanted[j].Title
+	})
+	if len(wanted) > limit {
+		wanted = wanted[:limit]
+	}
+
+	if len(wanted) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(wanted); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// LinkRebuildReport is the result of rebuilding the link table
+type LinkRebuildReport struct {
+	Entries   int      `json:"entries"`
+	Links     int64    `json:"links"`
+	Citations int64    `json:"citations"`
+	External  int64    `json:"external_links"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// RebuildLinks godoc
+// @Summary      Rebuild the link table
+// @Description  Extracts again the links, the citations and the external links of the current version of every entry, or of the entries of a wiki. Only admins can run it.
+// @Tags         Links
+// @Produce      application/json
+// @Param        wikiID  query     string  false  "Wiki ID to limit the rebuild to"
+// @Success      200     {object}  LinkRebuildReport
+// @Failure      403     {string}  string  "Forbidden"
+// @Failure      500     {string}  string  "Internal server error"
+// @Router       /api/versions/links/rebuild [post]
+func RebuildLinks(w http.ResponseWriter, r *http.Request) {
+	wikiID := r.URL.Query().Get("wikiID")
+
+	req := getRequester(r)
+	if !req.Internal && req.Role != "admin" {
+		config.App.Logger.Warn().Str("userID", req.ID).Str("role", req.Role).Msg("Link rebuild without privileges")
+		http.Error(w, "Forbidden: insufficient privileges", http.StatusForbidden)
+		return
+	}
+
+	var entryIDs []string
+	if wikiID != "" {
+		entries, err := wikiEntries(wikiID)
+		if err != nil {
+			config.App.Logger.Error().Err(err).Str("wikiID", wikiID).Msg("Failed to retrieve the entries of the wiki")
+			http.Error(w, "Failed to retrieve the entries of the wiki", http.StatusInternalServerError)
+			return
+		}
+		for _, entry := range entries {
+			entryIDs = append(entryIDs, entry.ID)
+		}
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		ids, err := database.VersionCollection.Distinct(ctx, "entry_id", bson.M{})
+		cancel()
+		if err != nil {
+			config.App.Logger.Error().Err(err).Msg("Database error")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		for _, id := range ids {
+			if id, ok := id.(string); ok {
+				entryIDs = append(entryIDs, id)
+			}
+		}
+	}
+
+	report := LinkRebuildReport{}
+	for _, entryID := range entryIDs {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := refreshLinks(ctx, entryID)
+		if err == nil {
+			err = refreshCitations(ctx, entryID)
+		}
+		if err == nil {
+			err = refreshExternalLinks(ctx, entryID)
+		}
+		cancel()
+		if err != nil {
+			config.App.Logger.Error().Err(err).Str("entryID", entryID).Msg("Failed to rebuild the links of the entry")
+			report.Errors = append(report.Errors, fmt.Sprintf("entry %s: %v", entryID, err))
+			continue
+		}
+		report.Entries++
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{}
+	if wikiID != "" {
+		filter["wiki_id"] = wikiID
+	}
+	count, err := database.LinkCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	report.Links = count
+	if report.Citations, err = database.CitationCollection.CountDocuments(ctx, filter); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if report.External, err = database.ExternalLinkCollection.CountDocuments(ctx, filter); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	config.App.Logger.Info().Int("entries", report.Entries).Int64("links", report.Links).Int64("citations", report.Citations).Int64("external_links", report.External).Int("errors", len(report.Errors)).Msg("Links rebuilt")
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// RetargetLinks godoc
+// @Summary      Resolve links again
+// @Description  Resolves again the links to the given titles of a wiki, and those leading to an entry, once entries have been created, renamed, redirected or deleted. Called by the entry service.
+// @Tags         Links
+// @Param        wikiID   query     string  true   "Wiki ID"
+// @Param        title    query     string  false  "Title whose links are resolved again (repeatable)"
+// @Param        entryID  query     string  false  "Entry whose links are resolved again"
+// @Success      204      {string}  string  "No Content"
+// @Failure      400      {string}  string  "WikiID is required"
+// @Failure      403      {string}  string  "Forbidden"
+// @Failure      500      {string}  string  "Internal server error"
+// @Router       /api/versions/links/retarget [post]
+func RetargetLinks(w http.ResponseWriter, r *http.Request) {
+	wikiID := r.URL.Query().Get("wikiID")
+	titles := r.URL.Query()["title"]
+	entryID := r.URL.Query().Get("entryID")
+	if wikiID == "" {
+		config.App.Logger.Warn().Msg("Missing wikiID parameter")
+		http.Error(w, "WikiID is required", http.StatusBadRequest)
+		return
+	}
+
+	req := getRequester(r)
+	if !req.Internal && req.Role != "admin" {
+		config.App.Logger.Warn().Str("userID", req.ID).Str("role", req.Role).Msg("Link retarget without privileges")
+		http.Error(w, "Forbidden: insufficient privileges", http.StatusForbidden)
+		return
+	}
+
+	// the rendered links to the titles are resolved again too
+	if titles == nil {
+		titles = []string{}
+	}
+	forgetTitles(wikiID, titles)
+	if entryID != "" {
+		invalidateTemplate(entryID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	targets := bson.A{bson.M{"target_title": bson.M{"$in": titles}}}
+	if entryID != "" {
+		targets = append(targets, bson.M{"target_entry_id": entryID})
+	}
+	affected, err := database.LinkCollection.Distinct(ctx, "target_title", bson.M{"wiki_id": wikiID, "$or": targets})
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var affectedTitles []string
+	for _, title := range affected {
+		if title, ok := title.(string); ok {
+			affectedTitles = append(affectedTitles, title)
+		}
+	}
+	forgetTitles(wikiID, affectedTitles)
+
+	for _, title := range affectedTitles {
+		targetID, _ := resolveTitle(wikiID, title)
+		_, err := database.LinkCollection.UpdateMany(ctx,
+			bson.M{"wiki_id": wikiID, "target_title": title},
+			bson.M{"$set": bson.M{"target_entry_id": targetID}})
+		if err != nil {
+			config.App.Logger.Error().Err(err).Str("title", title).Msg("Failed to retarget links")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	config.App.Logger.Info().Str("wikiID", wikiID).Int("titles", len(affectedTitles)).Msg("Links retargeted")
+	w.WriteHeader(http.StatusNoContent)
+}
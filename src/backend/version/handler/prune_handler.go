@@ -0,0 +1,345 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/laWiki/version/config"
+	"github.com/laWiki/version/database"
+	"github.com/laWiki/version/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	errDeleteMedia    = errors.New("failed to delete associated media files")
+	errDeleteComments = errors.New("failed to delete associated comments")
+)
+
+// deleteVersionCascade deletes a version together with its media files and comments. The versions stored
+// as deltas against it are detached first. It reports false if the version was already gone.
+func deleteVersionCascade(ctx context.Context, client *http.Client, version model.Version) (bool, error) {
+	for _, mediaID := range version.MediaIDs {
+		mediaServiceURL := fmt.Sprintf("%s/api/media/%s", config.App.API_GATEWAY_URL, mediaID)
+		config.App.Logger.Info().Str("url", mediaServiceURL).Msg("Sending delete request to media service")
+		if err := internalDelete(client, mediaServiceURL); err != nil {
+			return false, fmt.Errorf("%w: %v", errDeleteMedia, err)
+		}
+	}
+
+	commentServiceURL := fmt.Sprintf("%s/api/comments/version?versionID=%s", config.App.API_GATEWAY_URL, version.ID)
+	config.App.Logger.Info().Str("url", commentServiceURL).Msg("Sending request to delete associated comments")
+	if err := internalDelete(client, commentServiceURL); err != nil {
+		return false, fmt.Errorf("%w: %v", errDeleteComments, err)
+	}
+
+	if err := detachDependents(ctx, version); err != nil {
+		return false, err
+	}
+
+	objID, err := primitive.ObjectIDFromHex(version.ID)
+	if err != nil {
+		return false, err
+	}
+	result, err := database.VersionCollection.DeleteOne(ctx, bson.M{"_id": objID})
+	if err != nil {
+		return false, err
+	}
+	// the version before it may be the latest now
+	if err := materializeLatest(ctx, version.EntryID); err != nil {
+		config.App.Logger.Error().Err(err).Str("entryID", version.EntryID).Msg("Failed to materialize the latest version of the entry")
+	}
+	return result.DeletedCount > 0, nil
+}
+
+// internalDelete sends an internal DELETE request through the gateway
+func internalDelete(client *http.Client, url string) error {
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Internal-Auth", config.App.JWTSecret)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("DELETE %s returned %d: %s", url, resp.StatusCode, string(bodyBytes))
+	}
+	return nil
+}
+
+// internalPost sends an internal POST request without body through the gateway
+func internalPost(client *http.Client, url string) error {
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Internal-Auth", config.App.JWTSecret)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("POST %s returned %d: %s", url, resp.StatusCode, string(bodyBytes))
+	}
+	return nil
+}
+
+// retentionPolicy is the retention policy of a wiki, see the wiki service
+type retentionPolicy struct {
+	KeepAllDays  int `json:"keep_all_days"`
+	IntervalDays int `json:"interval_days"`
+	MaxAgeDays   int `json:"max_age_days"`
+}
+
+// keepsHistory reports whether the policy keeps more than the current version. Policies that don't were stored
+// before the wiki service refused them, and are ignored rather than pruning the whole history.
+func (p retentionPolicy) keepsHistory() bool {
+	return p.KeepAllDays > 0 || p.IntervalDays > 0
+}
+
+// prunable selects the versions of an entry the policy doesn't keep. The versions must be sorted newest first.
+// The current version, tagged versions and the versions still in the workflow (drafts and pending reviews)
+// are always kept.
+// Older versions are kept one per interval, aligned to fixed dates so the selection is stable between runs.
+func (p retentionPolicy) prunable(versions []model.Version, now time.Time) []model.Version {
+	var pruned []model.Version
+	currentFound := false
+	buckets := map[int64]bool{}
+	for _, version := range versions {
+		if isPublished(version) && !currentFound {
+			currentFound = true
+			continue
+		}
+		if len(version.Tags) > 0 || version.State == model.StateDraft || version.State == model.StatePendingReview {
+			continue
+		}
+
+		age := now.Sub(version.CreatedAt)
+		if age <= time.Duration(p.KeepAllDays)*24*time.Hour {
+			continue
+		}
+		if p.MaxAgeDays > 0 && age > time.Duration(p.MaxAgeDays)*24*time.Hour {
+			pruned = append(pruned, version)
+			continue
+		}
+		if p.IntervalDays > 0 {
+			bucket := version.CreatedAt.Unix() / int64(p.IntervalDays*24*60*60)
+			if !buckets[bucket] {
+				buckets[bucket] = true
+				continue
+			}
+		}
+		pruned = append(pruned, version)
+	}
+	return pruned
+}
+
+// PrunedVersion is a version removed (or to be removed, in a dry run) by the pruner
+type PrunedVersion struct {
+	ID        string    `json:"id"`
+	EntryID   string    `json:"entry_id"`
+	WikiID    string    `json:"wiki_id"`
+	Editor    string    `json:"editor"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PruneReport is the result of a pruning run
+type PruneReport struct {
+	DryRun     bool            `json:"dry_run"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt time.Time       `json:"finished_at"`
+	Wikis      int             `json:"wikis"`
+	Entries    int             `json:"entries"`
+	Examined   int             `json:"examined"`
+	Removed    int             `json:"removed"`
+	Versions   []PrunedVersion `json:"versions"`
+	Errors     []string        `json:"errors,omitempty"`
+}
+
+var (
+	pruneMutex sync.Mutex
+	lastPrune  *PruneReport
+)
+
+// RunPruner prunes the versions periodically until the context is done
+func RunPruner(ctx context.Context) {
+	ticker := time.NewTicker(config.App.PruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report := prune("", config.App.PruneDryRun)
+			config.App.Logger.Info().Bool("dryRun", report.DryRun).Int("examined", report.Examined).Int("removed", report.Removed).Int("errors", len(report.Errors)).Msg("Versions pruned")
+		}
+	}
+}
+
+// prune applies the retention policy of every wiki, or only of the given one. Runs never overlap.
+func prune(wikiID string, dryRun bool) PruneReport {
+	pruneMutex.Lock()
+	defer pruneMutex.Unlock()
+
+	report := PruneReport{DryRun: dryRun, StartedAt: time.Now().UTC(), Versions: []PrunedVersion{}}
+	defer func() {
+		report.FinishedAt = time.Now().UTC()
+		lastPrune = &report
+	}()
+
+	var wikis []wikiInfo
+	if wikiID != "" {
+		wiki, err := fetchWiki(wikiID)
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			return report
+		}
+		wikis = append(wikis, *wiki)
+	} else if err := fetchJSON(fmt.Sprintf("%s/api/wikis/", config.App.API_GATEWAY_URL), &wikis); err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return report
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	for _, wiki := range wikis {
+		if wiki.Retention == nil {
+			continue
+		}
+		if !wiki.Retention.keepsHistory() {
+			config.App.Logger.Warn().Str("wikiID", wiki.ID).Msg("Retention policy without keep window nor interval, skipped")
+			continue
+		}
+		report.Wikis++
+		if err := pruneWiki(client, wiki, dryRun, &report); err != nil {
+			config.App.Logger.Error().Err(err).Str("wikiID", wiki.ID).Msg("Failed to prune the versions of the wiki")
+			report.Errors = append(report.Errors, fmt.Sprintf("wiki %s: %v", wiki.ID, err))
+		}
+	}
+	return report
+}
+
+func pruneWiki(client *http.Client, wiki wikiInfo, dryRun bool, report *PruneReport) error {
+	var entries []entryInfo
+	if err := fetchJSON(fmt.Sprintf("%s/api/entries/search?wikiID=%s", config.App.API_GATEWAY_URL, url.QueryEscape(wiki.ID)), &entries); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		report.Entries++
+
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		// the content isn't needed to decide
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetProjection(bson.M{"content": 0, "delta": 0, "search_text": 0})
+		cursor, err := database.VersionCollection.Find(ctx, bson.M{"entry_id": entry.ID}, opts)
+		if err != nil {
+			cancel()
+			return err
+		}
+		var versions []model.Version
+		err = cursor.All(ctx, &versions)
+		cursor.Close(ctx)
+		if err != nil {
+			cancel()
+			return err
+		}
+
+		report.Examined += len(versions)
+		for _, version := range wiki.Retention.prunable(versions, now) {
+			if !dryRun {
+				if _, err := deleteVersionCascade(ctx, client, version); err != nil {
+					config.App.Logger.Error().Err(err).Str("versionID", version.ID).Msg("Failed to prune version")
+					report.Errors = append(report.Errors, fmt.Sprintf("version %s: %v", version.ID, err))
+					continue
+				}
+			}
+			report.Removed++
+			report.Versions = append(report.Versions, PrunedVersion{
+				ID:        version.ID,
+				EntryID:   version.EntryID,
+				WikiID:    wiki.ID,
+				Editor:    version.Editor,
+				CreatedAt: version.CreatedAt,
+			})
+		}
+		cancel()
+	}
+	return nil
+}
+
+// PruneVersions godoc
+// @Summary      Prune old versions
+// @Description  Applies the retention policy of the wikis now, deleting the versions it doesn't keep together with their comments and media. With dryRun nothing is deleted and the report lists what would be. Only admins can run it.
+// @Tags         Storage
+// @Produce      application/json
+// @Param        wikiID  query     string  false  "Wiki ID to limit the pruning to"
+// @Param        dryRun  query     bool    false  "Report the versions without deleting them"
+// @Success      200     {object}  PruneReport
+// @Failure      403     {string}  string  "Forbidden"
+// @Router       /api/versions/prune [post]
+func PruneVersions(w http.ResponseWriter, r *http.Request) {
+	wikiID := r.URL.Query().Get("wikiID")
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+
+	req := getRequester(r)
+	if !req.Internal && req.Role != "admin" {
+		config.App.Logger.Warn().Str("userID", req.ID).Str("role", req.Role).Msg("Pruning without privileges")
+		http.Error(w, "Forbidden: insufficient privileges", http.StatusForbidden)
+		return
+	}
+
+	report := prune(wikiID, dryRun)
+	config.App.Logger.Info().Bool("dryRun", report.DryRun).Int("examined", report.Examined).Int("removed", report.Removed).Int("errors", len(report.Errors)).Msg("Versions pruned")
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetLastPrune godoc
+// @Summary      Last pruning report
+// @Description  Returns the report of the last pruning run, scheduled or manual.
+// @Tags         Storage
+// @Produce      application/json
+// @Success      200  {object}  PruneReport
+// @Success      204  {string}  string  "No Content"
+// @Router       /api/versions/prune/last [get]
+func GetLastPrune(w http.ResponseWriter, r *http.Request) {
+	pruneMutex.Lock()
+	report := lastPrune
+	pruneMutex.Unlock()
+
+	if report == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/laWiki/version/model"
+)
+
+func TestPrunable(t *testing.T) {
+	// buckets are aligned to the epoch, so with whole days the interval of a version is its day / IntervalDays
+	now := time.Unix(1000*24*60*60, 0).UTC()
+	version := func(id string, days int, state string, tagged bool) model.Version {
+		v := model.Version{ID: id, State: state, CreatedAt: now.Add(-time.Duration(days) * 24 * time.Hour)}
+		if tagged {
+			v.Tags = []model.Tag{{Name: "v1"}}
+		}
+		return v
+	}
+
+	tests := []struct {
+		name     string
+		policy   retentionPolicy
+		versions []model.Version
+		want     []string
+	}{
+		{
+			name:   "keeps the current version however old",
+			policy: retentionPolicy{KeepAllDays: 1, MaxAgeDays: 10},
+			versions: []model.Version{
+				version("current", 500, model.StatePublished, false),
+				version("old", 600, model.StatePublished, false),
+			},
+			want: []string{"old"},
+		},
+		{
+			name:   "the current version is the newest published one",
+			policy: retentionPolicy{KeepAllDays: 1},
+			versions: []model.Version{
+				version("draft", 20, model.StateDraft, false),
+				version("rejected", 25, model.StateRejected, false),
+				version("current", 30, "", false),
+				version("previous", 40, model.StatePublished, false),
+			},
+			want: []string{"rejected", "previous"},
+		},
+		{
+			name:   "keeps everything in the keep window",
+			policy: retentionPolicy{KeepAllDays: 5},
+			versions: []model.Version{
+				version("current", 0, model.StatePublished, false),
+				version("recent", 2, model.StatePublished, false),
+				version("edge", 5, model.StatePublished, false),
+				version("older", 6, model.StatePublished, false),
+			},
+			want: []string{"older"},
+		},
+		{
+			name:   "keeps tagged versions and the workflow",
+			policy: retentionPolicy{KeepAllDays: 1, MaxAgeDays: 10},
+			versions: []model.Version{
+				version("current", 0, model.StatePublished, false),
+				version("draft", 30, model.StateDraft, false),
+				version("pending", 30, model.StatePendingReview, false),
+				version("tagged", 30, model.StatePublished, true),
+				version("approved", 30, model.StateApproved, false),
+			},
+			want: []string{"approved"},
+		},
+		{
+			name:   "keeps one version per interval",
+			policy: retentionPolicy{KeepAllDays: 5, IntervalDays: 10},
+			versions: []model.Version{
+				version("current", 0, model.StatePublished, false),
+				version("a", 31, model.StatePublished, false), // day 969
+				version("b", 35, model.StatePublished, false), // day 965, same interval as a
+				version("c", 41, model.StatePublished, false), // day 959
+				version("d", 49, model.StatePublished, false), // day 951, same interval as c
+			},
+			want: []string{"b", "d"},
+		},
+		{
+			name:   "prunes past the maximum age even once per interval",
+			policy: retentionPolicy{KeepAllDays: 5, IntervalDays: 10, MaxAgeDays: 100},
+			versions: []model.Version{
+				version("current", 0, model.StatePublished, false),
+				version("kept", 50, model.StatePublished, false),
+				version("old", 150, model.StatePublished, false),
+			},
+			want: []string{"old"},
+		},
+		{
+			name:     "nothing to prune",
+			policy:   retentionPolicy{KeepAllDays: 5},
+			versions: []model.Version{version("current", 100, model.StatePublished, false)},
+			want:     nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, v := range tt.policy.prunable(tt.versions, now) {
+				got = append(got, v.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("prunable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeepsHistory(t *testing.T) {
+	tests := []struct {
+		policy retentionPolicy
+		want   bool
+	}{
+		{retentionPolicy{}, false},
+		{retentionPolicy{MaxAgeDays: 30}, false},
+		{retentionPolicy{KeepAllDays: 7}, true},
+		{retentionPolicy{IntervalDays: 30}, true},
+	}
+
+	for _, tt := range tests {
+		if got := tt.policy.keepsHistory(); got != tt.want {
+			t.Errorf("%+v.keepsHistory() = %v, want %v", tt.policy, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,546 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/laWiki/version/config"
+	"github.com/laWiki/version/database"
+	"github.com/laWiki/version/model"
+	"github.com/laWiki/version/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuthorHistory counts the versions of an author by review state
+type AuthorHistory struct {
+	Total    int `json:"total"`
+	Pending  int `json:"pending"`
+	Approved int `json:"approved"`
+	Rejected int `json:"rejected"`
+}
+
+// QueueItem is a version waiting for review
+type QueueItem struct {
+	Version       model.Version `json:"version"`
+	CurrentID     string        `json:"current_id,omitempty"`
+	Added         int           `json:"added"`
+	Removed       int           `json:"removed"`
+	AuthorHistory AuthorHistory `json:"author_history"`
+	AgeSeconds    int64         `json:"age_seconds"`
+	DueAt         time.Time     `json:"due_at"`
+	Overdue       bool          `json:"overdue"`
+}
+
+// QueueStats is the SLA report of the reviews submitted in a period
+type QueueStats struct {
+	From                   time.Time `json:"from"`
+	To                     time.Time `json:"to"`
+	Submitted              int       `json:"submitted"`
+	Pending                int       `json:"pending"`
+	Approved               int       `json:"approved"`
+	Rejected               int       `json:"rejected"`
+	Overdue                int       `json:"overdue"`
+	AvgSecondsToFirstClaim float64   `json:"avg_seconds_to_first_claim"`
+	AvgSecondsToDecision   float64   `json:"avg_seconds_to_decision"`
+	SLAHours               float64   `json:"sla_hours"`
+}
+
+// moderationScope remembers which wikis the requester moderates, so every wiki is fetched once per request
+type moderationScope struct {
+	req   requester
+	wikis map[string]bool
+}
+
+func newModerationScope(req requester) *moderationScope {
+	return &moderationScope{req: req, wikis: map[string]bool{}}
+}
+
+func (s *moderationScope) moderates(version model.Version) (bool, error) {
+	if s.req.Internal || s.req.Role == "admin" {
+		return true, nil
+	}
+	wikiID, err := versionWikiID(version)
+	if err != nil {
+		return false, err
+	}
+	if moderates, ok := s.wikis[wikiID]; ok {
+		return moderates, nil
+	}
+	wiki, err := fetchWiki(wikiID)
+	if err != nil {
+		return false, err
+	}
+	s.wikis[wikiID] = canModerate(s.req, wiki)
+	return s.wikis[wikiID], nil
+}
+
+// submittedAt is when a version entered the queue
+func submittedAt(version model.Version) time.Time {
+	if version.SubmittedAt.IsZero() {
+		return version.CreatedAt
+	}
+	return version.SubmittedAt
+}
+
+// dueAt is the deadline of the review of a version
+func dueAt(version model.Version) time.Time {
+	return submittedAt(version).Add(config.App.ReviewSLA)
+}
+
+// GetReviewQueue godoc
+// @Summary      Review queue
+// @Description  Lists the versions pending review in the wikis moderated by the requester, oldest first. Every item has the diff stats against the current published version, the history of the author and the SLA deadline.
+// @Tags         Review
+// @Produce      application/json
+// @Param        wikiID   query     string  false  "Wiki ID to limit the queue to"
+// @Param        claimed  query     string  false  "mine or unclaimed"
+// @Param        limit    query     int     false  "Maximum number of items (default 50)"
+// @Success      200      {array}   QueueItem
+// @Success      204      {string}  string  "No Content"
+// @Failure      400      {string}  string  "Bad Request"
+// @Failure      401      {string}  string  "Unauthorized"
+// @Failure      500      {string}  string  "Internal server error"
+// @Router       /api/versions/review-queue [get]
+func GetReviewQueue(w http.ResponseWriter, r *http.Request) {
+	wikiID := r.URL.Query().Get("wikiID")
+	claimed := r.URL.Query().Get("claimed")
+	limitString := r.URL.Query().Get("limit")
+
+	req := getRequester(r)
+	if req.ID == "" && !req.Internal {
+		config.App.Logger.Warn().Msg("Review queue without identity")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit := 50
+	if limitString != "" {
+		parsed, err := strconv.Atoi(limitString)
+		if err != nil || parsed <= 0 {
+			config.App.Logger.Error().Str("limit", limitString).Msg("Invalid limit")
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	filter := bson.M{"state": model.StatePendingReview}
+	if wikiID != "" {
+		filter["wiki_id"] = wikiID
+	}
+	switch claimed {
+	case "":
+	case "mine":
+		filter["claimed_by"] = req.ID
+		filter["claimed_at"] = bson.M{"$gt": time.Now().Add(-config.App.ReviewClaimTTL)}
+	case "unclaimed":
+		filter["$or"] = bson.A{
+			bson.M{"claimed_by": bson.M{"$in": bson.A{"", nil}}},
+			bson.M{"claimed_at": bson.M{"$lte": time.Now().Add(-config.App.ReviewClaimTTL)}},
+		}
+	default:
+		config.App.Logger.Error().Str("claimed", claimed).Msg("Invalid claimed filter")
+		http.Error(w, "Invalid claimed filter, use 'mine' or 'unclaimed'", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "submitted_at", Value: 1}, {Key: "created_at", Value: 1}})
+	cursor, err := database.VersionCollection.Find(ctx, filter, opts)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	scope := newModerationScope(req)
+	var versions []model.Version
+	for cursor.Next(ctx) && len(versions) < limit {
+		var version model.Version
+		if err := cursor.Decode(&version); err != nil {
+			config.App.Logger.Error().Err(err).Msg("Failed to decode version")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		moderates, err := scope.moderates(version)
+		if err != nil {
+			config.App.Logger.Error().Err(err).Str("versionID", version.ID).Msg("Failed to retrieve the moderators of the wiki")
+			http.Error(w, "Failed to retrieve the moderators of the wiki", http.StatusInternalServerError)
+			return
+		}
+		if moderates {
+			versions = append(versions, version)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Cursor error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if len(versions) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := expandVersions(ctx, versions); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to rebuild the content of the versions")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	histories, err := authorHistories(ctx, versions)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to retrieve the history of the authors")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	currents := map[string]*model.Version{}
+	items := make([]QueueItem, 0, len(versions))
+	for _, version := range versions {
+		current, ok := currents[version.EntryID]
+		if !ok {
+			current, err = currentVersion(ctx, version.EntryID)
+			if err != nil && err != mongo.ErrNoDocuments {
+				config.App.Logger.Error().Err(err).Msg("Database error")
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			currents[version.EntryID] = current
+		}
+
+		item := QueueItem{
+			Version:       version,
+			AuthorHistory: histories[version.Editor],
+			DueAt:         dueAt(version),
+		}
+		previousContent := ""
+		if current != nil {
+			item.CurrentID = current.ID
+			previousContent = current.Content
+		}
+		item.Added, item.Removed = utils.DiffStats(utils.Diff(previousContent, version.Content))
+		item.AgeSeconds = int64(now.Sub(submittedAt(version)).Seconds())
+		item.Overdue = now.After(item.DueAt)
+		items = append(items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(items); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// authorHistories counts the versions of the authors of the given versions by state
+func authorHistories(ctx context.Context, versions []model.Version) (map[string]AuthorHistory, error) {
+	var editors bson.A
+	for _, version := range versions {
+		editors = append(editors, version.Editor)
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"editor": bson.M{"$in": editors}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"editor": "$editor", "state": "$state"},
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+	cursor, err := database.VersionCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		ID struct {
+			Editor string `bson:"editor"`
+			State  string `bson:"state"`
+		} `bson:"_id"`
+		Count int `bson:"count"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	histories := map[string]AuthorHistory{}
+	for _, result := range results {
+		history := histories[result.ID.Editor]
+		history.Total += result.Count
+		switch result.ID.State {
+		case model.StatePendingReview:
+			history.Pending += result.Count
+		case model.StateRejected:
+			history.Rejected += result.Count
+		case model.StateApproved, model.StatePublished, "":
+			history.Approved += result.Count
+		}
+		histories[result.ID.Editor] = history
+	}
+	return histories, nil
+}
+
+// ClaimVersion godoc
+// @Summary      Claim a version for review
+// @Description  Marks a pending version as being reviewed by the requester, so other moderators skip it. Claims expire after the configured time.
+// @Tags         Review
+// @Produce      application/json
+// @Param        id   path      string  true  "Version ID"
+// @Success      200  {object}  model.Version
+// @Failure      400  {string}  string  "Invalid ID"
+// @Failure      401  {string}  string  "Unauthorized"
+// @Failure      403  {string}  string  "Forbidden"
+// @Failure      404  {string}  string  "Version not found"
+// @Failure      409  {string}  string  "Version is claimed by another moderator"
+// @Failure      500  {string}  string  "Internal server error"
+// @Router       /api/versions/{id}/claim [post]
+func ClaimVersion(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req := getRequester(r)
+	if req.ID == "" {
+		config.App.Logger.Warn().Msg("Claim without identity")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	objID, version, ok := loadVersion(w, r, ctx)
+	if !ok {
+		return
+	}
+	if !checkReviewer(w, req, *version) {
+		return
+	}
+
+	// the filter makes the claim atomic: it only matches if nobody else got a live claim in the meantime
+	now := time.Now().UTC()
+	filter := bson.M{
+		"_id":   objID,
+		"state": model.StatePendingReview,
+		"$or": bson.A{
+			bson.M{"claimed_by": bson.M{"$in": bson.A{"", nil, req.ID}}},
+			bson.M{"claimed_at": bson.M{"$lte": now.Add(-config.App.ReviewClaimTTL)}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{"claimed_by": req.ID, "claimed_at": now},
+		"$min": bson.M{"first_claimed_at": now},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	updated := make([]model.Version, 1)
+	err := database.VersionCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated[0])
+	if err == nil {
+		err = expandVersions(ctx, updated)
+	}
+	if err == mongo.ErrNoDocuments {
+		config.App.Logger.Warn().Str("versionID", version.ID).Msg("Version claimed by another moderator")
+		http.Error(w, "Version is claimed by another moderator", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to claim version")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	config.App.Logger.Info().Str("versionID", updated[0].ID).Str("userID", req.ID).Msg("Version claimed")
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(updated[0]); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// UnclaimVersion godoc
+// @Summary      Release a claimed version
+// @Description  Releases the claim on a version so other moderators can review it. Only the moderator holding the claim, a moderator of the wiki or an admin can release it.
+// @Tags         Review
+// @Produce      application/json
+// @Param        id   path      string  true  "Version ID"
+// @Success      200  {object}  model.Version
+// @Failure      400  {string}  string  "Invalid ID"
+// @Failure      401  {string}  string  "Unauthorized"
+// @Failure      403  {string}  string  "Forbidden"
+// @Failure      404  {string}  string  "Version not found"
+// @Failure      500  {string}  string  "Internal server error"
+// @Router       /api/versions/{id}/unclaim [post]
+func UnclaimVersion(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req := getRequester(r)
+	if req.ID == "" && !req.Internal {
+		config.App.Logger.Warn().Msg("Unclaim without identity")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	objID, version, ok := loadVersion(w, r, ctx)
+	if !ok {
+		return
+	}
+
+	// anyone other than the claimer has to moderate the wiki of the version
+	if req.Internal || version.ClaimedBy == "" || version.ClaimedBy != req.ID {
+		moderates, err := moderatesVersion(req, *version)
+		if err != nil {
+			config.App.Logger.Error().Err(err).Msg("Failed to retrieve the moderators of the wiki")
+			http.Error(w, "Failed to retrieve the moderators of the wiki", http.StatusInternalServerError)
+			return
+		}
+		if !moderates {
+			config.App.Logger.Warn().Str("versionID", version.ID).Str("userID", req.ID).Msg("Unclaim by someone other than the claimer")
+			http.Error(w, "Forbidden: the version is claimed by another moderator", http.StatusForbidden)
+			return
+		}
+	}
+
+	updated, ok := updateReview(w, ctx, objID, bson.M{
+		"$unset": bson.M{"claimed_by": "", "claimed_at": ""},
+	})
+	if !ok {
+		return
+	}
+
+	config.App.Logger.Info().Str("versionID", updated.ID).Str("userID", req.ID).Msg("Version unclaimed")
+}
+
+// GetReviewQueueStats godoc
+// @Summary      Review SLA report
+// @Description  Reports the reviews of the versions submitted in a period: decisions, average time to the first claim and to the decision, and the reviews that missed the SLA.
+// @Tags         Review
+// @Produce      application/json
+// @Param        wikiID  query     string  false  "Wiki ID to limit the report to"
+// @Param        from    query     string  false  "Start of the period (RFC3339, defaults to 30 days ago)"
+// @Param        to      query     string  false  "End of the period (RFC3339, defaults to now)"
+// @Success      200     {object}  QueueStats
+// @Failure      400     {string}  string  "Bad Request"
+// @Failure      401     {string}  string  "Unauthorized"
+// @Failure      500     {string}  string  "Internal server error"
+// @Router       /api/versions/review-queue/stats [get]
+func GetReviewQueueStats(w http.ResponseWriter, r *http.Request) {
+	wikiID := r.URL.Query().Get("wikiID")
+	fromString := r.URL.Query().Get("from")
+	toString := r.URL.Query().Get("to")
+
+	req := getRequester(r)
+	if req.ID == "" && !req.Internal {
+		config.App.Logger.Warn().Msg("Review stats without identity")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	to := time.Now().UTC()
+	from := to.AddDate(0, 0, -30)
+	var err error
+	if fromString != "" {
+		if from, err = time.Parse(time.RFC3339, fromString); err != nil {
+			config.App.Logger.Error().Err(err).Str("from", fromString).Msg("Invalid from date")
+			http.Error(w, "Invalid 'from' date, use RFC3339", http.StatusBadRequest)
+			return
+		}
+	}
+	if toString != "" {
+		if to, err = time.Parse(time.RFC3339, toString); err != nil {
+			config.App.Logger.Error().Err(err).Str("to", toString).Msg("Invalid to date")
+			http.Error(w, "Invalid 'to' date, use RFC3339", http.StatusBadRequest)
+			return
+		}
+	}
+
+	filter := bson.M{"submitted_at": bson.M{"$gte": from, "$lte": to}}
+	if wikiID != "" {
+		filter["wiki_id"] = wikiID
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	// content is not needed for the report
+	opts := options.Find().SetProjection(bson.M{"content": 0, "delta": 0, "search_text": 0})
+	cursor, err := database.VersionCollection.Find(ctx, filter, opts)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var versions []model.Version
+	if err := cursor.All(ctx, &versions); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode versions")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	stats := QueueStats{From: from, To: to, SLAHours: config.App.ReviewSLA.Hours()}
+	scope := newModerationScope(req)
+	now := time.Now()
+	var claimTotal, decisionTotal time.Duration
+	var claimCount, decisionCount int
+	for _, version := range versions {
+		moderates, err := scope.moderates(version)
+		if err != nil {
+			config.App.Logger.Error().Err(err).Str("versionID", version.ID).Msg("Failed to retrieve the moderators of the wiki")
+			http.Error(w, "Failed to retrieve the moderators of the wiki", http.StatusInternalServerError)
+			return
+		}
+		// versions published without review have never been in the queue
+		if !moderates || version.State == model.StateDraft || (isPublished(version) && version.ReviewedAt.IsZero()) {
+			continue
+		}
+
+		stats.Submitted++
+		switch version.State {
+		case model.StatePendingReview:
+			stats.Pending++
+		case model.StateRejected:
+			stats.Rejected++
+		default:
+			stats.Approved++
+		}
+
+		if !version.FirstClaimedAt.IsZero() {
+			claimTotal += version.FirstClaimedAt.Sub(version.SubmittedAt)
+			claimCount++
+		}
+
+		decided := version.ReviewedAt
+		if decided.IsZero() {
+			decided = now
+		} else {
+			decisionTotal += version.ReviewedAt.Sub(version.SubmittedAt)
+			decisionCount++
+		}
+		if decided.After(dueAt(version)) {
+			stats.Overdue++
+		}
+	}
+	if claimCount > 0 {
+		stats.AvgSecondsToFirstClaim = claimTotal.Seconds() / float64(claimCount)
+	}
+	if decisionCount > 0 {
+		stats.AvgSecondsToDecision = decisionTotal.Seconds() / float64(decisionCount)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,392 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/laWiki/version/config"
+	"github.com/laWiki/version/database"
+	"github.com/laWiki/version/model"
+	"github.com/laWiki/version/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// doiPattern is the form of a DOI, once the resolver prefixes are removed
+var doiPattern = regexp.MustCompile(`^10\.\d{4,9}/\S+$`)
+
+// checkReferences validates the references of a version and normalizes them: names are trimmed and unique,
+// URLs absolute http(s) ones and DOIs without resolver prefix. A reference needs a URL, a title or a DOI.
+func checkReferences(references []model.Reference) ([]model.Reference, error) {
+	names := map[string]bool{}
+	for i := range references {
+		ref := &references[i]
+		ref.Name = strings.TrimSpace(ref.Name)
+		ref.URL = strings.TrimSpace(ref.URL)
+		ref.Title = strings.TrimSpace(ref.Title)
+		ref.Author = strings.TrimSpace(ref.Author)
+		ref.DOI = normalizeDOI(ref.DOI)
+		ref.AccessDate = ref.AccessDate.UTC()
+
+		if !utils.ValidCitationName(ref.Name) {
+			return nil, fmt.Errorf("reference %d: invalid name %q", i+1, ref.Name)
+		}
+		if names[ref.Name] {
+			return nil, fmt.Errorf("reference %q: duplicated name", ref.Name)
+		}
+		names[ref.Name] = true
+
+		if ref.URL == "" && ref.Title == "" && ref.DOI == "" {
+			return nil, fmt.Errorf("reference %q: a url, title or doi is required", ref.Name)
+		}
+		if ref.URL != "" {
+			u, err := url.Parse(ref.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("reference %q: invalid url", ref.Name)
+			}
+		}
+		if ref.DOI != "" && !doiPattern.MatchString(ref.DOI) {
+			return nil, fmt.Errorf("reference %q: invalid doi", ref.Name)
+		}
+	}
+	return references, nil
+}
+
+// normalizeDOI removes the resolver prefixes of a DOI. DOIs are case-insensitive, they are kept lowercase.
+func normalizeDOI(doi string) string {
+	doi = strings.ToLower(strings.TrimSpace(doi))
+	for _, prefix := range []string{"https://doi.org/", "http://doi.org/", "https://dx.doi.org/", "http://dx.doi.org/", "doi:"} {
+		doi = strings.TrimPrefix(doi, prefix)
+	}
+	return strings.TrimSpace(doi)
+}
+
+// referenceKey identifies the source of a reference across entries: its DOI, or else its URL without
+// fragment and trailing slash
+func referenceKey(ref model.Reference) string {
+	if ref.DOI != "" {
+		return "doi:" + ref.DOI
+	}
+	if ref.URL == "" {
+		return ""
+	}
+	u, err := url.Parse(ref.URL)
+	if err != nil {
+		return ref.URL
+	}
+	u.Fragment = ""
+	u.Host = strings.ToLower(u.Host)
+	u.Scheme = strings.ToLower(u.Scheme)
+	return strings.TrimSuffix(u.String(), "/")
+}
+
+// renderReferences turns the citations of the rendered HTML of a version into numbered footnotes, listed at
+// the end. References are numbered in order of first citation; those never cited follow.
+func renderReferences(version *model.Version, content string) string {
+	byName := map[string]model.Reference{}
+	for _, ref := range version.References {
+		byName[ref.Name] = ref
+	}
+	numbers := map[string]int{}
+	uses := map[string]int{}
+	var order []model.Reference
+
+	content = utils.RenderCitations(content, func(name string) (int, bool) {
+		ref, ok := byName[name]
+		if !ok {
+			return 0, false
+		}
+		if numbers[name] == 0 {
+			order = append(order, ref)
+			numbers[name] = len(order)
+		}
+		uses[name]++
+		return numbers[name], true
+	})
+	for _, ref := range version.References {
+		if numbers[ref.Name] == 0 {
+			order = append(order, ref)
+			numbers[ref.Name] = len(order)
+		}
+	}
+	if len(order) == 0 {
+		return content
+	}
+
+	var b strings.Builder
+	b.WriteString(content)
+	b.WriteString(`<ol class="references">`)
+	for _, ref := range order {
+		note, _ := utils.CitationAnchors(ref.Name, 0)
+		fmt.Fprintf(&b, `<li id="%s">`, html.EscapeString(note))
+		for n := 1; n <= uses[ref.Name]; n++ {
+			_, cite := utils.CitationAnchors(ref.Name, n)
+			fmt.Fprintf(&b, `<a class="backlink" href="#%s">^</a> `, html.EscapeString(cite))
+		}
+		b.WriteString(referenceHTML(ref))
+		b.WriteString(`</li>`)
+	}
+	b.WriteString(`</ol>`)
+	return b.String()
+}
+
+// referenceHTML formats a reference: author, title linked to its URL, DOI and access date
+func referenceHTML(ref model.Reference) string {
+	var parts []string
+	if ref.Author != "" {
+		parts = append(parts, html.EscapeString(ref.Author)+".")
+	}
+	title := ref.Title
+	if title == "" {
+		title = ref.URL
+	}
+	switch {
+	case ref.URL != "":
+		parts = append(parts, fmt.Sprintf(`<a href="%s" rel="nofollow noopener" target="_blank">%s</a>.`, html.EscapeString(ref.URL), html.EscapeString(title)))
+	case title != "":
+		parts = append(parts, "<cite>"+html.EscapeString(title)+"</cite>.")
+	}
+	if ref.DOI != "" {
+		parts = append(parts, fmt.Sprintf(`doi:<a href="https://doi.org/%s" rel="nofollow noopener" target="_blank">%s</a>.`, html.EscapeString(ref.DOI), html.EscapeString(ref.DOI)))
+	}
+	if !ref.AccessDate.IsZero() {
+		parts = append(parts, "Consultado el "+ref.AccessDate.Format("2006-01-02")+".")
+	}
+	return strings.Join(parts, " ")
+}
+
+// refreshCitations replaces the citation table rows of an entry with the references of its current version
+func refreshCitations(ctx context.Context, entryID string) error {
+	if _, err := database.CitationCollection.DeleteMany(ctx, bson.M{"source_entry_id": entryID}); err != nil {
+		return err
+	}
+
+	version, err := currentSource(ctx, entryID)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(version.References) == 0 {
+		return nil
+	}
+	wikiID, err := versionWikiID(*version)
+	if err != nil {
+		return err
+	}
+
+	counts := map[string]int{}
+	for _, cited := range utils.Citations(version.Content) {
+		counts[cited.Name] = cited.Count
+	}
+
+	now := time.Now().UTC()
+	docs := make([]interface{}, 0, len(version.References))
+	for _, ref := range version.References {
+		docs = append(docs, model.Citation{
+			WikiID:          wikiID,
+			SourceEntryID:   entryID,
+			SourceVersionID: version.ID,
+			Name:            ref.Name,
+			Key:             referenceKey(ref),
+			URL:             ref.URL,
+			Title:           ref.Title,
+			DOI:             ref.DOI,
+			Count:           counts[ref.Name],
+			UpdatedAt:       now,
+		})
+	}
+	_, err = database.CitationCollection.InsertMany(ctx, docs)
+	return err
+}
+
+// UncitedEntry is an entry whose current version cites no reference, with the number of references it lists
+type UncitedEntry struct {
+	ID         string `json:"id"`
+	Title      string `json:"title"`
+	References int    `json:"references"`
+}
+
+// GetUncitedEntries godoc
+// @Summary      Entries without citations
+// @Description  Lists the entries of a wiki whose current version doesn't cite any of its references, including those that list references without citing them. Redirects aren't listed.
+// @Tags         References
+// @Produce      application/json
+// @Param        wikiID  query     string  true  "Wiki ID"
+// @Success      200     {array}   UncitedEntry
+// @Success      204     {string}  string  "No Content"
+// @Failure      400     {string}  string  "WikiID is required"
+// @Failure      500     {string}  string  "Internal server error"
+// @Router       /api/versions/references/uncited [get]
+func GetUncitedEntries(w http.ResponseWriter, r *http.Request) {
+	wikiID := r.URL.Query().Get("wikiID")
+	if wikiID == "" {
+		config.App.Logger.Warn().Msg("Missing wikiID parameter")
+		http.Error(w, "WikiID is required", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := wikiEntries(wikiID)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Str("wikiID", wikiID).Msg("Failed to retrieve the entries of the wiki")
+		http.Error(w, "Failed to retrieve the entries of the wiki", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := database.CitationCollection.Find(ctx, bson.M{"wiki_id": wikiID})
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var citations []model.Citation
+	if err := cursor.All(ctx, &citations); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode citations")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	references := map[string]int{}
+	cited := map[string]bool{}
+	for _, citation := range citations {
+		references[citation.SourceEntryID]++
+		if citation.Count > 0 {
+			cited[citation.SourceEntryID] = true
+		}
+	}
+
+	var uncited []UncitedEntry
+	for _, entry := range entries {
+		if entry.RedirectTo == "" && !cited[entry.ID] {
+			uncited = append(uncited, UncitedEntry{ID: entry.ID, Title: entry.Title, References: references[entry.ID]})
+		}
+	}
+
+	if len(uncited) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(uncited); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// SharedReference is a source listed as a reference by several entries of a wiki
+type SharedReference struct {
+	Key     string   `json:"key"`
+	Title   string   `json:"title,omitempty"`
+	URL     string   `json:"url,omitempty"`
+	DOI     string   `json:"doi,omitempty"`
+	Entries []string `json:"entries"`
+}
+
+// GetSharedReferences godoc
+// @Summary      References reused across entries
+// @Description  Lists the sources, told apart by their DOI or else their URL, that several entries of a wiki list as references, the most reused first.
+// @Tags         References
+// @Produce      application/json
+// @Param        wikiID  query     string  true   "Wiki ID"
+// @Param        min     query     int     false  "Minimum number of entries listing the source (default 2)"
+// @Param        limit   query     int     false  "Maximum number of sources"
+// @Success      200     {array}   SharedReference
+// @Success      204     {string}  string  "No Content"
+// @Failure      400     {string}  string  "WikiID is required"
+// @Failure      500     {string}  string  "Internal server error"
+// @Router       /api/versions/references/shared [get]
+func GetSharedReferences(w http.ResponseWriter, r *http.Request) {
+	wikiID := r.URL.Query().Get("wikiID")
+	if wikiID == "" {
+		config.App.Logger.Warn().Msg("Missing wikiID parameter")
+		http.Error(w, "WikiID is required", http.StatusBadRequest)
+		return
+	}
+	min, err := strconv.Atoi(r.URL.Query().Get("min"))
+	if err != nil || min < 2 {
+		min = 2
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"wiki_id": wikiID, "key": bson.M{"$nin": bson.A{"", nil}}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     "$key",
+			"title":   bson.M{"$max": "$title"},
+			"url":     bson.M{"$max": "$url"},
+			"doi":     bson.M{"$max": "$doi"},
+			"entries": bson.M{"$addToSet": "$source_entry_id"},
+		}}},
+		{{Key: "$match", Value: bson.M{fmt.Sprintf("entries.%d", min-1): bson.M{"$exists": true}}}},
+	}
+	cursor, err := database.CitationCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		Key     string   `bson:"_id"`
+		Title   string   `bson:"title"`
+		URL     string   `bson:"url"`
+		DOI     string   `bson:"doi"`
+		Entries []string `bson:"entries"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode citations")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var shared []SharedReference
+	for _, group := range groups {
+		sort.Strings(group.Entries)
+		shared = append(shared, SharedReference{Key: group.Key, Title: group.Title, URL: group.URL, DOI: group.DOI, Entries: group.Entries})
+	}
+	sort.Slice(shared, func(i, j int) bool {
+		if len(shared[i].Entries) != len(shared[j].Entries) {
+			return len(shared[i].Entries) > len(shared[j].Entries)
+		}
+		return shared[i].Key < shared[j].Key
+	})
+	if len(shared) > limit {
+		shared = shared[:limit]
+	}
+
+	if len(shared) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(shared); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
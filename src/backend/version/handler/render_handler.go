@@ -0,0 +1,343 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/laWiki/version/config"
+	"github.com/laWiki/version/model"
+	"github.com/laWiki/version/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// policyTTL is how long the content policy of a wiki is reused when rendering
+const policyTTL = time.Minute
+
+type cachedPolicy struct {
+	policy    *utils.ContentPolicy
+	fetchedAt time.Time
+}
+
+var (
+	renderCacheOnce sync.Once
+	renderCache     *utils.RenderCache
+
+	policiesMutex sync.Mutex
+	wikiPolicies  = map[string]cachedPolicy{}
+)
+
+// cachedContentPolicy returns the content policy of a wiki, fetching it at most once per policyTTL
+func cachedContentPolicy(wikiID string) *utils.ContentPolicy {
+	policiesMutex.Lock()
+	cached, ok := wikiPolicies[wikiID]
+	policiesMutex.Unlock()
+	if ok && time.Since(cached.fetchedAt) < policyTTL {
+		return cached.policy
+	}
+
+	policy := contentPolicy(wikiID)
+	policiesMutex.Lock()
+	wikiPolicies[wikiID] = cachedPolicy{policy: policy, fetchedAt: time.Now()}
+	policiesMutex.Unlock()
+	return policy
+}
+
+// renderVersion renders the HTML of a version and its table of contents: templates are expanded, markdown is
+// rendered, the wiki links of the content are resolved, headings get their anchors and citations become
+// footnotes. HTML versions without templates, wiki links, headings or references need no rendering. If it
+// fails the version keeps only its source. Only single versions are rendered, lists return the source.
+func renderVersion(version *model.Version) {
+	markdown := version.Format == model.FormatMarkdown
+	templates := strings.Contains(version.Content, "{{")
+	citations := len(version.References) > 0 || strings.Contains(version.Content, "<ref")
+	version.TOC = tableOfContents(version.Content, markdown)
+	if !markdown && !templates && !citations && !strings.Contains(version.Content, "[[") && len(version.TOC) == 0 {
+		return
+	}
+	renderCacheOnce.Do(func() {
+		renderCache = utils.NewRenderCache(config.App.RenderCacheSize)
+	})
+
+	wikiID, err := versionWikiID(*version)
+	if err != nil {
+		config.App.Logger.Warn().Err(err).Str("versionID", version.ID).Msg("Failed to retrieve the wiki of the version, using the default content policy")
+	}
+
+	// the renderer drops the raw HTML of markdown, citations go through it as placeholders
+	source := version.Content
+	if markdown && citations {
+		source = utils.MarkCitations(source)
+	}
+
+	html := source
+	switch {
+	case templates && wikiID != "":
+		html, err = renderTemplates(wikiID, source, markdown)
+	case markdown:
+		html, err = renderCache.Render(source, cachedContentPolicy(wikiID))
+	}
+	if err != nil {
+		config.App.Logger.Error().Err(err).Str("versionID", version.ID).Msg("Failed to render the version")
+		return
+	}
+	if wikiID != "" && strings.Contains(html, "[[") {
+		resolved := resolveTitles(wikiID, utils.WikiLinks(html))
+		html = utils.ResolveWikiLinks(html, wikiID, func(title string) (string, bool) {
+			entryID, ok := resolved[title]
+			return entryID, ok
+		})
+	}
+	if !markdown && len(version.TOC) > 0 {
+		html = utils.AnchorHeadings(html)
+	}
+	if citations {
+		html = renderReferences(version, html)
+	}
+	version.HTML = html
+}
+
+// tableOfContents returns the headings of a content
+func tableOfContents(content string, markdown bool) []model.TOCEntry {
+	var toc []model.TOCEntry
+	for _, section := range utils.Sections(content, markdown) {
+		toc = append(toc, model.TOCEntry{Index: section.Index, Level: section.Level, Title: section.Title, Anchor: section.Anchor})
+	}
+	return toc
+}
+
+// renderTemplates expands the templates a content includes and renders it. The result is reused until one of
+// the templates changes, or a missing one is created. Expanded HTML is sanitized again, since parameters and
+// bodies of templates are only sanitized on their own.
+func renderTemplates(wikiID string, content string, markdown bool) (string, error) {
+	policy := cachedContentPolicy(wikiID)
+	key := wikiID + "|" + utils.RenderKey(content, policy)
+	if markdown {
+		key += "|" + model.FormatMarkdown
+	}
+	if html, ok := renderCache.Get(key); ok {
+		return html, nil
+	}
+
+	var tags []string
+	expanded, used := utils.ExpandTemplates(content, func(name string) (utils.Template, bool) {
+		tags = append(tags, templateTag(wikiID, name))
+		return findTemplate(wikiID, name)
+	})
+
+	html := utils.Sanitize(expanded, policy)
+	if markdown {
+		var err error
+		if html, err = utils.RenderMarkdown(expanded, policy); err != nil {
+			return "", err
+		}
+	}
+	renderCache.Add(key, html, append(tags, used...))
+	return html, nil
+}
+
+// templateTag tags the contents rendered with a template name of a wiki, whatever entry it led to
+func templateTag(wikiID string, name string) string {
+	return wikiID + "|" + name
+}
+
+type cachedTemplate struct {
+	template  utils.Template
+	found     bool
+	fetchedAt time.Time
+}
+
+var (
+	templatesMutex sync.Mutex
+	templates      = map[string]cachedTemplate{}
+)
+
+// findTemplate returns the template of a wiki with the given name: the current content of the template entry
+// the name leads to, like a wiki link. Lookups that fail count as missing templates, but aren't cached.
+func findTemplate(wikiID string, name string) (utils.Template, bool) {
+	key := templateTag(wikiID, name)
+	templatesMutex.Lock()
+	cached, ok := templates[key]
+	templatesMutex.Unlock()
+	if ok && time.Since(cached.fetchedAt) < linkTTL {
+		return cached.template, cached.found
+	}
+
+	var entry entryInfo
+	resolveURL := fmt.Sprintf("%s/api/entries/resolve?title=%s&wikiID=%s", config.App.API_GATEWAY_URL, url.QueryEscape(name), url.QueryEscape(wikiID))
+	err := fetchJSON(resolveURL, &entry)
+	if err != nil && !errors.Is(err, errNotFound) {
+		config.App.Logger.Warn().Err(err).Str("wikiID", wikiID).Str("template", name).Msg("Failed to resolve template")
+		return utils.Template{}, false
+	}
+
+	cached = cachedTemplate{fetchedAt: time.Now()}
+	if entry.Kind == entryKindTemplate {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		version, err := currentSource(ctx, entry.ID)
+		cancel()
+		if err != nil && err != mongo.ErrNoDocuments {
+			config.App.Logger.Warn().Err(err).Str("entryID", entry.ID).Str("template", name).Msg("Failed to retrieve the content of the template")
+			return utils.Template{}, false
+		}
+		if err == nil {
+			cached.template = utils.Template{ID: entry.ID, Content: version.Content}
+			cached.found = true
+		}
+	}
+
+	templatesMutex.Lock()
+	templates[key] = cached
+	templatesMutex.Unlock()
+	return cached.template, cached.found
+}
+
+// invalidateTemplate drops the cached content of a template entry and every content rendered with it
+func invalidateTemplate(entryID string) {
+	templatesMutex.Lock()
+	for key, cached := range templates {
+		if cached.template.ID == entryID {
+			delete(templates, key)
+		}
+	}
+	templatesMutex.Unlock()
+	if renderCache != nil {
+		renderCache.Invalidate(entryID)
+	}
+}
+
+// linkTTL is how long a resolved wiki link is reused, so a new entry turns its red links blue soon enough
+const linkTTL = time.Minute
+
+type resolvedLink struct {
+	entryID    string
+	resolvedAt time.Time
+}
+
+var (
+	linksMutex sync.Mutex
+	links      = map[string]resolvedLink{}
+)
+
+// resolveTimeout bounds the time spent resolving the wiki links of a version. resolveWorkers is the number
+// of titles looked up at once.
+const (
+	resolveTimeout = 2 * time.Second
+	resolveWorkers = 8
+)
+
+// resolveTitles resolves the titles of the wiki links of a content at once, returning the ID of the entry
+// each found title leads to. Titles not resolved within resolveTimeout are left out, as missing entries;
+// their lookups go on and are cached for the next read.
+func resolveTitles(wikiID string, wikiLinks []utils.WikiLink) map[string]string {
+	type result struct {
+		title   string
+		entryID string
+		found   bool
+	}
+
+	titles := map[string]bool{}
+	for _, link := range wikiLinks {
+		titles[link.Title] = true
+	}
+	results := make(chan result, len(titles))
+	slots := make(chan struct{}, resolveWorkers)
+	for title := range titles {
+		go func(title string) {
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			entryID, found := resolveTitle(wikiID, title)
+			results <- result{title: title, entryID: entryID, found: found}
+		}(title)
+	}
+
+	resolved := map[string]string{}
+	timeout := time.NewTimer(resolveTimeout)
+	defer timeout.Stop()
+	for range titles {
+		select {
+		case r := <-results:
+			if r.found {
+				resolved[r.title] = r.entryID
+			}
+		case <-timeout.C:
+			config.App.Logger.Warn().Str("wikiID", wikiID).Int("titles", len(titles)).Int("resolved", len(resolved)).Msg("Wiki links not resolved in time")
+			return resolved
+		}
+	}
+	return resolved
+}
+
+// resolveTitle returns the ID of the entry of a wiki a title leads to, by its title or an alias and following
+// redirects. Lookups that fail count as missing entries, but aren't cached.
+func resolveTitle(wikiID string, title string) (string, bool) {
+	entryID, err := lookupTitle(wikiID, title)
+	if err != nil {
+		config.App.Logger.Warn().Err(err).Str("wikiID", wikiID).Str("title", title).Msg("Failed to resolve wiki link")
+		return "", false
+	}
+	return entryID, entryID != ""
+}
+
+// lookupTitle returns the ID of the entry a title leads to, "" when there is none. Unlike resolveTitle it
+// tells the titles without an entry from the ones that couldn't be looked up.
+func lookupTitle(wikiID string, title string) (string, error) {
+	key := wikiID + "|" + title
+	linksMutex.Lock()
+	link, ok := links[key]
+	linksMutex.Unlock()
+	if ok && time.Since(link.resolvedAt) < linkTTL {
+		return link.entryID, nil
+	}
+
+	var entry entryInfo
+	resolveURL := fmt.Sprintf("%s/api/entries/resolve?title=%s&wikiID=%s", config.App.API_GATEWAY_URL, url.QueryEscape(title), url.QueryEscape(wikiID))
+	err := fetchJSON(resolveURL, &entry)
+	if err != nil && !errors.Is(err, errNotFound) {
+		return "", err
+	}
+
+	link = resolvedLink{entryID: entry.ID, resolvedAt: time.Now()}
+	linksMutex.Lock()
+	// expired links are dropped once there are as many as rendered contents are cached
+	if len(links) >= config.App.RenderCacheSize {
+		for key, cached := range links {
+			if time.Since(cached.resolvedAt) >= linkTTL {
+				delete(links, key)
+			}
+		}
+	}
+	links[key] = link
+	linksMutex.Unlock()
+	return link.entryID, nil
+}
+
+// forgetTitles drops what is cached about titles of a wiki whose entries have changed: where their links
+// lead, the templates they name and the contents rendered with those templates
+func forgetTitles(wikiID string, titles []string) {
+	linksMutex.Lock()
+	for _, title := range titles {
+		delete(links, wikiID+"|"+title)
+	}
+	linksMutex.Unlock()
+
+	templatesMutex.Lock()
+	for _, title := range titles {
+		delete(templates, templateTag(wikiID, title))
+	}
+	templatesMutex.Unlock()
+	if renderCache != nil {
+		for _, title := range titles {
+			renderCache.Invalidate(templateTag(wikiID, title))
+		}
+	}
+}
+
+// validFormat reports whether a version format is known. Versions without a format are HTML.
+func validFormat(format string) bool {
+	return format == "" || format == model.FormatHTML || format == model.FormatMarkdown
+}
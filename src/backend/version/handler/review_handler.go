@@ -0,0 +1,726 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/laWiki/version/config"
+	"github.com/laWiki/version/database"
+	"github.com/laWiki/version/model"
+	"github.com/laWiki/version/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Headers set by the gateway with the user making the request
+const (
+	userIDHeader   = "X-User-ID"
+	userRoleHeader = "X-User-Role"
+)
+
+// requester is the user making a request, as forwarded by the gateway
+type requester struct {
+	ID       string
+	Role     string
+	Internal bool
+}
+
+func getRequester(r *http.Request) requester {
+	return requester{
+		ID:       r.Header.Get(userIDHeader),
+		Role:     r.Header.Get(userRoleHeader),
+		Internal: r.Header.Get("X-Internal-Auth") == config.App.JWTSecret,
+	}
+}
+
+// publishedFilter matches the versions visible to everyone
+func publishedFilter() bson.M {
+	return bson.M{"state": bson.M{"$in": bson.A{model.StatePublished, nil}}}
+}
+
+// visibilityFilter matches the versions the requester can see: moderators see everything in the wikis they
+// moderate, authors see the published versions and their own, everyone else only the published ones.
+// Versions stored before they knew their wiki are shown to editors, as the wikis without moderators are.
+func visibilityFilter(req requester) bson.M {
+	if req.Internal || req.Role == "admin" {
+		return nil
+	}
+	if req.ID == "" {
+		return publishedFilter()
+	}
+	visible := bson.A{publishedFilter(), bson.M{"editor": req.ID}}
+	wikiIDs, err := moderatedWikis(req)
+	if err != nil {
+		config.App.Logger.Warn().Err(err).Str("userID", req.ID).Msg("Failed to retrieve the wikis moderated by the requester, showing the published versions only")
+		return bson.M{"$or": visible}
+	}
+	if len(wikiIDs) > 0 {
+		visible = append(visible, bson.M{"wiki_id": bson.M{"$in": wikiIDs}})
+	}
+	if req.Role == "editor" {
+		visible = append(visible, bson.M{"wiki_id": bson.M{"$in": bson.A{"", nil}}})
+	}
+	return bson.M{"$or": visible}
+}
+
+// moderatedWikis returns the IDs of the wikis the requester moderates
+func moderatedWikis(req requester) ([]string, error) {
+	var wikis []wikiInfo
+	if err := fetchJSON(fmt.Sprintf("%s/api/wikis", config.App.API_GATEWAY_URL), &wikis); err != nil {
+		return nil, err
+	}
+	var wikiIDs []string
+	for i := range wikis {
+		if canModerate(req, &wikis[i]) {
+			wikiIDs = append(wikiIDs, wikis[i].ID)
+		}
+	}
+	return wikiIDs, nil
+}
+
+// applyVisibility restricts a filter to the versions visible to the requester
+func applyVisibility(filter bson.M, r *http.Request) bson.M {
+	visibility := visibilityFilter(getRequester(r))
+	if visibility == nil {
+		return filter
+	}
+	if len(filter) == 0 {
+		return visibility
+	}
+	return bson.M{"$and": bson.A{filter, visibility}}
+}
+
+// isVisible reports whether a single version can be seen by the requester
+func isVisible(version model.Version, r *http.Request) bool {
+	req := getRequester(r)
+	if isPublished(version) || (req.ID != "" && req.ID == version.Editor) {
+		return true
+	}
+	if req.ID == "" && !req.Internal {
+		return false
+	}
+	moderates, err := moderatesVersion(req, version)
+	if err != nil {
+		config.App.Logger.Warn().Err(err).Str("versionID", version.ID).Msg("Failed to retrieve the moderators of the wiki, hiding the version")
+		return false
+	}
+	return moderates
+}
+
+func isPublished(version model.Version) bool {
+	return version.State == "" || version.State == model.StatePublished
+}
+
+// entryKindTemplate is the kind of the entries other entries include as templates
+const entryKindTemplate = "template"
+
+// entryInfo is the part of an entry the version service needs
+type entryInfo struct {
+	ID         string           `json:"id"`
+	Author     string           `json:"author"`
+	Title      string           `json:"title"`
+	WikiID     string           `json:"wiki_id"`
+	RedirectTo string           `json:"redirect_to"`
+	Kind       string           `json:"kind"`
+	Protection *entryProtection `json:"protection"`
+}
+
+// entryProtection is the protection of an entry, enforced when adding versions
+type entryProtection struct {
+	Level     string    `json:"level"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// allows reports whether the requester can add versions to an entry with this protection
+func (p *entryProtection) allows(req requester) bool {
+	if p == nil || req.Internal || (!p.ExpiresAt.IsZero() && p.ExpiresAt.Before(time.Now())) {
+		return true
+	}
+	switch p.Level {
+	case "semi":
+		return req.Role == "editor" || req.Role == "admin"
+	case "full":
+		return req.Role == "admin"
+	}
+	return true
+}
+
+// wikiInfo is the part of a wiki the version service needs
+type wikiInfo struct {
+	ID            string               `json:"id"`
+	Title         string               `json:"title"`
+	RequireReview []string             `json:"require_review"`
+	Moderators    []string             `json:"moderators"`
+	Retention     *retentionPolicy     `json:"retention"`
+	ContentPolicy *utils.ContentPolicy `json:"content_policy"`
+}
+
+// fetchEntry retrieves an entry from the entry service
+func fetchEntry(entryID string) (*entryInfo, error) {
+	var entry entryInfo
+	if err := fetchJSON(fmt.Sprintf("%s/api/entries/%s", config.App.API_GATEWAY_URL, entryID), &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// fetchWiki retrieves a wiki from the wiki service
+func fetchWiki(wikiID string) (*wikiInfo, error) {
+	var wiki wikiInfo
+	if err := fetchJSON(fmt.Sprintf("%s/api/wikis/%s", config.App.API_GATEWAY_URL, wikiID), &wiki); err != nil {
+		return nil, err
+	}
+	return &wiki, nil
+}
+
+// errNotFound is returned by fetchJSON when the resource doesn't exist
+var errNotFound = errors.New("not found")
+
+// fetchJSON sends an internal GET request through the gateway and decodes the JSON response
+func fetchJSON(url string, out interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Internal-Auth", config.App.JWTSecret)
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// nothing found, out is left as is
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("GET %s: %w", url, errNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("GET %s returned %d: %s", url, resp.StatusCode, string(bodyBytes))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// requiresReview checks the policy of the wiki for the role of the requester. Only internal calls skip
+// review; requests without a role are always reviewed.
+func requiresReview(wikiID string, req requester) (bool, error) {
+	if req.Internal {
+		return false, nil
+	}
+	if req.Role == "" {
+		return true, nil
+	}
+	wiki, err := fetchWiki(wikiID)
+	if err != nil {
+		return false, err
+	}
+	return contains(wiki.RequireReview, req.Role), nil
+}
+
+// versionWikiID returns the wiki of a version. Versions created before the wiki was stored
+// in them are resolved through their entry.
+func versionWikiID(version model.Version) (string, error) {
+	if version.WikiID != "" {
+		return version.WikiID, nil
+	}
+	entry, err := fetchEntry(version.EntryID)
+	if err != nil {
+		return "", err
+	}
+	return entry.WikiID, nil
+}
+
+// canModerate reports whether the requester moderates a wiki: admins moderate every wiki,
+// and editors moderate the wikis without an explicit list of moderators.
+func canModerate(req requester, wiki *wikiInfo) bool {
+	if req.Internal || req.Role == "admin" {
+		return true
+	}
+	if req.ID == "" {
+		return false
+	}
+	if len(wiki.Moderators) == 0 {
+		return req.Role == "editor"
+	}
+	return contains(wiki.Moderators, req.ID)
+}
+
+// moderatesVersion reports whether the requester moderates the wiki of a version
+func moderatesVersion(req requester, version model.Version) (bool, error) {
+	if req.Internal || req.Role == "admin" {
+		return true, nil
+	}
+	wikiID, err := versionWikiID(version)
+	if err != nil {
+		return false, err
+	}
+	wiki, err := fetchWiki(wikiID)
+	if err != nil {
+		return false, err
+	}
+	return canModerate(req, wiki), nil
+}
+
+// claimedByOther reports whether another moderator holds a live claim on the version
+func claimedByOther(req requester, version model.Version) bool {
+	if version.ClaimedBy == "" || version.ClaimedBy == req.ID {
+		return false
+	}
+	return time.Since(version.ClaimedAt) < config.App.ReviewClaimTTL
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// submittedState is the state of a version once submitted by its author
+func submittedState(needsReview bool) string {
+	if needsReview {
+		return model.StatePendingReview
+	}
+	return model.StatePublished
+}
+
+// loadVersion decodes the version in the URL, writing the error response if it fails
+func loadVersion(w http.ResponseWriter, r *http.Request, ctx context.Context) (primitive.ObjectID, *model.Version, bool) {
+	id := chi.URLParam(r, "id")
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Invalid ID format")
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return objID, nil, false
+	}
+
+	version, err := findVersion(ctx, bson.M{"_id": objID})
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Version not found")
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return objID, nil, false
+	}
+
+	return objID, version, true
+}
+
+// updateReview applies a review update to a version and writes it back as the response
+func updateReview(w http.ResponseWriter, ctx context.Context, objID primitive.ObjectID, update bson.M) (*model.Version, bool) {
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	versions := make([]model.Version, 1)
+	err := database.VersionCollection.FindOneAndUpdate(ctx, bson.M{"_id": objID}, update, opts).Decode(&versions[0])
+	if err == nil {
+		err = expandVersions(ctx, versions)
+	}
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	version := versions[0]
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(version); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return &version, true
+}
+
+// GetCurrentVersion godoc
+// @Summary      Get the current version of an entry
+// @Description  Retrieves the latest published version of an entry. With asOf, the version that was the current one at that moment.
+// @Tags         Versions
+// @Produce      application/json
+// @Param        entryID  query     string  true  "Entry ID"
+// @Param        asOf     query     string  false  "Moment to read the current version at (RFC3339)"
+// @Success      200      {object}  model.Version
+// @Failure      400      {string}  string  "EntryID is required or invalid asOf date"
+// @Failure      404      {string}  string  "No published version found"
+// @Router       /api/versions/current [get]
+func GetCurrentVersion(w http.ResponseWriter, r *http.Request) {
+	entryID := r.URL.Query().Get("entryID")
+	if entryID == "" {
+		config.App.Logger.Warn().Msg("Missing entryID parameter")
+		http.Error(w, "EntryID is required", http.StatusBadRequest)
+		return
+	}
+
+	var asOf time.Time
+	if asOfString := r.URL.Query().Get("asOf"); asOfString != "" {
+		var err error
+		asOf, err = time.Parse(time.RFC3339, asOfString)
+		if err != nil {
+			config.App.Logger.Error().Err(err).Msg("Invalid 'asOf' date format. Expected ISO8601 format.")
+			http.Error(w, "Invalid 'asOf' date format. Expected ISO8601 format.", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var version *model.Version
+	var err error
+	if asOf.IsZero() {
+		version, err = currentVersion(ctx, entryID)
+	} else {
+		version, err = currentVersionAt(ctx, entryID, asOf)
+	}
+	if err == mongo.ErrNoDocuments {
+		config.App.Logger.Info().Str("entryID", entryID).Msg("No published version found")
+		http.Error(w, "No published version found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(version); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// currentVersion returns the latest published version of an entry
+func currentVersion(ctx context.Context, entryID string) (*model.Version, error) {
+	filter := publishedFilter()
+	filter["entry_id"] = entryID
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	return findVersion(ctx, filter, opts)
+}
+
+// currentSource returns the latest published version of an entry without rendering it
+func currentSource(ctx context.Context, entryID string) (*model.Version, error) {
+	filter := publishedFilter()
+	filter["entry_id"] = entryID
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	return findSource(ctx, filter, opts)
+}
+
+// currentVersionAt returns the version that was the current one of an entry at the given moment. A version
+// became visible when it was created, submitted or approved, whichever came last. Versions published later
+// are left out, as are the versions deleted or pruned since.
+func currentVersionAt(ctx context.Context, entryID string, asOf time.Time) (*model.Version, error) {
+	filter := publishedFilter()
+	filter["entry_id"] = entryID
+	filter["created_at"] = bson.M{"$lte": asOf}
+	filter["$and"] = bson.A{
+		bson.M{"$or": bson.A{bson.M{"submitted_at": nil}, bson.M{"submitted_at": bson.M{"$lte": asOf}}}},
+		bson.M{"$or": bson.A{bson.M{"reviewed_at": nil}, bson.M{"reviewed_at": bson.M{"$lte": asOf}}}},
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	return findVersion(ctx, filter, opts)
+}
+
+// SubmitVersion godoc
+// @Summary      Submit a version
+// @Description  Submits a draft (or rejected) version. Depending on the review policy of the wiki it is published or sent to review.
+// @Tags         Review
+// @Produce      application/json
+// @Param        id   path      string  true  "Version ID"
+// @Success      200  {object}  model.Version
+// @Failure      400  {string}  string  "Invalid ID"
+// @Failure      403  {string}  string  "Only the author can submit the version"
+// @Failure      404  {string}  string  "Version not found"
+// @Failure      409  {string}  string  "Version can't be submitted"
+// @Failure      500  {string}  string  "Internal server error"
+// @Router       /api/versions/{id}/submit [post]
+func SubmitVersion(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objID, version, ok := loadVersion(w, r, ctx)
+	if !ok {
+		return
+	}
+
+	req := getRequester(r)
+	if !req.Internal && req.ID != version.Editor {
+		config.App.Logger.Warn().Str("versionID", version.ID).Str("userID", req.ID).Msg("Submit by someone other than the author")
+		http.Error(w, "Only the author can submit the version", http.StatusForbidden)
+		return
+	}
+
+	if version.State != model.StateDraft && version.State != model.StateRejected {
+		config.App.Logger.Warn().Str("versionID", version.ID).Str("state", version.State).Msg("Version can't be submitted")
+		http.Error(w, "Only draft or rejected versions can be submitted", http.StatusConflict)
+		return
+	}
+
+	wikiID, err := versionWikiID(*version)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to retrieve entry information")
+		http.Error(w, "Failed to retrieve entry information", http.StatusInternalServerError)
+		return
+	}
+
+	needsReview, err := requiresReview(wikiID, req)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to retrieve the review policy")
+		http.Error(w, "Failed to retrieve the review policy", http.StatusInternalServerError)
+		return
+	}
+
+	// a new submission starts a new review cycle
+	updated, ok := updateReview(w, ctx, objID, bson.M{
+		"$set": bson.M{
+			"state":        submittedState(needsReview),
+			"submitted_at": time.Now().UTC(),
+			"wiki_id":      wikiID,
+		},
+		"$unset": bson.M{
+			"rejection_reason": "",
+			"reviewed_by":      "",
+			"reviewed_at":      "",
+			"claimed_by":       "",
+			"claimed_at":       "",
+			"first_claimed_at": "",
+		},
+	})
+	if !ok {
+		return
+	}
+
+	config.App.Logger.Info().Str("versionID", updated.ID).Str("state", updated.State).Msg("Version submitted")
+
+	if updated.State == model.StatePublished {
+		currentVersionChanged(updated.EntryID)
+	}
+	if updated.State == model.StatePublished && !updated.Minor {
+		notifyEntryAuthor(updated.EntryID)
+	}
+}
+
+// ApproveVersion godoc
+// @Summary      Approve a version
+// @Description  Approves a version pending review. It is published, unless a newer version has been published in the meantime, in which case it stays approved in the history.
+// @Tags         Review
+// @Produce      application/json
+// @Param        id   path      string  true  "Version ID"
+// @Success      200  {object}  model.Version
+// @Failure      400  {string}  string  "Invalid ID"
+// @Failure      403  {string}  string  "Forbidden"
+// @Failure      404  {string}  string  "Version not found"
+// @Failure      409  {string}  string  "Version is not pending review"
+// @Failure      500  {string}  string  "Internal server error"
+// @Router       /api/versions/{id}/approve [post]
+func ApproveVersion(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objID, version, ok := loadVersion(w, r, ctx)
+	if !ok {
+		return
+	}
+
+	req := getRequester(r)
+	if !checkReviewer(w, req, *version) {
+		return
+	}
+
+	// a newer published version keeps being the current one
+	newer := publishedFilter()
+	newer["entry_id"] = version.EntryID
+	newer["created_at"] = bson.M{"$gt": version.CreatedAt}
+	newerCount, err := database.VersionCollection.CountDocuments(ctx, newer)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	state := model.StatePublished
+	if newerCount > 0 {
+		state = model.StateApproved
+	}
+
+	updated, ok := updateReview(w, ctx, objID, bson.M{
+		"$set": bson.M{
+			"state":       state,
+			"reviewed_by": req.ID,
+			"reviewed_at": time.Now().UTC(),
+		},
+	})
+	if !ok {
+		return
+	}
+
+	config.App.Logger.Info().Str("versionID", updated.ID).Str("state", updated.State).Msg("Version approved")
+
+	if updated.State == model.StatePublished {
+		currentVersionChanged(updated.EntryID)
+	}
+
+	entry, err := fetchEntry(updated.EntryID)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to retrieve entry information")
+		return
+	}
+	notifyUser(updated.Editor, entry.Title,
+		"Tu modificación ha sido aprobada",
+		"Tu versión de la entrada \"{{ entrada }}\" ha sido aprobada.",
+		"Tu versión de la entrada "+entry.Title+" ha sido aprobada")
+	if updated.State == model.StatePublished && !updated.Minor {
+		notifyEntryAuthor(updated.EntryID)
+	}
+}
+
+// checkReviewer verifies that the requester can decide on a pending version, writing the error response otherwise
+func checkReviewer(w http.ResponseWriter, req requester, version model.Version) bool {
+	moderates, err := moderatesVersion(req, version)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to retrieve the moderators of the wiki")
+		http.Error(w, "Failed to retrieve the moderators of the wiki", http.StatusInternalServerError)
+		return false
+	}
+	if !moderates {
+		config.App.Logger.Warn().Str("userID", req.ID).Str("role", req.Role).Str("versionID", version.ID).Msg("Review without privileges")
+		http.Error(w, "Forbidden: insufficient privileges", http.StatusForbidden)
+		return false
+	}
+
+	if version.State != model.StatePendingReview {
+		config.App.Logger.Warn().Str("versionID", version.ID).Str("state", version.State).Msg("Version is not pending review")
+		http.Error(w, "Version is not pending review", http.StatusConflict)
+		return false
+	}
+
+	if claimedByOther(req, version) {
+		config.App.Logger.Warn().Str("versionID", version.ID).Str("claimedBy", version.ClaimedBy).Msg("Version claimed by another moderator")
+		http.Error(w, "Version is claimed by another moderator", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// RejectVersionRequest is the body of a rejection
+type RejectVersionRequest struct {
+	Reason string `json:"reason"`
+}
+
+// RejectVersion godoc
+// @Summary      Reject a version
+// @Description  Rejects a version pending review. A reason is required and sent to the author, who can edit and submit it again.
+// @Tags         Review
+// @Accept       application/json
+// @Produce      application/json
+// @Param        id      path      string                true  "Version ID"
+// @Param        reason  body      RejectVersionRequest  true  "Reason of the rejection"
+// @Success      200     {object}  model.Version
+// @Failure      400     {string}  string  "Invalid ID or missing reason"
+// @Failure      403     {string}  string  "Forbidden"
+// @Failure      404     {string}  string  "Version not found"
+// @Failure      409     {string}  string  "Version is not pending review"
+// @Failure      500     {string}  string  "Internal server error"
+// @Router       /api/versions/{id}/reject [post]
+func RejectVersion(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var body RejectVersionRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Reason == "" {
+		config.App.Logger.Error().Err(err).Msg("Missing rejection reason")
+		http.Error(w, "A reason is required", http.StatusBadRequest)
+		return
+	}
+
+	objID, version, ok := loadVersion(w, r, ctx)
+	if !ok {
+		return
+	}
+
+	req := getRequester(r)
+	if !checkReviewer(w, req, *version) {
+		return
+	}
+
+	updated, ok := updateReview(w, ctx, objID, bson.M{
+		"$set": bson.M{
+			"state":            model.StateRejected,
+			"reviewed_by":      req.ID,
+			"reviewed_at":      time.Now().UTC(),
+			"rejection_reason": body.Reason,
+		},
+	})
+	if !ok {
+		return
+	}
+
+	config.App.Logger.Info().Str("versionID", updated.ID).Str("reason", body.Reason).Msg("Version rejected")
+
+	entry, err := fetchEntry(updated.EntryID)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to retrieve entry information")
+		return
+	}
+	notifyUser(updated.Editor, entry.Title,
+		"Tu modificación ha sido rechazada",
+		"Tu versión de la entrada \"{{ entrada }}\" ha sido rechazada: "+escapeMailTemplate(body.Reason),
+		"Tu versión de la entrada "+entry.Title+" ha sido rechazada: "+body.Reason)
+}
+
+// escapeMailTemplate breaks the opening delimiters of the mail templates in text written by a user, so it
+// can't add variables or tags to the template it is put in
+func escapeMailTemplate(text string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(text); i++ {
+		escaped.WriteByte(text[i])
+		if text[i] == '{' && i+1 < len(text) && strings.IndexByte("{%#", text[i+1]) >= 0 {
+			escaped.WriteByte(' ')
+		}
+	}
+	return escaped.String()
+}
+
+// notifyUser sends a notification to a user, by email if enabled or internally otherwise
+func notifyUser(userID string, entryTitle string, subject string, emailMessage string, internalMessage string) {
+	var user struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		Email       string `json:"email"`
+		EnableMails bool   `json:"enable_mails"`
+	}
+	if err := fetchJSON(fmt.Sprintf("%s/api/auth/user?id=%s", config.App.API_GATEWAY_URL, userID), &user); err != nil {
+		config.App.Logger.Error().Err(err).Str("userID", userID).Msg("Failed to retrieve user information")
+		return
+	}
+
+	if user.EnableMails {
+		notifyEmail(subject,
+			"Hola {{ nombre }},\n"+emailMessage,
+			"<p> Hola {{ nombre }},</p><p>"+html.EscapeString(emailMessage)+"</p>",
+			user.Name,
+			user.Email,
+			entryTitle)
+	} else {
+		notifyInterno(internalMessage, userID)
+	}
+}
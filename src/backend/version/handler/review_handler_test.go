@@ -0,0 +1,22 @@
+package handler
+
+import "testing"
+
+func TestEscapeMailTemplate(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Falta una fuente", "Falta una fuente"},
+		{"Hola {{ nombre }}", "Hola { { nombre }}"},
+		{"{{{ entrada }}}", "{ { { entrada }}}"},
+		{"{% if x %}{# nota #}", "{ % if x %}{ # nota #}"},
+		{"{a} y {", "{a} y {"},
+	}
+
+	for _, tt := range tests {
+		if got := escapeMailTemplate(tt.text); got != tt.want {
+			t.Errorf("escapeMailTemplate(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
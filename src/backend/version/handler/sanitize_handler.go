@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/laWiki/version/config"
+	"github.com/laWiki/version/database"
+	"github.com/laWiki/version/model"
+	"github.com/laWiki/version/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// contentPolicy returns the content policy of a wiki. If the wiki can't be retrieved the default policy is used.
+func contentPolicy(wikiID string) *utils.ContentPolicy {
+	if wikiID == "" {
+		return nil
+	}
+	wiki, err := fetchWiki(wikiID)
+	if err != nil {
+		config.App.Logger.Warn().Err(err).Str("wikiID", wikiID).Msg("Failed to retrieve the content policy, using the default one")
+		return nil
+	}
+	return wiki.ContentPolicy
+}
+
+// sanitizeVersion sanitizes the content of a version and its translations in place, reporting whether
+// anything changed. Markdown is sanitized when it is rendered instead.
+func sanitizeVersion(version *model.Version, policy *utils.ContentPolicy) bool {
+	if version.Format == model.FormatMarkdown {
+		return false
+	}
+	changed := false
+	if content := utils.Sanitize(version.Content, policy); content != version.Content {
+		version.Content = content
+		changed = true
+	}
+	for lang, fields := range version.TranslatedFields {
+		if content, ok := fields["content"]; ok {
+			if sanitized := utils.Sanitize(content, policy); sanitized != content {
+				version.TranslatedFields[lang]["content"] = sanitized
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// SanitizedContent is a content sanitized with the content policy of the wiki of a version
+type SanitizedContent struct {
+	Content string `json:"content"`
+}
+
+// SanitizeContent godoc
+// @Summary      Sanitize a content for a version
+// @Description  Sanitizes an HTML content with the content policy of the wiki of a version. The comment service sanitizes the comments of the version with it. Only for internal calls.
+// @Tags         Versions
+// @Accept       application/json
+// @Produce      application/json
+// @Param        id       path      string            true  "Version ID"
+// @Param        content  body      SanitizedContent  true  "Content to sanitize"
+// @Success      200      {object}  SanitizedContent
+// @Failure      400      {string}  string  "Invalid ID or request body"
+// @Failure      403      {string}  string  "Forbidden"
+// @Failure      404      {string}  string  "Version not found"
+// @Router       /api/versions/{id}/sanitize [post]
+func SanitizeContent(w http.ResponseWriter, r *http.Request) {
+	req := getRequester(r)
+	if !req.Internal {
+		config.App.Logger.Warn().Str("userID", req.ID).Str("role", req.Role).Msg("Content sanitization without privileges")
+		http.Error(w, "Forbidden: insufficient privileges", http.StatusForbidden)
+		return
+	}
+
+	var body SanitizedContent
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode provided request body")
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, version, ok := loadVersion(w, r, ctx)
+	if !ok {
+		return
+	}
+	wikiID, err := versionWikiID(*version)
+	if err != nil {
+		config.App.Logger.Warn().Err(err).Str("versionID", version.ID).Msg("Failed to retrieve the wiki of the version, using the default content policy")
+	}
+
+	body.Content = utils.Sanitize(body.Content, cachedContentPolicy(wikiID))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// SanitizationReport is the result of sanitizing the stored versions
+type SanitizationReport struct {
+	DryRun    bool     `json:"dry_run"`
+	Entries   int      `json:"entries"`
+	Examined  int      `json:"examined"`
+	Sanitized int      `json:"sanitized"`
+	Versions  []string `json:"versions"`
+}
+
+// SanitizeVersions godoc
+// @Summary      Sanitize the stored versions
+// @Description  One-off migration that sanitizes the content of the versions stored before the sanitizer, and their translations, with the content policy of their wiki. With dryRun the versions that would change are reported but nothing is written. Only admins can run it.
+// @Tags         Storage
+// @Produce      application/json
+// @Param        entryID  query     string  false  "Entry ID to limit the migration to"
+// @Param        dryRun   query     bool    false  "Report the result without writing anything"
+// @Success      200      {object}  SanitizationReport
+// @Failure      403      {string}  string  "Forbidden"
+// @Failure      500      {string}  string  "Internal server error"
+// @Router       /api/versions/sanitize [post]
+func SanitizeVersions(w http.ResponseWriter, r *http.Request) {
+	entryID := r.URL.Query().Get("entryID")
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+
+	req := getRequester(r)
+	if !req.Internal && req.Role != "admin" {
+		config.App.Logger.Warn().Str("userID", req.ID).Str("role", req.Role).Msg("Sanitization without privileges")
+		http.Error(w, "Forbidden: insufficient privileges", http.StatusForbidden)
+		return
+	}
+
+	entryIDs := []interface{}{entryID}
+	if entryID == "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		ids, err := database.VersionCollection.Distinct(ctx, "entry_id", bson.M{})
+		cancel()
+		if err != nil {
+			config.App.Logger.Error().Err(err).Msg("Database error")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		entryIDs = ids
+	}
+
+	report := SanitizationReport{DryRun: dryRun, Versions: []string{}}
+	policies := map[string]*utils.ContentPolicy{}
+	for _, id := range entryIDs {
+		id, ok := id.(string)
+		if !ok {
+			continue
+		}
+		if err := sanitizeEntry(id, policies, dryRun, &report); err != nil {
+			config.App.Logger.Error().Err(err).Str("entryID", id).Msg("Failed to sanitize the versions of the entry")
+			http.Error(w, "Failed to sanitize the versions of entry "+id, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	config.App.Logger.Info().Bool("dryRun", dryRun).Int("examined", report.Examined).Int("sanitized", report.Sanitized).Msg("Versions sanitized")
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// sanitizeEntry sanitizes the versions of an entry. Sanitizing changes the contents the deltas are
+// built on, so the whole chain of the entry is encoded again, like the compaction does.
+func sanitizeEntry(entryID string, policies map[string]*utils.ContentPolicy, dryRun bool, report *SanitizationReport) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := database.VersionCollection.Find(ctx, bson.M{"entry_id": entryID}, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var versions []model.Version
+	if err := cursor.All(ctx, &versions); err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return nil
+	}
+	if err := expandVersions(ctx, versions); err != nil {
+		return err
+	}
+
+	wikiID, err := versionWikiID(versions[0])
+	if err != nil {
+		return err
+	}
+	policy, ok := policies[wikiID]
+	if !ok {
+		policy = contentPolicy(wikiID)
+		policies[wikiID] = policy
+	}
+
+	report.Entries++
+	changed := map[string]bool{}
+	for i := range versions {
+		report.Examined++
+		if sanitizeVersion(&versions[i], policy) {
+			changed[versions[i].ID] = true
+			report.Sanitized++
+			report.Versions = append(report.Versions, versions[i].ID)
+		}
+	}
+	if dryRun || len(changed) == 0 {
+		return nil
+	}
+
+	var base *model.Version
+	for i := range versions {
+		stored := encodeVersion(versions[i], base)
+		update := storageFields(stored)
+		if changed[stored.ID] && len(stored.TranslatedFields) > 0 {
+			update["$set"].(bson.M)["translatedFields"] = stored.TranslatedFields
+		}
+
+		objID, err := primitive.ObjectIDFromHex(stored.ID)
+		if err != nil {
+			return err
+		}
+		if _, err := database.VersionCollection.UpdateOne(ctx, bson.M{"_id": objID}, update); err != nil {
+			return err
+		}
+
+		versions[i].DeltaDepth = stored.DeltaDepth
+		base = &versions[i]
+	}
+	// the searchable copy of the latest version has to be sanitized too
+	return materializeLatest(ctx, entryID)
+}
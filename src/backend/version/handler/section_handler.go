@@ -0,0 +1,211 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/laWiki/version/config"
+	"github.com/laWiki/version/model"
+	"github.com/laWiki/version/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// SectionContent is a section of the content of a version, heading and subsections included
+type SectionContent struct {
+	VersionID string `json:"version_id"`
+	EntryID   string `json:"entry_id"`
+	Index     int    `json:"index"`
+	Level     int    `json:"level,omitempty"`
+	Title     string `json:"title,omitempty"`
+	Anchor    string `json:"anchor,omitempty"`
+	Format    string `json:"format,omitempty"`
+	Content   string `json:"content"`
+}
+
+// SectionEdit is the new text of a section. The other fields are those of the version it creates.
+type SectionEdit struct {
+	Content string `json:"content"`
+	Editor  string `json:"editor"`
+	Summary string `json:"summary,omitempty"`
+	Minor   bool   `json:"minor"`
+	State   string `json:"state,omitempty"`
+}
+
+// sectionIndex parses the section number of the route, writing the error response if it is invalid
+func sectionIndex(w http.ResponseWriter, r *http.Request) (int, bool) {
+	index, err := strconv.Atoi(chi.URLParam(r, "n"))
+	if err != nil || index < 0 {
+		config.App.Logger.Warn().Str("section", chi.URLParam(r, "n")).Msg("Invalid section number")
+		http.Error(w, "Invalid section number", http.StatusBadRequest)
+		return 0, false
+	}
+	return index, true
+}
+
+// loadSource retrieves the version of the route without rendering it, writing the error response on failure.
+// Versions the requester can't see are not found.
+func loadSource(w http.ResponseWriter, r *http.Request, ctx context.Context) (*model.Version, bool) {
+	objID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Invalid ID format")
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	version, err := findSource(ctx, bson.M{"_id": objID})
+	if err != nil || !isVisible(*version, r) {
+		config.App.Logger.Error().Err(err).Msg("Version not found")
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return nil, false
+	}
+	return version, true
+}
+
+// GetVersionSection godoc
+// @Summary      Get a section of a version
+// @Description  Returns a section of the content of a version: its heading and everything up to the next heading of the same or a higher level. Sections are numbered from 1 as in the toc of the version; section 0 is the lead, before the first heading.
+// @Tags         Versions
+// @Produce      application/json
+// @Param        id   path      string  true  "Version ID"
+// @Param        n    path      int     true  "Section number"
+// @Success      200  {object}  SectionContent
+// @Failure      400  {string}  string  "Invalid ID or section number"
+// @Failure      404  {string}  string  "Version or section not found"
+// @Failure      500  {string}  string  "Internal server error"
+// @Router       /api/versions/{id}/sections/{n} [get]
+func GetVersionSection(w http.ResponseWriter, r *http.Request) {
+	index, ok := sectionIndex(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	version, ok := loadSource(w, r, ctx)
+	if !ok {
+		return
+	}
+
+	section, err := utils.FindSection(version.Content, version.Format == model.FormatMarkdown, index)
+	if err != nil {
+		config.App.Logger.Info().Str("versionID", version.ID).Int("section", index).Msg("Section not found")
+		http.Error(w, "Section not found", http.StatusNotFound)
+		return
+	}
+
+	response := SectionContent{
+		VersionID: version.ID,
+		EntryID:   version.EntryID,
+		Index:     index,
+		Level:     section.Level,
+		Title:     section.Title,
+		Anchor:    section.Anchor,
+		Format:    version.Format,
+		Content:   version.Content[section.Start:section.End],
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// EditVersionSection godoc
+// @Summary      Edit a section of a version
+// @Description  Creates a new version of the entry with only a section replaced, subsections included. The section is replaced in the current version of the entry; if the version edited isn't the current one, the edit only goes through when the section is the same in both, otherwise the edit conflicts. The new version goes through review like any other.
+// @Tags         Versions
+// @Accept       application/json
+// @Produce      application/json
+// @Param        id       path      string       true  "Version ID the edit is based on"
+// @Param        n        path      int          true  "Section number"
+// @Param        section  body      SectionEdit  true  "New text of the section"
+// @Success      201      {object}  model.Version
+// @Failure      400      {string}  string  "Invalid ID, section number or request body"
+// @Failure      403      {string}  string  "Forbidden: the entry is protected"
+// @Failure      404      {string}  string  "Version or section not found"
+// @Failure      409      {string}  string  "The section changed in the current version"
+// @Failure      500      {string}  string  "Internal server error"
+// @Router       /api/versions/{id}/sections/{n} [post]
+func EditVersionSection(w http.ResponseWriter, r *http.Request) {
+	index, ok := sectionIndex(w, r)
+	if !ok {
+		return
+	}
+
+	var edit SectionEdit
+	if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode provided request body")
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	base, ok := loadSource(w, r, ctx)
+	if !ok {
+		return
+	}
+	markdown := base.Format == model.FormatMarkdown
+	section, err := utils.FindSection(base.Content, markdown, index)
+	if err != nil {
+		config.App.Logger.Info().Str("versionID", base.ID).Int("section", index).Msg("Section not found")
+		http.Error(w, "Section not found", http.StatusNotFound)
+		return
+	}
+
+	// the edit applies to the current version, as long as the section didn't change since the base
+	current, err := currentSource(ctx, base.EntryID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		current = base
+	} else if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to retrieve the current version of the entry")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if current.ID != base.ID {
+		currentSection, err := utils.FindSection(current.Content, current.Format == model.FormatMarkdown, index)
+		if err != nil || current.Format != base.Format ||
+			current.Content[currentSection.Start:currentSection.End] != base.Content[section.Start:section.End] {
+			config.App.Logger.Warn().Str("versionID", base.ID).Str("currentID", current.ID).Int("section", index).Msg("Section edit conflict")
+			http.Error(w, "The section changed in the current version", http.StatusConflict)
+			return
+		}
+	}
+
+	content, err := utils.ReplaceSection(current.Content, markdown, index, edit.Content)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to replace the section")
+		http.Error(w, "Section not found", http.StatusNotFound)
+		return
+	}
+
+	summary := edit.Summary
+	if summary == "" && section.Title != "" {
+		summary = "/* " + section.Title + " */"
+	}
+
+	createVersion(w, r, model.Version{
+		Content:    content,
+		Format:     current.Format,
+		SourceLang: current.SourceLang,
+		Editor:     edit.Editor,
+		EntryID:    current.EntryID,
+		Address:    current.Address,
+		MediaIDs:   current.MediaIDs,
+		References: current.References,
+		Summary:    summary,
+		Minor:      edit.Minor,
+		State:      edit.State,
+	})
+}
@@ -0,0 +1,426 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/laWiki/version/config"
+	"github.com/laWiki/version/database"
+	"github.com/laWiki/version/model"
+	"github.com/laWiki/version/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// deltaMaxRatio is the largest delta, relative to the content, that is worth storing instead of a snapshot
+const deltaMaxRatio = 0.8
+
+// encodeVersion returns the stored form of a version: a delta against base, or a snapshot when there is
+// no base, the chain is long enough or the delta isn't worth it. Both contents must be rebuilt already.
+func encodeVersion(version model.Version, base *model.Version) model.Version {
+	stored := version
+	stored.ContentSize = len(version.Content)
+	stored.ContentChecksum = utils.Checksum(version.Content)
+	stored.Delta = nil
+	stored.DeltaBase = ""
+	stored.DeltaDepth = 0
+	stored.SearchText = ""
+
+	if base == nil || version.Content == "" || base.DeltaDepth+1 >= config.App.SnapshotInterval {
+		return stored
+	}
+	delta := utils.EncodeDelta(base.Content, version.Content)
+	if float64(len(delta)) > deltaMaxRatio*float64(len(version.Content)) {
+		return stored
+	}
+
+	stored.Content = ""
+	stored.Delta = delta
+	stored.DeltaBase = base.ID
+	stored.DeltaDepth = base.DeltaDepth + 1
+	return stored
+}
+
+// storageFields are the fields that hold the content of a stored version
+func storageFields(stored model.Version) bson.M {
+	set := bson.M{
+		"content":          stored.Content,
+		"content_size":     stored.ContentSize,
+		"content_checksum": stored.ContentChecksum,
+	}
+	if stored.Delta == nil {
+		return bson.M{
+			"$set":   set,
+			"$unset": bson.M{"delta": "", "delta_base": "", "delta_depth": "", "search_text": ""},
+		}
+	}
+	set["delta"] = stored.Delta
+	set["delta_base"] = stored.DeltaBase
+	set["delta_depth"] = stored.DeltaDepth
+	return bson.M{"$set": set}
+}
+
+// latestVersion returns the newest version of an entry in any state, or nil if it has none
+func latestVersion(ctx context.Context, entryID string) (*model.Version, error) {
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	version, err := findSource(ctx, bson.M{"entry_id": entryID}, opts)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return version, err
+}
+
+// materializeLatest keeps the content of the latest version of an entry searchable in the database. Versions
+// stored as deltas have no content there, so the latest one keeps a copy of it in search_text; older versions
+// are only searched when they are snapshots.
+func materializeLatest(ctx context.Context, entryID string) error {
+	latest, err := latestVersion(ctx, entryID)
+	if err != nil {
+		return err
+	}
+
+	stale := bson.M{"entry_id": entryID, "search_text": bson.M{"$exists": true}}
+	if latest != nil {
+		objID, err := primitive.ObjectIDFromHex(latest.ID)
+		if err != nil {
+			return err
+		}
+		stale["_id"] = bson.M{"$ne": objID}
+		if latest.DeltaBase != "" && latest.SearchText != latest.Content {
+			update := bson.M{"$set": bson.M{"search_text": latest.Content}}
+			if _, err := database.VersionCollection.UpdateOne(ctx, bson.M{"_id": objID}, update); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = database.VersionCollection.UpdateMany(ctx, stale, bson.M{"$unset": bson.M{"search_text": ""}})
+	return err
+}
+
+// findVersion retrieves a version, rebuilds its content and renders it if it is markdown
+func findVersion(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) (*model.Version, error) {
+	version, err := findSource(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	renderVersion(version)
+	return version, nil
+}
+
+// findSource retrieves a version and rebuilds its content, without rendering it
+func findSource(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) (*model.Version, error) {
+	versions := make([]model.Version, 1)
+	if err := database.VersionCollection.FindOne(ctx, filter, opts...).Decode(&versions[0]); err != nil {
+		return nil, err
+	}
+	if err := expandVersions(ctx, versions); err != nil {
+		return nil, err
+	}
+	return &versions[0], nil
+}
+
+// findVersions retrieves versions and rebuilds their content. Lists aren't rendered, see renderVersion.
+func findVersions(ctx context.Context, filter interface{}, opts ...*options.FindOptions) ([]model.Version, error) {
+	cursor, err := database.VersionCollection.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var versions []model.Version
+	if err := cursor.All(ctx, &versions); err != nil {
+		return nil, err
+	}
+	if err := expandVersions(ctx, versions); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// expandVersions rebuilds, in place, the content of the versions stored as deltas.
+// Bases found in the list are reused, the others are read from the database.
+func expandVersions(ctx context.Context, versions []model.Version) error {
+	known := map[string]*model.Version{}
+	for i := range versions {
+		known[versions[i].ID] = &versions[i]
+	}
+	for i := range versions {
+		if err := expandContent(ctx, &versions[i], known); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func expandContent(ctx context.Context, version *model.Version, known map[string]*model.Version) error {
+	if version.Delta == nil {
+		return nil
+	}
+
+	base, ok := known[version.DeltaBase]
+	if !ok {
+		baseID, err := primitive.ObjectIDFromHex(version.DeltaBase)
+		if err != nil {
+			return fmt.Errorf("invalid base %q of version %s: %w", version.DeltaBase, version.ID, err)
+		}
+		base = &model.Version{}
+		if err := database.VersionCollection.FindOne(ctx, bson.M{"_id": baseID}).Decode(base); err != nil {
+			return fmt.Errorf("failed to retrieve base %s of version %s: %w", version.DeltaBase, version.ID, err)
+		}
+		known[base.ID] = base
+	}
+	if err := expandContent(ctx, base, known); err != nil {
+		return err
+	}
+
+	content, err := utils.ApplyDelta(base.Content, version.Delta)
+	if err != nil {
+		return fmt.Errorf("failed to apply the delta of version %s: %w", version.ID, err)
+	}
+	if version.ContentChecksum != 0 && utils.Checksum(content) != version.ContentChecksum {
+		return fmt.Errorf("checksum mismatch rebuilding version %s", version.ID)
+	}
+	version.Content = content
+	version.Delta = nil
+	return nil
+}
+
+// detachDependents stores the versions built on top of a version as snapshots,
+// so the version can be changed or deleted without breaking them
+func detachDependents(ctx context.Context, version model.Version) error {
+	dependents, err := findVersions(ctx, bson.M{"delta_base": version.ID})
+	if err != nil {
+		return err
+	}
+	for _, dependent := range dependents {
+		objID, err := primitive.ObjectIDFromHex(dependent.ID)
+		if err != nil {
+			return err
+		}
+		stored := encodeVersion(dependent, nil)
+		if _, err := database.VersionCollection.UpdateOne(ctx, bson.M{"_id": objID}, storageFields(stored)); err != nil {
+			return err
+		}
+		config.App.Logger.Debug().Str("versionID", dependent.ID).Str("baseID", version.ID).Msg("Version detached from its base")
+	}
+	return nil
+}
+
+// StorageStats reports how much space the delta storage saves
+type StorageStats struct {
+	Versions     int64   `json:"versions"`
+	Snapshots    int64   `json:"snapshots"`
+	Deltas       int64   `json:"deltas"`
+	ContentBytes int64   `json:"content_bytes"`
+	StoredBytes  int64   `json:"stored_bytes"`
+	SavedBytes   int64   `json:"saved_bytes"`
+	SavedRatio   float64 `json:"saved_ratio"`
+}
+
+// GetStorageStats godoc
+// @Summary      Storage metrics
+// @Description  Reports the size of the content of all versions, the size actually stored with the delta storage and the space saved.
+// @Tags         Storage
+// @Produce      application/json
+// @Param        entryID  query     string  false  "Entry ID to limit the metrics to"
+// @Success      200      {object}  StorageStats
+// @Failure      500      {string}  string  "Internal server error"
+// @Router       /api/versions/storage/stats [get]
+func GetStorageStats(w http.ResponseWriter, r *http.Request) {
+	entryID := r.URL.Query().Get("entryID")
+
+	match := bson.M{}
+	if entryID != "" {
+		match["entry_id"] = entryID
+	}
+
+	contentBytes := bson.M{"$strLenBytes": bson.M{"$ifNull": bson.A{"$content", ""}}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      nil,
+			"versions": bson.M{"$sum": 1},
+			"deltas":   bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$ifNull": bson.A{"$delta", false}}, 1, 0}}},
+			// versions stored before the delta storage have no size, their content is all there is
+			"content_bytes": bson.M{"$sum": bson.M{"$ifNull": bson.A{"$content_size", contentBytes}}},
+			"stored_bytes": bson.M{"$sum": bson.M{"$add": bson.A{
+				contentBytes,
+				bson.M{"$binarySize": bson.M{"$ifNull": bson.A{"$delta", ""}}},
+				bson.M{"$strLenBytes": bson.M{"$ifNull": bson.A{"$search_text", ""}}},
+			}}},
+		}}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cursor, err := database.VersionCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		Versions     int64 `bson:"versions"`
+		Deltas       int64 `bson:"deltas"`
+		ContentBytes int64 `bson:"content_bytes"`
+		StoredBytes  int64 `bson:"stored_bytes"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode storage metrics")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var stats StorageStats
+	if len(results) > 0 {
+		stats.Versions = results[0].Versions
+		stats.Deltas = results[0].Deltas
+		stats.Snapshots = stats.Versions - stats.Deltas
+		stats.ContentBytes = results[0].ContentBytes
+		stats.StoredBytes = results[0].StoredBytes
+		stats.SavedBytes = stats.ContentBytes - stats.StoredBytes
+		if stats.ContentBytes > 0 {
+			stats.SavedRatio = float64(stats.SavedBytes) / float64(stats.ContentBytes)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// CompactionReport is the result of compacting the stored versions
+type CompactionReport struct {
+	DryRun      bool  `json:"dry_run"`
+	Entries     int   `json:"entries"`
+	Versions    int   `json:"versions"`
+	Snapshots   int   `json:"snapshots"`
+	Deltas      int   `json:"deltas"`
+	BytesBefore int64 `json:"bytes_before"`
+	BytesAfter  int64 `json:"bytes_after"`
+}
+
+// CompactVersions godoc
+// @Summary      Compact the stored versions
+// @Description  Migrates the versions to the delta storage: the versions of every entry are rewritten as a chain of snapshots and deltas. With dryRun the chains are computed and reported but nothing is written. Only admins can run it.
+// @Tags         Storage
+// @Produce      application/json
+// @Param        entryID  query     string  false  "Entry ID to limit the compaction to"
+// @Param        dryRun   query     bool    false  "Report the result without writing anything"
+// @Success      200      {object}  CompactionReport
+// @Failure      403      {string}  string  "Forbidden"
+// @Failure      500      {string}  string  "Internal server error"
+// @Router       /api/versions/storage/compact [post]
+func CompactVersions(w http.ResponseWriter, r *http.Request) {
+	entryID := r.URL.Query().Get("entryID")
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+
+	req := getRequester(r)
+	if !req.Internal && req.Role != "admin" {
+		config.App.Logger.Warn().Str("userID", req.ID).Str("role", req.Role).Msg("Compaction without privileges")
+		http.Error(w, "Forbidden: insufficient privileges", http.StatusForbidden)
+		return
+	}
+
+	entryIDs := []interface{}{entryID}
+	if entryID == "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		ids, err := database.VersionCollection.Distinct(ctx, "entry_id", bson.M{})
+		cancel()
+		if err != nil {
+			config.App.Logger.Error().Err(err).Msg("Database error")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		entryIDs = ids
+	}
+
+	report := CompactionReport{DryRun: dryRun}
+	for _, id := range entryIDs {
+		id, ok := id.(string)
+		if !ok {
+			continue
+		}
+		if err := compactEntry(id, dryRun, &report); err != nil {
+			config.App.Logger.Error().Err(err).Str("entryID", id).Msg("Failed to compact the versions of the entry")
+			http.Error(w, "Failed to compact the versions of entry "+id, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	config.App.Logger.Info().Bool("dryRun", dryRun).Int("entries", report.Entries).Int64("bytesBefore", report.BytesBefore).Int64("bytesAfter", report.BytesAfter).Msg("Versions compacted")
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// compactEntry rewrites the versions of an entry, oldest first, as a chain of snapshots and deltas.
+// Every write keeps the content of the version unchanged, so readers never see a broken chain.
+func compactEntry(entryID string, dryRun bool, report *CompactionReport) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := database.VersionCollection.Find(ctx, bson.M{"entry_id": entryID}, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var versions []model.Version
+	if err := cursor.All(ctx, &versions); err != nil {
+		return err
+	}
+	for _, version := range versions {
+		report.BytesBefore += int64(len(version.Content) + len(version.Delta))
+	}
+	if err := expandVersions(ctx, versions); err != nil {
+		return err
+	}
+
+	report.Entries++
+	var base *model.Version
+	for i := range versions {
+		stored := encodeVersion(versions[i], base)
+		report.Versions++
+		report.BytesAfter += int64(len(stored.Content) + len(stored.Delta))
+		if stored.Delta != nil {
+			report.Deltas++
+		} else {
+			report.Snapshots++
+		}
+
+		if !dryRun {
+			objID, err := primitive.ObjectIDFromHex(stored.ID)
+			if err != nil {
+				return err
+			}
+			if _, err := database.VersionCollection.UpdateOne(ctx, bson.M{"_id": objID}, storageFields(stored)); err != nil {
+				return err
+			}
+		}
+
+		// the next version is encoded against this one as it is now stored
+		versions[i].DeltaDepth = stored.DeltaDepth
+		base = &versions[i]
+	}
+	if dryRun {
+		return nil
+	}
+	return materializeLatest(ctx, entryID)
+}
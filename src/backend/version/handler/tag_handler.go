@@ -0,0 +1,536 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/laWiki/version/config"
+	"github.com/laWiki/version/database"
+	"github.com/laWiki/version/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// tagName is the format of tag names: letters, digits, dots, dashes and underscores
+var tagName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// TagRequest is the body used to tag a version or snapshot a wiki
+type TagRequest struct {
+	Name   string `json:"name"`
+	WikiID string `json:"wiki_id,omitempty"`
+}
+
+// TagRef is a tag together with the version it labels
+type TagRef struct {
+	model.Tag
+	VersionID string    `json:"version_id"`
+	EntryID   string    `json:"entry_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SnapshotReport is the result of snapshotting a wiki
+type SnapshotReport struct {
+	WikiID    string   `json:"wiki_id"`
+	Name      string   `json:"name"`
+	Tagged    int      `json:"tagged"`
+	Unchanged int      `json:"unchanged"`
+	Skipped   []string `json:"skipped,omitempty"`
+	Conflicts []string `json:"conflicts,omitempty"`
+}
+
+// SnapshotSummary describes a snapshot of a wiki
+type SnapshotSummary struct {
+	Name     string    `json:"name"`
+	Entries  int       `json:"entries"`
+	TaggedAt time.Time `json:"tagged_at"`
+}
+
+// addTag labels a version with the tag, unless another version of the entry already has a tag with that name.
+// It reports whether the tag is on the version after the call.
+func addTag(ctx context.Context, version model.Version, tag model.Tag) (bool, error) {
+	objID, err := primitive.ObjectIDFromHex(version.ID)
+	if err != nil {
+		return false, err
+	}
+
+	taken, err := database.VersionCollection.CountDocuments(ctx, bson.M{
+		"entry_id":  version.EntryID,
+		"tags.name": tag.Name,
+		"_id":       bson.M{"$ne": objID},
+	})
+	if err != nil {
+		return false, err
+	}
+	if taken > 0 {
+		return false, nil
+	}
+
+	// already tagged versions are left as they are
+	update := bson.M{"$push": bson.M{"tags": tag}}
+	if version.WikiID != "" {
+		update["$set"] = bson.M{"wiki_id": version.WikiID}
+	}
+	// the unique index on the tags of an entry rejects a tag another version got in the meantime
+	_, err = database.VersionCollection.UpdateOne(ctx, bson.M{
+		"_id":       objID,
+		"tags.name": bson.M{"$ne": tag.Name},
+	}, update)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// hasTag reports whether a version has a tag with the given name
+func hasTag(version model.Version, name string) bool {
+	for _, tag := range version.Tags {
+		if tag.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// TagVersion godoc
+// @Summary      Tag a version
+// @Description  Labels a version with a name, e.g. "reviewed-2026-Q3". Tag names are unique among the versions of an entry. Tagged versions can't be deleted and their content can't be changed.
+// @Tags         Tags
+// @Accept       application/json
+// @Produce      application/json
+// @Param        id   path      string      true  "Version ID"
+// @Param        tag  body      TagRequest  true  "Tag name"
+// @Success      200  {object}  model.Version
+// @Failure      400  {string}  string  "Invalid ID or tag name"
+// @Failure      403  {string}  string  "Forbidden"
+// @Failure      404  {string}  string  "Version not found"
+// @Failure      409  {string}  string  "Tag already used by another version of the entry"
+// @Failure      500  {string}  string  "Internal server error"
+// @Router       /api/versions/{id}/tags [post]
+func TagVersion(w http.ResponseWriter, r *http.Request) {
+	var body TagRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || !tagName.MatchString(body.Name) {
+		config.App.Logger.Error().Err(err).Str("name", body.Name).Msg("Invalid tag name")
+		http.Error(w, "Invalid tag name", http.StatusBadRequest)
+		return
+	}
+
+	req := getRequester(r)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objID, version, ok := loadVersion(w, r, ctx)
+	if !ok {
+		return
+	}
+	moderates, err := moderatesVersion(req, *version)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to retrieve the moderators of the wiki")
+		http.Error(w, "Failed to retrieve the moderators of the wiki", http.StatusInternalServerError)
+		return
+	}
+	if !moderates {
+		config.App.Logger.Warn().Str("userID", req.ID).Str("role", req.Role).Msg("Tagging without privileges")
+		http.Error(w, "Forbidden: insufficient privileges", http.StatusForbidden)
+		return
+	}
+
+	tagged, err := addTag(ctx, *version, model.Tag{Name: body.Name, TaggedBy: req.ID, TaggedAt: time.Now().UTC()})
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to tag version")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !tagged {
+		config.App.Logger.Warn().Str("entryID", version.EntryID).Str("tag", body.Name).Msg("Tag already used in the entry")
+		http.Error(w, "Tag already used by another version of the entry", http.StatusConflict)
+		return
+	}
+
+	config.App.Logger.Info().Str("versionID", version.ID).Str("tag", body.Name).Msg("Version tagged")
+	writeVersion(w, ctx, objID)
+}
+
+// UntagVersion godoc
+// @Summary      Remove a tag from a version
+// @Description  Removes a tag from a version. A version without tags can be deleted again.
+// @Tags         Tags
+// @Produce      application/json
+// @Param        id   path      string  true  "Version ID"
+// @Param        tag  path      string  true  "Tag name"
+// @Success      200  {object}  model.Version
+// @Failure      400  {string}  string  "Invalid ID"
+// @Failure      403  {string}  string  "Forbidden"
+// @Failure      404  {string}  string  "Version or tag not found"
+// @Failure      500  {string}  string  "Internal server error"
+// @Router       /api/versions/{id}/tags/{tag} [delete]
+func UntagVersion(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "tag")
+
+	req := getRequester(r)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objID, version, ok := loadVersion(w, r, ctx)
+	if !ok {
+		return
+	}
+	moderates, err := moderatesVersion(req, *version)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to retrieve the moderators of the wiki")
+		http.Error(w, "Failed to retrieve the moderators of the wiki", http.StatusInternalServerError)
+		return
+	}
+	if !moderates {
+		config.App.Logger.Warn().Str("userID", req.ID).Str("role", req.Role).Msg("Untagging without privileges")
+		http.Error(w, "Forbidden: insufficient privileges", http.StatusForbidden)
+		return
+	}
+	if !hasTag(*version, name) {
+		config.App.Logger.Warn().Str("versionID", version.ID).Str("tag", name).Msg("Tag not found")
+		http.Error(w, "Tag not found", http.StatusNotFound)
+		return
+	}
+
+	_, err = database.VersionCollection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$pull": bson.M{"tags": bson.M{"name": name}}})
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to untag version")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	config.App.Logger.Info().Str("versionID", version.ID).Str("tag", name).Msg("Version untagged")
+	writeVersion(w, ctx, objID)
+}
+
+// writeVersion retrieves a version and writes it as the response
+func writeVersion(w http.ResponseWriter, ctx context.Context, objID primitive.ObjectID) {
+	version, err := findVersion(ctx, bson.M{"_id": objID})
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to retrieve updated version")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(version); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetTaggedVersion godoc
+// @Summary      Get an entry as of a tag
+// @Description  Retrieves the version of an entry labeled with the tag.
+// @Tags         Tags
+// @Produce      application/json
+// @Param        entryID  query     string  true  "Entry ID"
+// @Param        tag      query     string  true  "Tag name"
+// @Success      200      {object}  model.Version
+// @Failure      400      {string}  string  "EntryID and tag are required"
+// @Failure      404      {string}  string  "Tag not found"
+// @Failure      500      {string}  string  "Internal server error"
+// @Router       /api/versions/tagged [get]
+func GetTaggedVersion(w http.ResponseWriter, r *http.Request) {
+	entryID := r.URL.Query().Get("entryID")
+	name := r.URL.Query().Get("tag")
+	if entryID == "" || name == "" {
+		config.App.Logger.Warn().Msg("Missing entryID or tag parameter")
+		http.Error(w, "EntryID and tag are required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	version, err := findVersion(ctx, applyVisibility(bson.M{"entry_id": entryID, "tags.name": name}, r))
+	if err == mongo.ErrNoDocuments {
+		config.App.Logger.Info().Str("entryID", entryID).Str("tag", name).Msg("Tag not found")
+		http.Error(w, "Tag not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(version); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetEntryTags godoc
+// @Summary      List the tags of an entry
+// @Description  Lists the tags of the versions of an entry, newest version first.
+// @Tags         Tags
+// @Produce      application/json
+// @Param        entryID  query     string  true  "Entry ID"
+// @Success      200      {array}   TagRef
+// @Success      204      {string}  string  "No Content"
+// @Failure      400      {string}  string  "EntryID is required"
+// @Failure      500      {string}  string  "Internal server error"
+// @Router       /api/versions/tags [get]
+func GetEntryTags(w http.ResponseWriter, r *http.Request) {
+	entryID := r.URL.Query().Get("entryID")
+	if entryID == "" {
+		config.App.Logger.Warn().Msg("Missing entryID parameter")
+		http.Error(w, "EntryID is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := applyVisibility(bson.M{"entry_id": entryID, "tags.0": bson.M{"$exists": true}}, r)
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetProjection(bson.M{"content": 0, "delta": 0, "search_text": 0})
+	cursor, err := database.VersionCollection.Find(ctx, filter, opts)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var versions []model.Version
+	if err := cursor.All(ctx, &versions); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode versions")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var tags []TagRef
+	for _, version := range versions {
+		for _, tag := range version.Tags {
+			tags = append(tags, TagRef{Tag: tag, VersionID: version.ID, EntryID: version.EntryID, CreatedAt: version.CreatedAt})
+		}
+	}
+
+	if len(tags) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tags); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// CreateSnapshot godoc
+// @Summary      Snapshot a wiki
+// @Description  Tags the current version of every entry of a wiki with the same name, so the whole wiki can be read or exported as it is now. Entries without a published version are skipped, and entries where another version already has the tag are reported as conflicts.
+// @Tags         Tags
+// @Accept       application/json
+// @Produce      application/json
+// @Param        snapshot  body      TagRequest  true  "Wiki ID and snapshot name"
+// @Success      200       {object}  SnapshotReport
+// @Failure      400       {string}  string  "Invalid request body"
+// @Failure      403       {string}  string  "Forbidden"
+// @Failure      500       {string}  string  "Internal server error"
+// @Router       /api/versions/snapshots [post]
+func CreateSnapshot(w http.ResponseWriter, r *http.Request) {
+	var body TagRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.WikiID == "" || !tagName.MatchString(body.Name) {
+		config.App.Logger.Error().Err(err).Str("name", body.Name).Msg("Invalid snapshot request")
+		http.Error(w, "Invalid request body: wiki_id and a valid name are required", http.StatusBadRequest)
+		return
+	}
+
+	wiki, err := fetchWiki(body.WikiID)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to retrieve the wiki")
+		http.Error(w, "Failed to retrieve the wiki", http.StatusInternalServerError)
+		return
+	}
+
+	req := getRequester(r)
+	if !canModerate(req, wiki) {
+		config.App.Logger.Warn().Str("userID", req.ID).Str("role", req.Role).Msg("Snapshot without privileges")
+		http.Error(w, "Forbidden: insufficient privileges", http.StatusForbidden)
+		return
+	}
+
+	var entries []entryInfo
+	if err := fetchJSON(fmt.Sprintf("%s/api/entries/search?wikiID=%s", config.App.API_GATEWAY_URL, url.QueryEscape(wiki.ID)), &entries); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to retrieve the entries of the wiki")
+		http.Error(w, "Failed to retrieve the entries of the wiki", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	report := SnapshotReport{WikiID: wiki.ID, Name: body.Name}
+	tag := model.Tag{Name: body.Name, TaggedBy: req.ID, TaggedAt: time.Now().UTC(), Snapshot: true}
+	for _, entry := range entries {
+		current, err := currentVersion(ctx, entry.ID)
+		if err == mongo.ErrNoDocuments {
+			report.Skipped = append(report.Skipped, entry.ID)
+			continue
+		}
+		if err != nil {
+			config.App.Logger.Error().Err(err).Msg("Database error")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if hasTag(*current, body.Name) {
+			report.Unchanged++
+			continue
+		}
+
+		current.WikiID = wiki.ID
+		tagged, err := addTag(ctx, *current, tag)
+		if err != nil {
+			config.App.Logger.Error().Err(err).Msg("Failed to tag version")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !tagged {
+			report.Conflicts = append(report.Conflicts, entry.ID)
+			continue
+		}
+		report.Tagged++
+	}
+
+	config.App.Logger.Info().Str("wikiID", wiki.ID).Str("name", body.Name).Int("tagged", report.Tagged).Msg("Wiki snapshot created")
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetSnapshots godoc
+// @Summary      List the snapshots of a wiki
+// @Description  Lists the snapshots of a wiki, newest first.
+// @Tags         Tags
+// @Produce      application/json
+// @Param        wikiID  query     string  true  "Wiki ID"
+// @Success      200     {array}   SnapshotSummary
+// @Success      204     {string}  string  "No Content"
+// @Failure      400     {string}  string  "WikiID is required"
+// @Failure      500     {string}  string  "Internal server error"
+// @Router       /api/versions/snapshots [get]
+func GetSnapshots(w http.ResponseWriter, r *http.Request) {
+	wikiID := r.URL.Query().Get("wikiID")
+	if wikiID == "" {
+		config.App.Logger.Warn().Msg("Missing wikiID parameter")
+		http.Error(w, "WikiID is required", http.StatusBadRequest)
+		return
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"wiki_id": wikiID, "tags.snapshot": true}}},
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$match", Value: bson.M{"tags.snapshot": true}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":       "$tags.name",
+			"entries":   bson.M{"$sum": 1},
+			"tagged_at": bson.M{"$min": "$tags.tagged_at"},
+		}}},
+		{{Key: "$sort", Value: bson.M{"tagged_at": -1}}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := database.VersionCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		Name     string    `bson:"_id"`
+		Entries  int       `bson:"entries"`
+		TaggedAt time.Time `bson:"tagged_at"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode snapshots")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if len(results) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	snapshots := make([]SnapshotSummary, 0, len(results))
+	for _, result := range results {
+		snapshots = append(snapshots, SnapshotSummary{Name: result.Name, Entries: result.Entries, TaggedAt: result.TaggedAt})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(snapshots); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetSnapshot godoc
+// @Summary      Read a wiki as of a snapshot
+// @Description  Retrieves the versions of the entries of a wiki labeled with the snapshot, with their full content. This is the export of the wiki as it was when the snapshot was taken.
+// @Tags         Tags
+// @Produce      application/json
+// @Param        name    path      string  true  "Snapshot name"
+// @Param        wikiID  query     string  true  "Wiki ID"
+// @Success      200     {array}   model.Version
+// @Success      204     {string}  string  "No Content"
+// @Failure      400     {string}  string  "WikiID is required"
+// @Failure      500     {string}  string  "Internal server error"
+// @Router       /api/versions/snapshots/{name} [get]
+func GetSnapshot(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	wikiID := r.URL.Query().Get("wikiID")
+	if wikiID == "" {
+		config.App.Logger.Warn().Msg("Missing wikiID parameter")
+		http.Error(w, "WikiID is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "entry_id", Value: 1}})
+	versions, err := findVersions(ctx, bson.M{"wiki_id": wikiID, "tags": bson.M{"$elemMatch": bson.M{"name": name, "snapshot": true}}}, opts)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if len(versions) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(versions); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
 	"strings"
 	"time"
 
@@ -14,6 +16,7 @@ import (
 	"github.com/laWiki/version/config"
 	"github.com/laWiki/version/database"
 	"github.com/laWiki/version/model"
+	"github.com/laWiki/version/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -35,41 +38,22 @@ func HealthCheck(w http.ResponseWriter, r *http.Request) {
 
 // GetVersions godoc
 // @Summary      Get all versions
-// @Description  Retrieves the list of all version JSON objects from the database.
+// @Description  Retrieves the list of all version JSON objects from the database. Lists return the source of the versions, without rendered HTML.
 // @Tags         Versions
 // @Produce      application/json
 // @Success      200  {array}   model.Version
 // @Failure      500  {string}  string  "Internal server error"
 // @Router       /api/versions/ [get]
 func GetVersions(w http.ResponseWriter, r *http.Request) {
-	var versions []model.Version
-
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	cursor, err := database.VersionCollection.Find(ctx, bson.M{})
+	versions, err := findVersions(ctx, applyVisibility(bson.M{}, r))
 	if err != nil {
 		config.App.Logger.Error().Err(err).Msg("Database error")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
-	defer cursor.Close(ctx)
-
-	for cursor.Next(ctx) {
-		var version model.Version
-		if err := cursor.Decode(&version); err != nil {
-			config.App.Logger.Error().Err(err).Msg("Failed to decode version")
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-		versions = append(versions, version)
-	}
-
-	if err := cursor.Err(); err != nil {
-		config.App.Logger.Error().Err(err).Msg("Cursor error")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
 
 	if len(versions) == 0 {
 		config.App.Logger.Info().Msg("No versions found")
@@ -106,18 +90,23 @@ func GetVersionByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var version model.Version
-
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	err = database.VersionCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&version)
+	version, err := findVersion(ctx, bson.M{"_id": objID})
 	if err != nil {
 		config.App.Logger.Error().Err(err).Msg("Version not found")
 		http.Error(w, "Version not found", http.StatusNotFound)
 		return
 	}
 
+	// unpublished versions are only visible to their author and moderators
+	if !isVisible(*version, r) {
+		config.App.Logger.Info().Str("versionID", id).Str("state", version.State).Msg("Version not visible to the requester")
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(version); err != nil {
 		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
@@ -128,13 +117,15 @@ func GetVersionByID(w http.ResponseWriter, r *http.Request) {
 
 // SearchVersions godoc
 // @Summary      Search versions
-// @Description  Search for versions using various query parameters. You can search by content, editor, createdAt, or entryID. All parameters are optional and can be combined.
+// @Description  Search for versions using various query parameters. You can search by content, editor, createdAt, or entryID, and hide minor edits. All parameters are optional and can be combined. The versions found are returned as source, without rendered HTML.
 // @Tags         Versions
 // @Produce      application/json
-// @Param        content     query     string  false  "Partial content to search for (case-insensitive)"
+// @Param        content     query     string  false  "Partial content to search for (case-insensitive). Matches the latest version of each entry and the versions stored as snapshots"
 // @Param        editor      query     string  false  "Editor to search for"
 // @Param        createdAt   query     string  false  "Creation date (YYYY-MM-DD)"
 // @Param        entryID     query     string  false  "Entry ID to search for"
+// @Param        hideMinor   query     bool    false  "Exclude versions flagged as minor edits"
+// @Param        state       query     string  false  "State of the version (draft, pending_review, approved, rejected, published)"
 // @Success      200         {array}   model.Version
 // @Failure      400         {string}  string  "Bad Request"
 // @Failure      500         {string}  string  "Internal Server Error"
@@ -145,13 +136,22 @@ func SearchVersions(w http.ResponseWriter, r *http.Request) {
 	createdAtFromString := r.URL.Query().Get("createdAtFrom")
 	createdAtToString := r.URL.Query().Get("createdAtTo")
 	entryID := r.URL.Query().Get("entryID")
+	hideMinor := r.URL.Query().Get("hideMinor") == "true"
+	states := r.URL.Query()["state"]
 
 	filter := bson.M{}
 
+	// versions stored as deltas have no content in the database: the latest version of each entry is matched
+	// on its copy in search_text, older ones only when they are snapshots
 	if content != "" {
-		filter["content"] = bson.M{
-			"$regex":   content,
-			"$options": "i",
+		if _, err := regexp.Compile("(?i)" + content); err != nil {
+			config.App.Logger.Error().Err(err).Msg("Invalid content pattern")
+			http.Error(w, "Invalid content pattern", http.StatusBadRequest)
+			return
+		}
+		filter["$or"] = bson.A{
+			bson.M{"content": bson.M{"$regex": content, "$options": "i"}},
+			bson.M{"search_text": bson.M{"$regex": content, "$options": "i"}},
 		}
 	}
 	// Handle 'author' parameter (multiple IDs as strings)
@@ -189,36 +189,33 @@ func SearchVersions(w http.ResponseWriter, r *http.Request) {
 		filter["entry_id"] = entryID
 	}
 
-	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	if hideMinor {
+		filter["minor"] = bson.M{"$ne": true}
+	}
 
-	var versions []model.Version
+	if len(states) > 0 {
+		stateFilter := bson.A{}
+		for _, state := range states {
+			stateFilter = append(stateFilter, state)
+			// versions stored before the review workflow have no state
+			if state == model.StatePublished {
+				stateFilter = append(stateFilter, nil)
+			}
+		}
+		filter["state"] = bson.M{"$in": stateFilter}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	cursor, err := database.VersionCollection.Find(ctx, filter, opts)
+	versions, err := findVersions(ctx, applyVisibility(filter, r), opts)
 	if err != nil {
 		config.App.Logger.Error().Err(err).Msg("Database error")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
-	defer cursor.Close(ctx)
-
-	for cursor.Next(ctx) {
-		var version model.Version
-		if err := cursor.Decode(&version); err != nil {
-			config.App.Logger.Error().Err(err).Msg("Failed to decode version")
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-		versions = append(versions, version)
-	}
-
-	if err := cursor.Err(); err != nil {
-		config.App.Logger.Error().Err(err).Msg("Cursor error")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
 
 	if len(versions) == 0 {
 		config.App.Logger.Info().Msg("No versions found")
@@ -236,13 +233,14 @@ func SearchVersions(w http.ResponseWriter, r *http.Request) {
 
 // PostVersion godoc
 // @Summary      Create a new version
-// @Description  Creates a new version. Expects a JSON object in the request body.
+// @Description  Creates a new version. Expects a JSON object in the request body. Versions with state "draft" are saved as drafts, any other version is submitted: it is published, or sent to review if the wiki requires it for the role of the author or the author has no role. The editor is the user making the request. The content is HTML, or markdown with format "markdown": markdown is stored as is and returned rendered to sanitized HTML in the html field. The content cites the references of the version with <ref name="..."/>, rendered as numbered footnotes.
 // @Tags         Versions
 // @Accept       application/json
 // @Produce      application/json
 // @Param        version  body      model.Version  true  "Version information"
 // @Success      201      {object}  model.Version
-// @Failure      400      {string}  string  "Invalid request body"
+// @Failure      400      {string}  string  "Invalid request body or references"
+// @Failure      403      {string}  string  "Forbidden: the entry is protected"
 // @Failure      500      {string}  string  "Internal server error"
 // @Router       /api/versions/ [post]
 func PostVersion(w http.ResponseWriter, r *http.Request) {
@@ -254,12 +252,88 @@ func PostVersion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	createVersion(w, r, version)
+}
+
+// createVersion stores a new version of an entry sent by a client and writes it as the response. The state
+// asked for is honored for drafts; the rest of the review fields are set by the service. The editor is the
+// user making the request; only other services can create versions on behalf of someone else.
+func createVersion(w http.ResponseWriter, r *http.Request, version model.Version) {
 	version.CreatedAt = time.Now().UTC()
 
+	req := getRequester(r)
+	if !req.Internal {
+		version.Editor = req.ID
+	}
+
+	entry, err := fetchEntry(version.EntryID)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to retrieve entry information")
+		http.Error(w, "Failed to retrieve entry information", http.StatusInternalServerError)
+		return
+	}
+	version.WikiID = entry.WikiID
+
+	if !validFormat(version.Format) {
+		config.App.Logger.Error().Str("format", version.Format).Msg("Invalid content format")
+		http.Error(w, "Invalid content format", http.StatusBadRequest)
+		return
+	}
+	// markdown is the source of truth, it is sanitized once rendered
+	if version.Format != model.FormatMarkdown {
+		version.Content = utils.Sanitize(version.Content, contentPolicy(version.WikiID))
+	}
+
+	references, err := checkReferences(version.References)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Invalid references")
+		http.Error(w, "Invalid references: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	version.References = references
+
+	if !entry.Protection.allows(req) {
+		config.App.Logger.Warn().Str("entryID", entry.ID).Str("userID", req.ID).Str("level", entry.Protection.Level).Msg("Version on a protected entry")
+		http.Error(w, "Forbidden: the entry is protected", http.StatusForbidden)
+		return
+	}
+
+	// review state is set by the service, not by the client
+	version.ReviewedBy = ""
+	version.ReviewedAt = time.Time{}
+	version.RejectionReason = ""
+	version.ClaimedBy = ""
+	version.ClaimedAt = time.Time{}
+	version.FirstClaimedAt = time.Time{}
+	version.Tags = nil
+	if version.State == model.StateDraft {
+		version.SubmittedAt = time.Time{}
+	} else {
+		needsReview, err := requiresReview(version.WikiID, req)
+		if err != nil {
+			config.App.Logger.Error().Err(err).Msg("Failed to retrieve the review policy")
+			http.Error(w, "Failed to retrieve the review policy", http.StatusInternalServerError)
+			return
+		}
+		version.State = submittedState(needsReview)
+		version.SubmittedAt = version.CreatedAt
+	}
+
+	// the location is the geocoded address, not something clients set
+	version.Location = geocodeAddress(version.Address)
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	result, err := database.VersionCollection.InsertOne(ctx, version)
+	// the new version is stored as a delta against the latest version of the entry
+	base, err := latestVersion(ctx, version.EntryID)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to retrieve the latest version of the entry")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	result, err := database.VersionCollection.InsertOne(ctx, encodeVersion(version, base))
 	if err != nil {
 		config.App.Logger.Error().Err(err).Msg("Database error")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -274,6 +348,10 @@ func PostVersion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	version.ID = objID.Hex()
+	if err := materializeLatest(ctx, version.EntryID); err != nil {
+		config.App.Logger.Error().Err(err).Str("entryID", version.EntryID).Msg("Failed to materialize the latest version of the entry")
+	}
+	renderVersion(&version)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated) // Return 201 Created
@@ -285,12 +363,32 @@ func PostVersion(w http.ResponseWriter, r *http.Request) {
 
 	config.App.Logger.Info().Interface("version", version).Msg("Added new version")
 
+	if version.State == model.StatePublished {
+		currentVersionChanged(version.EntryID)
+	}
+
+	// Only published versions notify the author of the entry
+	if version.State != model.StatePublished {
+		config.App.Logger.Debug().Str("versionID", version.ID).Str("state", version.State).Msg("Version not published, skipping notification")
+		return
+	}
+
+	// Minor edits (typos, formatting) don't notify the author of the entry
+	if version.Minor {
+		config.App.Logger.Debug().Str("versionID", version.ID).Msg("Minor edit, skipping notification")
+		return
+	}
+
+	notifyEntryAuthor(version.EntryID)
+}
+
+// notifyEntryAuthor lets the author of an entry know that it has been modified
+func notifyEntryAuthor(entryID string) {
 	// Retrieve the entry from the entry service with the entry ID from the version
-	entryServiceURL := fmt.Sprintf("%s/api/entries/%s", config.App.API_GATEWAY_URL, version.EntryID)
+	entryServiceURL := fmt.Sprintf("%s/api/entries/%s", config.App.API_GATEWAY_URL, entryID)
 	req, err := http.NewRequest("GET", entryServiceURL, nil)
 	if err != nil {
 		config.App.Logger.Error().Err(err).Msg("Failed to create request to entry service")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
@@ -299,7 +397,6 @@ func PostVersion(w http.ResponseWriter, r *http.Request) {
 	resp, err := client.Do(req)
 	if err != nil {
 		config.App.Logger.Error().Err(err).Msg("Failed to send request to entry service")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 	defer resp.Body.Close()
@@ -308,7 +405,6 @@ func PostVersion(w http.ResponseWriter, r *http.Request) {
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		bodyString := string(bodyBytes)
 		config.App.Logger.Error().Int("status", resp.StatusCode).Str("body", bodyString).Msg("Entry service returned error")
-		http.Error(w, "Failed to retrieve entry information", http.StatusInternalServerError)
 		return
 	}
 
@@ -320,7 +416,6 @@ func PostVersion(w http.ResponseWriter, r *http.Request) {
 
 	if err := json.NewDecoder(resp.Body).Decode(&entry); err != nil {
 		config.App.Logger.Error().Err(err).Msg("Failed to decode entry response")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
@@ -329,14 +424,12 @@ func PostVersion(w http.ResponseWriter, r *http.Request) {
 	req, err = http.NewRequest("GET", userServiceURL, nil)
 	if err != nil {
 		config.App.Logger.Error().Err(err).Msg("Failed to create request to user service")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 	req.Header.Set("X-Internal-Auth", config.App.JWTSecret)
 	resp, err = client.Do(req)
 	if err != nil {
 		config.App.Logger.Error().Err(err).Msg("Failed to send request to user service")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 	defer resp.Body.Close()
@@ -345,7 +438,6 @@ func PostVersion(w http.ResponseWriter, r *http.Request) {
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		bodyString := string(bodyBytes)
 		config.App.Logger.Error().Int("status", resp.StatusCode).Str("body", bodyString).Msg("User service returned error")
-		http.Error(w, "Failed to retrieve user information", http.StatusInternalServerError)
 		return
 	}
 
@@ -358,7 +450,6 @@ func PostVersion(w http.ResponseWriter, r *http.Request) {
 
 	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
 		config.App.Logger.Error().Err(err).Msg("Failed to decode user response")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
@@ -378,15 +469,17 @@ func PostVersion(w http.ResponseWriter, r *http.Request) {
 
 // PutVersion godoc
 // @Summary      Update a version by ID
-// @Description  Updates a version by its ID. Expects a JSON object in the request body.
+// @Description  Updates a version by its ID. Expects a JSON object in the request body. The references are only replaced when given. The editor can't be changed, and neither can the content of published or approved versions: a new version has to be posted. Protected entries are enforced as on creation.
 // @Tags         Versions
 // @Accept       application/json
 // @Produce      application/json
 // @Param        id      query     string          true  "Version ID"
 // @Param        version body      model.Version   true  "Updated version information"
 // @Success      200     {object}  model.Version
-// @Failure      400     {string}  string  "Invalid ID or request body"
+// @Failure      400     {string}  string  "Invalid ID, request body or references"
+// @Failure      403     {string}  string  "Forbidden: the entry is protected"
 // @Failure      404     {string}  string  "Version not found"
+// @Failure      409     {string}  string  "The content of published, approved or tagged versions can't be changed"
 // @Failure      500     {string}  string  "Internal server error"
 // @Router       /api/versions/{id} [put]
 func PutVersion(w http.ResponseWriter, r *http.Request) {
@@ -410,14 +503,53 @@ func PutVersion(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	var existingVersion model.Version
-	err = database.VersionCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&existingVersion)
+	existingVersion, err := findVersion(ctx, bson.M{"_id": objID})
 	if err != nil {
 		config.App.Logger.Error().Err(err).Msg("Version not found")
 		http.Error(w, "Version not found", http.StatusNotFound)
 		return
 	}
 
+	if !validFormat(newVersion.Format) {
+		config.App.Logger.Error().Str("format", newVersion.Format).Msg("Invalid content format")
+		http.Error(w, "Invalid content format", http.StatusBadRequest)
+		return
+	}
+	if newVersion.Format == "" {
+		newVersion.Format = existingVersion.Format
+	}
+
+	entry, err := fetchEntry(existingVersion.EntryID)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to retrieve entry information")
+		http.Error(w, "Failed to retrieve entry information", http.StatusInternalServerError)
+		return
+	}
+	req := getRequester(r)
+	if !entry.Protection.allows(req) {
+		config.App.Logger.Warn().Str("entryID", entry.ID).Str("userID", req.ID).Str("level", entry.Protection.Level).Msg("Version update on a protected entry")
+		http.Error(w, "Forbidden: the entry is protected", http.StatusForbidden)
+		return
+	}
+
+	if newVersion.Content != existingVersion.Content && newVersion.Format != model.FormatMarkdown {
+		newVersion.Content = utils.Sanitize(newVersion.Content, contentPolicy(entry.WikiID))
+	}
+
+	// reviewed contents are changed by posting a new version, which goes through review again
+	if newVersion.Content != existingVersion.Content && (isPublished(*existingVersion) || existingVersion.State == model.StateApproved) {
+		config.App.Logger.Warn().Str("id", id).Str("state", existingVersion.State).Msg("Content change of a reviewed version")
+		http.Error(w, "The content of published or approved versions can't be changed, post a new version instead", http.StatusConflict)
+		return
+	}
+
+	// tags label a content, it can't change under them
+	if len(existingVersion.Tags) > 0 && newVersion.Content != existingVersion.Content {
+		config.App.Logger.Warn().Str("id", id).Msg("Content change of a tagged version")
+		http.Error(w, "The content of tagged versions can't be changed", http.StatusConflict)
+		return
+	}
+
 	// Identify media_ids to delete
 	mediaIDsToDelete := difference(existingVersion.MediaIDs, newVersion.MediaIDs)
 
@@ -453,14 +585,58 @@ func PutVersion(w http.ResponseWriter, r *http.Request) {
 
 	update := bson.M{
 		"$set": bson.M{
-			"content":    newVersion.Content,
-			"editor":     newVersion.Editor,
 			"updated_at": newVersion.UpdatedAt,
 			"address":    newVersion.Address,
 			"media_ids":  newVersion.MediaIDs,
+			"summary":    newVersion.Summary,
+			"minor":      newVersion.Minor,
+			"format":     newVersion.Format,
 		},
 	}
 
+	// references are only changed when given
+	referencesChanged := newVersion.References != nil
+	if referencesChanged {
+		references, err := checkReferences(newVersion.References)
+		if err != nil {
+			config.App.Logger.Error().Err(err).Msg("Invalid references")
+			http.Error(w, "Invalid references: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		update["$set"].(bson.M)["references"] = references
+	}
+
+	addressChanged := newVersion.Address != existingVersion.Address
+	if addressChanged {
+		if location := geocodeAddress(newVersion.Address); location != nil {
+			update["$set"].(bson.M)["location"] = location
+		} else {
+			update["$unset"] = bson.M{"location": ""}
+		}
+	}
+
+	// a new content is stored as a snapshot, once the versions built on top of the old one are detached from it
+	if newVersion.Content != existingVersion.Content {
+		if err := detachDependents(ctx, *existingVersion); err != nil {
+			config.App.Logger.Error().Err(err).Msg("Failed to detach the dependent versions")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		storage := storageFields(encodeVersion(newVersion, nil))
+		for field, value := range storage["$set"].(bson.M) {
+			update["$set"].(bson.M)[field] = value
+		}
+		unset, _ := update["$unset"].(bson.M)
+		if unset == nil {
+			unset = bson.M{}
+		}
+		fields, _ := storage["$unset"].(bson.M)
+		for field, value := range fields {
+			unset[field] = value
+		}
+		update["$unset"] = unset
+	}
+
 	result, err := database.VersionCollection.UpdateOne(ctx, bson.M{"_id": objID}, update)
 	if err != nil {
 		config.App.Logger.Error().Err(err).Msg("Database error")
@@ -474,7 +650,7 @@ func PutVersion(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Retrieve the updated document (optional)
-	err = database.VersionCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&newVersion)
+	updatedVersion, err := findVersion(ctx, bson.M{"_id": objID})
 	if err != nil {
 		config.App.Logger.Error().Err(err).Msg("Failed to retrieve updated version")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -482,11 +658,15 @@ func PutVersion(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(newVersion); err != nil {
+	if err := json.NewEncoder(w).Encode(updatedVersion); err != nil {
 		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+
+	if newVersion.Content != existingVersion.Content || addressChanged || referencesChanged {
+		currentVersionChanged(existingVersion.EntryID)
+	}
 }
 
 // Helper function to find the difference between two slices
@@ -515,6 +695,7 @@ func difference(slice1, slice2 []string) []string {
 // @Success      204 {string} string "No Content"
 // @Failure      400 {string} string "Invalid ID"
 // @Failure      404 {string} string "Version not found"
+// @Failure      409 {string} string "Tagged versions can't be deleted"
 // @Failure      500 {string} string "Internal server error"
 // @Router       /api/versions/{id} [delete]
 func DeleteVersion(w http.ResponseWriter, r *http.Request) {
@@ -544,76 +725,26 @@ func DeleteVersion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Delete associated media files first
-	for _, mediaID := range version.MediaIDs {
-		mediaServiceURL := fmt.Sprintf("%s/api/media/%s", config.App.API_GATEWAY_URL, mediaID)
-		req, err := http.NewRequest("DELETE", mediaServiceURL, nil)
-		if err != nil {
-			config.App.Logger.Error().Err(err).Msg("Failed to create request to media service")
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-
-		config.App.Logger.Info().Str("url", mediaServiceURL).Msg("Sending delete request to media service")
-		req.Header.Set("X-Internal-Auth", config.App.JWTSecret)
-		resp, err := client.Do(req)
-		if err != nil {
-			config.App.Logger.Error().Err(err).Msg("Failed to send delete request to media service")
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-			bodyBytes, _ := io.ReadAll(resp.Body)
-			bodyString := string(bodyBytes)
-			config.App.Logger.Error().Int("status", resp.StatusCode).Str("body", bodyString).Msg("Media service returned error")
-			http.Error(w, "Failed to delete associated media files", http.StatusInternalServerError)
-			return
-		}
-	}
-
-	// Delete associated comments first
-	commentServiceURL := fmt.Sprintf("%s/api/comments/version?versionID=%s", config.App.API_GATEWAY_URL, id)
-	config.App.Logger.Info().Str("url", commentServiceURL).Msg("Preparing to delete associated comments")
-
-	req, err := http.NewRequest("DELETE", commentServiceURL, nil)
-	if err != nil {
-		config.App.Logger.Error().Err(err).Msg("Failed to create request to comment service")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	config.App.Logger.Info().Str("url", commentServiceURL).Msg("Sending request to delete associated comments")
-	req.Header.Set("X-Internal-Auth", config.App.JWTSecret)
-	resp, err := client.Do(req)
-	if err != nil {
-		config.App.Logger.Error().Err(err).Msg("Failed to send request to comment service")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		config.App.Logger.Error().
-			Int("status", resp.StatusCode).
-			Str("body", bodyString).
-			Msg("Comment service returned error")
-		http.Error(w, "Failed to delete associated comments", http.StatusInternalServerError)
+	if len(version.Tags) > 0 {
+		config.App.Logger.Warn().Str("versionID", id).Msg("Deletion of a tagged version")
+		http.Error(w, "Tagged versions can't be deleted", http.StatusConflict)
 		return
 	}
 
-	// Now proceed to delete the version document
-
-	result, err := database.VersionCollection.DeleteOne(ctx, bson.M{"_id": objID})
+	deleted, err := deleteVersionCascade(ctx, client, version)
 	if err != nil {
-		config.App.Logger.Error().Err(err).Msg("Failed to delete version")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		config.App.Logger.Error().Err(err).Str("versionID", id).Msg("Failed to delete version")
+		switch {
+		case errors.Is(err, errDeleteMedia):
+			http.Error(w, "Failed to delete associated media files", http.StatusInternalServerError)
+		case errors.Is(err, errDeleteComments):
+			http.Error(w, "Failed to delete associated comments", http.StatusInternalServerError)
+		default:
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
 		return
 	}
-	if result.DeletedCount == 0 {
+	if !deleted {
 		config.App.Logger.Info().Msg("Version not found")
 		w.WriteHeader(http.StatusNoContent)
 		return
@@ -621,17 +752,18 @@ func DeleteVersion(w http.ResponseWriter, r *http.Request) {
 
 	config.App.Logger.Info().Str("versionID", id).Msg("Version and associated comments deleted successfully")
 	w.WriteHeader(http.StatusNoContent)
+	currentVersionChanged(version.EntryID)
 
 	// Retrieve the entry from the entry service with the entry ID from the version
 	entryServiceURL := fmt.Sprintf("%s/api/entries/%s", config.App.API_GATEWAY_URL, version.EntryID)
-	req, err = http.NewRequest("GET", entryServiceURL, nil)
+	req, err := http.NewRequest("GET", entryServiceURL, nil)
 	if err != nil {
 		config.App.Logger.Error().Err(err).Msg("Failed to create request to entry service")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 	req.Header.Set("X-Internal-Auth", config.App.JWTSecret)
-	resp, err = client.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		config.App.Logger.Error().Err(err).Msg("Failed to send request to entry service")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -714,12 +846,14 @@ func DeleteVersion(w http.ResponseWriter, r *http.Request) {
 
 // DeleteVersionsByEntryID godoc
 // @Summary      Deletes all versions by the Entry ID
-// @Description  Deletes all versions associated with a specific Entry ID.
+// @Description  Deletes all versions associated with a specific Entry ID. Tagged versions belong to the snapshots of the wiki, so the versions are kept while any is tagged, unless tagged=delete is given (when the whole wiki goes).
 // @Tags         Versions
-// @Param        id    query     string  true  "Entry ID"
+// @Param        id      query     string  true   "Entry ID"
+// @Param        tagged  query     string  false  "Set to delete to also delete tagged versions"
 // @Success      204   {string}  string  "No Content"
 // @Failure      400   {string}  string  "EntryID is required"
 // @Failure      404   {string}  string  "No versions found for the given entry ID"
+// @Failure      409   {string}  string  "The entry has tagged versions"
 // @Failure      500   {string}  string  "Internal server error"
 // @Failure      500   {string}  string  "Failed to delete associated comments"
 // @Router       /api/versions/entry/ [delete]
@@ -756,6 +890,17 @@ func DeleteVersionsByEntryID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// tagged versions are only deleted when asked for, as DeleteVersion refuses them
+	if r.URL.Query().Get("tagged") != "delete" {
+		for _, version := range versions {
+			if len(version.Tags) > 0 {
+				config.App.Logger.Warn().Str("entryID", entryID).Str("versionID", version.ID).Msg("Deletion of the versions of an entry with tagged versions")
+				http.Error(w, "The entry has tagged versions, untag them first", http.StatusConflict)
+				return
+			}
+		}
+	}
+
 	// Collect all versionIDs
 	var versionIDs []string
 	for _, version := range versions {
@@ -836,6 +981,21 @@ func DeleteVersionsByEntryID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if _, err := database.LinkCollection.DeleteMany(ctx, bson.M{"source_entry_id": entryID}); err != nil {
+		config.App.Logger.Error().Err(err).Str("entryID", entryID).Msg("Failed to delete the links of the entry")
+	}
+	if _, err := database.PlaceCollection.DeleteMany(ctx, bson.M{"entry_id": entryID}); err != nil {
+		config.App.Logger.Error().Err(err).Str("entryID", entryID).Msg("Failed to delete the place of the entry")
+	}
+	if _, err := database.CitationCollection.DeleteMany(ctx, bson.M{"source_entry_id": entryID}); err != nil {
+		config.App.Logger.Error().Err(err).Str("entryID", entryID).Msg("Failed to delete the citations of the entry")
+	}
+	if _, err := database.ExternalLinkCollection.UpdateMany(ctx, bson.M{"entry_ids": entryID}, bson.M{"$pull": bson.M{"entry_ids": entryID}}); err != nil {
+		config.App.Logger.Error().Err(err).Str("entryID", entryID).Msg("Failed to delete the external links of the entry")
+	} else if _, err := database.ExternalLinkCollection.DeleteMany(ctx, bson.M{"entry_ids": bson.M{"$size": 0}}); err != nil {
+		config.App.Logger.Error().Err(err).Str("entryID", entryID).Msg("Failed to delete the external links of the entry")
+	}
+
 	if deleteResult.DeletedCount == 0 {
 		config.App.Logger.Info().Str("entryID", entryID).Msg("No versions found to delete for the given entryID")
 		w.WriteHeader(http.StatusNoContent)
@@ -981,11 +1141,10 @@ func TranslateVersion(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Retrieve the Version from the database
-	var version model.Version
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	err = database.VersionCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&version)
+	version, err := findVersion(ctx, bson.M{"_id": objID})
 	if err != nil {
 		config.App.Logger.Error().Err(err).Str("versionID", versionID).Msg("Version not found")
 		http.Error(w, "Version not found", http.StatusNotFound)
@@ -1085,6 +1244,17 @@ func TranslateVersion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the translation service keeps the HTML tags, the result is sanitized like the original content
+	if version.Format != model.FormatMarkdown {
+		wikiID, err := versionWikiID(*version)
+		if err != nil {
+			config.App.Logger.Error().Err(err).Msg("Failed to retrieve entry information")
+			http.Error(w, "Failed to retrieve entry information", http.StatusInternalServerError)
+			return
+		}
+		translationResp.TranslatedFields["content"] = utils.Sanitize(translationResp.TranslatedFields["content"], contentPolicy(wikiID))
+	}
+
 	// Assign translated fields for the target language
 	for field, translatedText := range translationResp.TranslatedFields {
 		if version.TranslatedFields[field] == nil {
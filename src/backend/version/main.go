@@ -11,6 +11,7 @@ import (
 
 	"github.com/laWiki/version/config"
 	"github.com/laWiki/version/database"
+	"github.com/laWiki/version/handler"
 	"github.com/laWiki/version/router"
 	"github.com/rs/zerolog/log"
 )
@@ -75,6 +76,11 @@ func main() {
 		}
 	}()
 	xlog.Info().Str("Port", config.App.Port).Msg("HTTP server started")
+
+	// background job applying the retention policies of the wikis
+	go handler.RunPruner(ctx)
+	// background job checking the external links of the current versions
+	go handler.RunLinkChecker(ctx)
 	// Block until context is canceled (waiting for the shutdown signal).
 	<-ctx.Done()
 	// Shutdown logic
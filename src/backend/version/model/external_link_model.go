@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// ExternalLink is a URL outside the wiki the current versions of some of its entries link to, with the
+// results of the last checks, newest last. BrokenSince is when the link was first found broken in the
+// current run of failures.
+type ExternalLink struct {
+	ID          string      `json:"-" bson:"_id,omitempty"`
+	WikiID      string      `json:"wiki_id" bson:"wiki_id"`
+	URL         string      `json:"url" bson:"url"`
+	EntryIDs    []string    `json:"entry_ids" bson:"entry_ids"`
+	Status      *LinkCheck  `json:"status,omitempty" bson:"status,omitempty"`
+	History     []LinkCheck `json:"history,omitempty" bson:"history,omitempty"`
+	BrokenSince time.Time   `json:"broken_since,omitempty" bson:"broken_since,omitempty"`
+	FoundAt     time.Time   `json:"found_at" bson:"found_at"`
+}
+
+// LinkCheck is the result of checking an external link
+type LinkCheck struct {
+	StatusCode int       `json:"status_code,omitempty" bson:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty" bson:"error,omitempty"`
+	Broken     bool      `json:"broken" bson:"broken"`
+	CheckedAt  time.Time `json:"checked_at" bson:"checked_at"`
+}
@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+// Kinds of links. Links without kind are wiki links.
+const (
+	LinkTemplate = "template" // inclusion of a template
+)
+
+// Link is a wiki link, or the inclusion of a template, from the current version of an entry to another entry
+// of the same wiki. Targets are kept by title, together with the entry the title led to, following aliases
+// and redirects, when the link was last resolved. A link without target entry is a red link.
+type Link struct {
+	ID              string    `json:"-" bson:"_id,omitempty"`
+	WikiID          string    `json:"wiki_id" bson:"wiki_id"`
+	SourceEntryID   string    `json:"source_entry_id" bson:"source_entry_id"`
+	SourceVersionID string    `json:"source_version_id" bson:"source_version_id"`
+	TargetTitle     string    `json:"target_title" bson:"target_title"`
+	TargetEntryID   string    `json:"target_entry_id" bson:"target_entry_id"`
+	Kind            string    `json:"kind,omitempty" bson:"kind,omitempty"`
+	Count           int       `json:"count" bson:"count"`
+	UpdatedAt       time.Time `json:"updated_at" bson:"updated_at"`
+}
@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// GeoPoint is a GeoJSON point. Coordinates are longitude first, then latitude.
+type GeoPoint struct {
+	Type        string    `json:"type" bson:"type"`
+	Coordinates []float64 `json:"coordinates" bson:"coordinates"`
+}
+
+// NewGeoPoint returns the GeoJSON point at the given latitude and longitude
+func NewGeoPoint(lat float64, lon float64) *GeoPoint {
+	return &GeoPoint{Type: "Point", Coordinates: []float64{lon, lat}}
+}
+
+// Place is where an entry is, taken from the geocoded address of its current version. Entries whose current
+// version has no address, or an address the geocoder didn't find, have no place.
+type Place struct {
+	ID        string    `json:"-" bson:"_id,omitempty"`
+	EntryID   string    `json:"entry_id" bson:"entry_id"`
+	WikiID    string    `json:"wiki_id" bson:"wiki_id"`
+	VersionID string    `json:"version_id" bson:"version_id"`
+	Address   string    `json:"address" bson:"address"`
+	Location  GeoPoint  `json:"location" bson:"location"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
@@ -0,0 +1,30 @@
+package model
+
+import "time"
+
+// Reference is a source listed in a version, cited from the content with <ref name="..."/>. Names are
+// unique among the references of a version.
+type Reference struct {
+	Name       string    `json:"name" bson:"name"`
+	URL        string    `json:"url,omitempty" bson:"url,omitempty"`
+	Title      string    `json:"title,omitempty" bson:"title,omitempty"`
+	Author     string    `json:"author,omitempty" bson:"author,omitempty"`
+	AccessDate time.Time `json:"access_date,omitempty" bson:"access_date,omitempty"`
+	DOI        string    `json:"doi,omitempty" bson:"doi,omitempty"`
+}
+
+// Citation is a reference of the current version of an entry, with the number of times the content cites
+// it. Key identifies the source across entries: its DOI, or else its URL.
+type Citation struct {
+	ID              string    `json:"-" bson:"_id,omitempty"`
+	WikiID          string    `json:"wiki_id" bson:"wiki_id"`
+	SourceEntryID   string    `json:"source_entry_id" bson:"source_entry_id"`
+	SourceVersionID string    `json:"source_version_id" bson:"source_version_id"`
+	Name            string    `json:"name" bson:"name"`
+	Key             string    `json:"key,omitempty" bson:"key,omitempty"`
+	URL             string    `json:"url,omitempty" bson:"url,omitempty"`
+	Title           string    `json:"title,omitempty" bson:"title,omitempty"`
+	DOI             string    `json:"doi,omitempty" bson:"doi,omitempty"`
+	Count           int       `json:"count" bson:"count"`
+	UpdatedAt       time.Time `json:"updated_at" bson:"updated_at"`
+}
@@ -9,9 +9,20 @@ type Translation struct {
 	Content  string `json:"content" bson:"content"`
 }
 
+// Version is a revision of the content of an entry. To save space, versions are stored in chains: every
+// few versions a full snapshot, and in between deltas against the previous version of the entry. The delta
+// fields are never exposed, handlers always see the rebuilt content.
+// HTML is the content rendered when the version is read by itself: markdown versions keep their source in
+// Content, and [[wiki links]] are resolved to the entries of the wiki. It is empty when there is nothing to
+// render, and in lists of versions. Location is the Address geocoded when the version is stored. TOC is the
+// table of contents taken from the headings of the content when the version is read by itself. The
+// references cited in the content are rendered as numbered footnotes.
 type Version struct {
 	ID               string                       `json:"id" bson:"_id,omitempty"`
 	Content          string                       `json:"content" bson:"content"`
+	Format           string                       `json:"format,omitempty" bson:"format,omitempty"`
+	HTML             string                       `json:"html,omitempty" bson:"-"`
+	TOC              []TOCEntry                   `json:"toc,omitempty" bson:"-"`
 	TranslatedFields map[string]map[string]string `json:"translatedFields,omitempty" bson:"translatedFields,omitempty"`
 	SourceLang       string                       `json:"sourceLang" bson:"sourceLang"`
 	Editor           string                       `json:"editor" bson:"editor"`
@@ -19,5 +30,58 @@ type Version struct {
 	UpdatedAt        time.Time                    `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
 	EntryID          string                       `json:"entry_id" bson:"entry_id"`
 	Address          string                       `json:"address" bson:"address"`
+	Location         *GeoPoint                    `json:"location,omitempty" bson:"location,omitempty"`
 	MediaIDs         []string                     `json:"media_ids,omitempty" bson:"media_ids,omitempty"`
+	References       []Reference                  `json:"references,omitempty" bson:"references,omitempty"`
+	Summary          string                       `json:"summary,omitempty" bson:"summary,omitempty"`
+	Minor            bool                         `json:"minor" bson:"minor"`
+	State            string                       `json:"state,omitempty" bson:"state,omitempty"`
+	SubmittedAt      time.Time                    `json:"submitted_at,omitempty" bson:"submitted_at,omitempty"`
+	ReviewedBy       string                       `json:"reviewed_by,omitempty" bson:"reviewed_by,omitempty"`
+	ReviewedAt       time.Time                    `json:"reviewed_at,omitempty" bson:"reviewed_at,omitempty"`
+	RejectionReason  string                       `json:"rejection_reason,omitempty" bson:"rejection_reason,omitempty"`
+	WikiID           string                       `json:"wiki_id,omitempty" bson:"wiki_id,omitempty"`
+	ClaimedBy        string                       `json:"claimed_by,omitempty" bson:"claimed_by,omitempty"`
+	ClaimedAt        time.Time                    `json:"claimed_at,omitempty" bson:"claimed_at,omitempty"`
+	FirstClaimedAt   time.Time                    `json:"first_claimed_at,omitempty" bson:"first_claimed_at,omitempty"`
+	Tags             []Tag                        `json:"tags,omitempty" bson:"tags,omitempty"`
+	DeltaBase        string                       `json:"-" bson:"delta_base,omitempty"`
+	Delta            []byte                       `json:"-" bson:"delta,omitempty"`
+	DeltaDepth       int                          `json:"-" bson:"delta_depth,omitempty"`
+	ContentSize      int                          `json:"-" bson:"content_size,omitempty"`
+	ContentChecksum  uint32                       `json:"-" bson:"content_checksum,omitempty"`
+	SearchText       string                       `json:"-" bson:"search_text,omitempty"`
 }
+
+// TOCEntry is a heading of the content of a version. Index is the number of its section, Anchor the ID of
+// the heading in the HTML.
+type TOCEntry struct {
+	Index  int    `json:"index"`
+	Level  int    `json:"level"`
+	Title  string `json:"title"`
+	Anchor string `json:"anchor"`
+}
+
+// Tag labels a version. Tag names are unique among the versions of an entry, and tagged versions can't be
+// deleted. Snapshot tags are set on the current version of every entry of a wiki at once.
+type Tag struct {
+	Name     string    `json:"name" bson:"name"`
+	TaggedBy string    `json:"tagged_by,omitempty" bson:"tagged_by,omitempty"`
+	TaggedAt time.Time `json:"tagged_at" bson:"tagged_at"`
+	Snapshot bool      `json:"snapshot,omitempty" bson:"snapshot,omitempty"`
+}
+
+// Content formats. Versions without a format are HTML.
+const (
+	FormatHTML     = "html"
+	FormatMarkdown = "markdown"
+)
+
+// Version states. Versions stored before the review workflow have no state and count as published.
+const (
+	StateDraft         = "draft"
+	StatePendingReview = "pending_review"
+	StateApproved      = "approved"
+	StateRejected      = "rejected"
+	StatePublished     = "published"
+)
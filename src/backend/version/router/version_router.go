@@ -15,6 +15,35 @@ func NewRouter() http.Handler {
 		r.Get("/", handler.GetVersions)
 		r.Post("/", handler.PostVersion)
 		r.Get("/search", handler.SearchVersions)
+		r.Get("/feed", handler.GetVersionsFeed)
+		r.Get("/blame", handler.GetEntryBlame)
+		r.Get("/current", handler.GetCurrentVersion)
+		r.Get("/review-queue", handler.GetReviewQueue)
+		r.Get("/review-queue/stats", handler.GetReviewQueueStats)
+		r.Get("/storage/stats", handler.GetStorageStats)
+		r.Post("/storage/compact", handler.CompactVersions)
+		r.Post("/prune", handler.PruneVersions)
+		r.Get("/prune/last", handler.GetLastPrune)
+		r.Post("/sanitize", handler.SanitizeVersions)
+		r.Get("/tagged", handler.GetTaggedVersion)
+		r.Get("/tags", handler.GetEntryTags)
+		r.Post("/snapshots", handler.CreateSnapshot)
+		r.Get("/snapshots", handler.GetSnapshots)
+		r.Get("/snapshots/{name}", handler.GetSnapshot)
+		r.Get("/links", handler.GetLinks)
+		r.Get("/links/orphans", handler.GetOrphanEntries)
+		r.Get("/links/wanted", handler.GetWantedEntries)
+		r.Post("/links/rebuild", handler.RebuildLinks)
+		r.Post("/links/retarget", handler.RetargetLinks)
+		r.Get("/places/near", handler.GetPlacesNear)
+		r.Get("/places/within", handler.GetPlacesWithin)
+		r.Get("/places/geojson", handler.GetPlacesGeoJSON)
+		r.Post("/places/rebuild", handler.RebuildPlaces)
+		r.Get("/references/uncited", handler.GetUncitedEntries)
+		r.Get("/references/shared", handler.GetSharedReferences)
+		r.Get("/external-links", handler.GetExternalLinks)
+		r.Post("/external-links/check", handler.CheckExternalLinks)
+		r.Get("/external-links/check/last", handler.GetLastLinkCheck)
 		r.Delete("/entry", handler.DeleteVersionsByEntryID)
 
 		r.Route("/{id}", func(r chi.Router) {
@@ -22,6 +51,17 @@ func NewRouter() http.Handler {
 			r.Put("/", handler.PutVersion)
 			r.Delete("/", handler.DeleteVersion)
 			r.Post("/translate", handler.TranslateVersion)
+			r.Get("/diff", handler.GetVersionDiff)
+			r.Post("/submit", handler.SubmitVersion)
+			r.Post("/approve", handler.ApproveVersion)
+			r.Post("/reject", handler.RejectVersion)
+			r.Post("/claim", handler.ClaimVersion)
+			r.Post("/unclaim", handler.UnclaimVersion)
+			r.Post("/tags", handler.TagVersion)
+			r.Delete("/tags/{tag}", handler.UntagVersion)
+			r.Get("/sections/{n}", handler.GetVersionSection)
+			r.Post("/sections/{n}", handler.EditVersionSection)
+			r.Post("/sanitize", handler.SanitizeContent)
 		})
 	})
 
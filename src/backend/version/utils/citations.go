@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// citationName is the form of the names of the references, so they can be used in anchors
+var citationName = regexp.MustCompile(`^[\p{L}\p{N}_.:-]+$`)
+
+// citationMarker matches <ref name="x"/> in a source, and <ref name="x"></ref>
+var citationMarker = regexp.MustCompile(`(?i)<ref\s+name\s*=\s*(?:"([^"<>]*)"|'([^'<>]*)')\s*/?>(?:\s*</ref\s*>)?`)
+
+// Markdown drops raw HTML, so its citations are replaced before rendering by the name of the reference
+// between these private use characters
+const (
+	citationOpen  = "\uE000"
+	citationClose = "\uE001"
+)
+
+var citationPlaceholder = regexp.MustCompile(citationOpen + `([^` + citationOpen + citationClose + `]+)` + citationClose)
+
+// ValidCitationName reports whether a reference name can be cited
+func ValidCitationName(name string) bool {
+	return citationName.MatchString(name)
+}
+
+// CitedName is a reference cited in a content, with the number of times it is cited
+type CitedName struct {
+	Name  string
+	Count int
+}
+
+// Citations returns the references a content cites, in order of first citation
+func Citations(content string) []CitedName {
+	var cited []CitedName
+	index := map[string]int{}
+	for _, match := range citationMarker.FindAllStringSubmatch(content, -1) {
+		name := html.UnescapeString(match[1] + match[2])
+		if !ValidCitationName(name) {
+			continue
+		}
+		if i, ok := index[name]; ok {
+			cited[i].Count++
+			continue
+		}
+		index[name] = len(cited)
+		cited = append(cited, CitedName{Name: name, Count: 1})
+	}
+	return cited
+}
+
+// MarkCitations replaces the citations of a markdown source with placeholders that go through the renderer,
+// for RenderCitations to turn into footnotes
+func MarkCitations(source string) string {
+	return citationMarker.ReplaceAllStringFunc(source, func(marker string) string {
+		match := citationMarker.FindStringSubmatch(marker)
+		name := html.UnescapeString(match[1] + match[2])
+		if !ValidCitationName(name) {
+			return marker
+		}
+		return citationOpen + name + citationClose
+	})
+}
+
+// CitationAnchors are the IDs of the footnote of a reference, and of its nth citation in the text
+func CitationAnchors(name string, n int) (note string, ref string) {
+	return "cite-note-" + name, fmt.Sprintf("cite-ref-%s-%d", name, n)
+}
+
+// RenderCitations replaces the citations of rendered HTML, <ref> elements or the placeholders of
+// MarkCitations, with links to their footnotes. cite returns the number of the footnote of a reference,
+// false for references the version doesn't have. Citations in code are left as they are, and placeholders
+// in code go back to the marker they were.
+func RenderCitations(content string, cite func(name string) (int, bool)) string {
+	uses := map[string]int{}
+	footnote := func(name string) string {
+		number, ok := cite(name)
+		if !ok {
+			return fmt.Sprintf(`<sup class="reference reference-missing" title="%s">[?]</sup>`, html.EscapeString(name))
+		}
+		uses[name]++
+		note, ref := CitationAnchors(name, uses[name])
+		return fmt.Sprintf(`<sup class="reference" id="%s"><a href="#%s">[%d]</a></sup>`, html.EscapeString(ref), html.EscapeString(note), number)
+	}
+
+	var out strings.Builder
+	code := 0
+	z := xhtml.NewTokenizer(strings.NewReader(content))
+	for {
+		tokenType := z.Next()
+		if tokenType == xhtml.ErrorToken {
+			return out.String()
+		}
+		raw := string(z.Raw())
+
+		switch tokenType {
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken, xhtml.EndTagToken:
+			token := z.Token()
+			switch token.Data {
+			case "code", "pre":
+				if tokenType == xhtml.StartTagToken {
+					code++
+				} else if tokenType == xhtml.EndTagToken && code > 0 {
+					code--
+				}
+			case "ref":
+				// code shows the markup as it is
+				if code > 0 {
+					break
+				}
+				// the end tags of the citations are dropped with them
+				if tokenType != xhtml.EndTagToken {
+					for _, attr := range token.Attr {
+						if attr.Key == "name" && ValidCitationName(attr.Val) {
+							out.WriteString(footnote(attr.Val))
+						}
+					}
+				}
+				continue
+			}
+			out.WriteString(raw)
+		case xhtml.TextToken:
+			out.WriteString(citationPlaceholder.ReplaceAllStringFunc(raw, func(placeholder string) string {
+				name := html.UnescapeString(citationPlaceholder.FindStringSubmatch(placeholder)[1])
+				if code > 0 {
+					return html.EscapeString(fmt.Sprintf(`<ref name="%s"/>`, name))
+				}
+				return footnote(name)
+			}))
+		default:
+			out.WriteString(raw)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCitations(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []CitedName
+	}{
+		{"sin citas", nil},
+		{`Uno<ref name="a"/> dos<ref name='b'></ref> tres<ref name="a"/>`, []CitedName{{"a", 2}, {"b", 1}}},
+		{`<ref name="no vale"/>`, nil},
+	}
+
+	for _, tt := range tests {
+		if got := Citations(tt.content); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Citations(%q) = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestRenderCitations(t *testing.T) {
+	cite := func(name string) (int, bool) {
+		number, ok := map[string]int{"a": 1, "b": 2}[name]
+		return number, ok
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "ref element",
+			content: `<p>Texto<ref name="a"></ref>.</p>`,
+			want:    `<p>Texto<sup class="reference" id="cite-ref-a-1"><a href="#cite-note-a">[1]</a></sup>.</p>`,
+		},
+		{
+			name:    "placeholder cited twice",
+			content: "<p>" + MarkCitations(`Uno<ref name="b"/> dos<ref name="b"/>`) + "</p>",
+			want:    `<p>Uno<sup class="reference" id="cite-ref-b-1"><a href="#cite-note-b">[2]</a></sup> dos<sup class="reference" id="cite-ref-b-2"><a href="#cite-note-b">[2]</a></sup></p>`,
+		},
+		{
+			name:    "missing reference",
+			content: `<p>Texto<ref name="c"/></p>`,
+			want:    `<p>Texto<sup class="reference reference-missing" title="c">[?]</sup></p>`,
+		},
+		{
+			name:    "ref element in code",
+			content: `<pre><code>&lt;b&gt;<ref name="a"></ref></code></pre>`,
+			want:    `<pre><code>&lt;b&gt;<ref name="a"></ref></code></pre>`,
+		},
+		{
+			name:    "placeholder in code",
+			content: "<p><code>" + MarkCitations(`<ref name="a"/>`) + "</code></p>",
+			want:    `<p><code>&lt;ref name=&#34;a&#34;/&gt;</code></p>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RenderCitations(tt.content, cite); got != tt.want {
+				t.Errorf("RenderCitations() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// Delta operations. A delta is a sequence of operations that rebuild a content from a base content:
+// copy (offset and length in the base, as uvarints) and insert (length as uvarint, then the bytes).
+const (
+	deltaCopy   byte = 'c'
+	deltaInsert byte = 'i'
+)
+
+var errInvalidDelta = errors.New("invalid delta")
+
+type deltaOp struct {
+	copy   bool
+	offset int
+	length int
+	text   string
+}
+
+// EncodeDelta computes a delta that rebuilds target from base. The common start and end of both contents
+// are copied as is, the rest is compared block by block like Diff does.
+func EncodeDelta(base, target string) []byte {
+	prefix := 0
+	for prefix < len(base) && prefix < len(target) && base[prefix] == target[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(base)-prefix && suffix < len(target)-prefix && base[len(base)-1-suffix] == target[len(target)-1-suffix] {
+		suffix++
+	}
+
+	ops := appendCopy(nil, 0, prefix)
+	offset := prefix
+	for _, line := range DiffBlocks(splitTokens(base[prefix:len(base)-suffix]), splitTokens(target[prefix:len(target)-suffix])) {
+		switch line.Op {
+		case OpEqual:
+			ops = appendCopy(ops, offset, len(line.Text))
+			offset += len(line.Text)
+		case OpDelete:
+			offset += len(line.Text)
+		case OpInsert:
+			ops = appendInsert(ops, line.Text)
+		}
+	}
+	ops = appendCopy(ops, len(base)-suffix, suffix)
+
+	var delta []byte
+	for _, op := range ops {
+		if op.copy {
+			delta = append(delta, deltaCopy)
+			delta = binary.AppendUvarint(delta, uint64(op.offset))
+			delta = binary.AppendUvarint(delta, uint64(op.length))
+		} else {
+			delta = append(delta, deltaInsert)
+			delta = binary.AppendUvarint(delta, uint64(len(op.text)))
+			delta = append(delta, op.text...)
+		}
+	}
+	return delta
+}
+
+// ApplyDelta rebuilds a content from its base and a delta made by EncodeDelta
+func ApplyDelta(base string, delta []byte) (string, error) {
+	var content []byte
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch op {
+		case deltaCopy:
+			offset, n := binary.Uvarint(delta)
+			if n <= 0 {
+				return "", errInvalidDelta
+			}
+			delta = delta[n:]
+			length, n := binary.Uvarint(delta)
+			if n <= 0 || offset > uint64(len(base)) || length > uint64(len(base))-offset {
+				return "", errInvalidDelta
+			}
+			delta = delta[n:]
+			content = append(content, base[offset:offset+length]...)
+		case deltaInsert:
+			length, n := binary.Uvarint(delta)
+			if n <= 0 || uint64(len(delta)-n) < length {
+				return "", errInvalidDelta
+			}
+			delta = delta[n:]
+			content = append(content, delta[:length]...)
+			delta = delta[length:]
+		default:
+			return "", errInvalidDelta
+		}
+	}
+	return string(content), nil
+}
+
+// Checksum is used to verify the content rebuilt from a delta
+func Checksum(content string) uint32 {
+	return crc32.ChecksumIEEE([]byte(content))
+}
+
+// splitTokens splits content at the same places as SplitBlocks, but keeps every byte
+// so the tokens add up to the content again.
+func splitTokens(content string) []string {
+	var tokens []string
+	last := 0
+	for _, loc := range blockEnd.FindAllStringIndex(content, -1) {
+		tokens = append(tokens, content[last:loc[1]])
+		last = loc[1]
+	}
+	if last < len(content) {
+		tokens = append(tokens, content[last:])
+	}
+	return tokens
+}
+
+func appendCopy(ops []deltaOp, offset, length int) []deltaOp {
+	if length == 0 {
+		return ops
+	}
+	if n := len(ops); n > 0 && ops[n-1].copy && ops[n-1].offset+ops[n-1].length == offset {
+		ops[n-1].length += length
+		return ops
+	}
+	return append(ops, deltaOp{copy: true, offset: offset, length: length})
+}
+
+func appendInsert(ops []deltaOp, text string) []deltaOp {
+	if n := len(ops); n > 0 && !ops[n-1].copy {
+		ops[n-1].text += text
+		return ops
+	}
+	return append(ops, deltaOp{text: text})
+}
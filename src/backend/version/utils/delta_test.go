@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestDeltaRoundTrip(t *testing.T) {
+	long := strings.Repeat("Una línea de la entrada.\n", 200)
+
+	tests := []struct {
+		name   string
+		base   string
+		target string
+	}{
+		{"both empty", "", ""},
+		{"empty base", "", "Contenido nuevo.\n"},
+		{"empty target", "Contenido viejo.\n", ""},
+		{"identical", "Sin cambios.\n\nOtro párrafo.\n", "Sin cambios.\n\nOtro párrafo.\n"},
+		{"appended paragraph", "Primero.\n\nSegundo.\n", "Primero.\n\nSegundo.\n\nTercero.\n"},
+		{"prepended paragraph", "Primero.\n\nSegundo.\n", "Cero.\n\nPrimero.\n\nSegundo.\n"},
+		{"changed middle", "Uno.\n\nDos.\n\nTres.\n", "Uno.\n\nDOS.\n\nTres.\n"},
+		{"deleted middle", "Uno.\n\nDos.\n\nTres.\n", "Uno.\n\nTres.\n"},
+		{"multibyte", "El ñandú corre.\n", "El ñandú camina.\n"},
+		{"prefix and suffix overlap", "aaa", "aaaa"},
+		{"unrelated", "Nada en común.", "Todo distinto!"},
+		{"long edit", long, strings.Replace(long, "Una línea", "Otra línea", 3)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delta := EncodeDelta(tt.base, tt.target)
+			got, err := ApplyDelta(tt.base, delta)
+			if err != nil {
+				t.Fatalf("ApplyDelta() error = %v", err)
+			}
+			if got != tt.target {
+				t.Errorf("ApplyDelta() = %q, want %q", got, tt.target)
+			}
+			if Checksum(got) != Checksum(tt.target) {
+				t.Errorf("Checksum() of the rebuilt content doesn't match")
+			}
+		})
+	}
+}
+
+func TestEncodeDeltaSmallEdit(t *testing.T) {
+	base := strings.Repeat("Una línea de la entrada.\n", 200)
+	target := base + "Una línea más.\n"
+
+	if delta := EncodeDelta(base, target); len(delta) > 64 {
+		t.Errorf("EncodeDelta() of a small edit is %d bytes, want at most 64", len(delta))
+	}
+}
+
+func TestApplyDeltaInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		base  string
+		delta []byte
+	}{
+		{"unknown operation", "base", []byte{'x'}},
+		{"truncated copy", "base", []byte{deltaCopy}},
+		{"copy out of range", "base", []byte{deltaCopy, 2, 10}},
+		{"truncated insert", "base", []byte{deltaInsert, 5, 'a'}},
+		{"copy length overflows", "base", append(append([]byte{deltaCopy}, binary.AppendUvarint(nil, 2)...), binary.AppendUvarint(nil, math.MaxUint64-1)...)},
+		{"copy offset overflows", "base", append(append([]byte{deltaCopy}, binary.AppendUvarint(nil, math.MaxUint64)...), binary.AppendUvarint(nil, 2)...)},
+		{"insert length overflows", "base", append([]byte{deltaInsert}, binary.AppendUvarint(nil, math.MaxUint64)...)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ApplyDelta(tt.base, tt.delta); err == nil {
+				t.Errorf("ApplyDelta() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"", "", true},
+		{"contenido", "contenido", true},
+		{"contenido", "Contenido", false},
+		{"contenido", "contenido ", false},
+	}
+
+	for _, tt := range tests {
+		if got := Checksum(tt.a) == Checksum(tt.b); got != tt.same {
+			t.Errorf("Checksum(%q) == Checksum(%q) is %v, want %v", tt.a, tt.b, got, tt.same)
+		}
+	}
+}
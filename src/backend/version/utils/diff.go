@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Diff operations
+const (
+	OpEqual  = "equal"
+	OpInsert = "insert"
+	OpDelete = "delete"
+)
+
+// maxLCSCells caps the size of the LCS table so a pathological diff can't eat the memory of the service
+const maxLCSCells = 4_000_000
+
+// blockEnd matches the end of a block-level HTML element (or a line break), which is where content gets split
+var blockEnd = regexp.MustCompile(`(?i)(</(p|h[1-6]|li|ul|ol|blockquote|pre|div|table|tr)>|<br\s*/?>|\n)`)
+
+// DiffLine is a single block of content in a diff
+type DiffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// SplitBlocks splits version content into blocks (paragraphs, headings, list items, lines...).
+// Empty blocks are dropped.
+func SplitBlocks(content string) []string {
+	var blocks []string
+	last := 0
+	for _, loc := range blockEnd.FindAllStringIndex(content, -1) {
+		blocks = appendBlock(blocks, content[last:loc[1]])
+		last = loc[1]
+	}
+	blocks = appendBlock(blocks, content[last:])
+	return blocks
+}
+
+func appendBlock(blocks []string, block string) []string {
+	block = strings.TrimSpace(block)
+	if block == "" {
+		return blocks
+	}
+	return append(blocks, block)
+}
+
+// Diff computes the block-level difference between two contents
+func Diff(oldContent, newContent string) []DiffLine {
+	return DiffBlocks(SplitBlocks(oldContent), SplitBlocks(newContent))
+}
+
+// DiffBlocks computes the difference between two lists of blocks.
+// The common prefix and suffix are trimmed before running a LCS on the remaining blocks.
+func DiffBlocks(a, b []string) []DiffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var lines []DiffLine
+	for _, block := range a[:prefix] {
+		lines = append(lines, DiffLine{Op: OpEqual, Text: block})
+	}
+	lines = append(lines, lcsDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, block := range a[len(a)-suffix:] {
+		lines = append(lines, DiffLine{Op: OpEqual, Text: block})
+	}
+	return lines
+}
+
+func lcsDiff(a, b []string) []DiffLine {
+	n, m := len(a), len(b)
+	var lines []DiffLine
+
+	// too big to compare block by block, replace everything
+	if n*m > maxLCSCells {
+		for _, block := range a {
+			lines = append(lines, DiffLine{Op: OpDelete, Text: block})
+		}
+		for _, block := range b {
+			lines = append(lines, DiffLine{Op: OpInsert, Text: block})
+		}
+		return lines
+	}
+
+	// table[i][j] holds the LCS length of a[i:] and b[j:]
+	table := make([][]int32, n+1)
+	for i := range table {
+		table[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				table[i][j] = table[i+1][j+1] + 1
+			} else if table[i+1][j] >= table[i][j+1] {
+				table[i][j] = table[i+1][j]
+			} else {
+				table[i][j] = table[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, DiffLine{Op: OpEqual, Text: a[i]})
+			i++
+			j++
+		case table[i+1][j] >= table[i][j+1]:
+			lines = append(lines, DiffLine{Op: OpDelete, Text: a[i]})
+			i++
+		default:
+			lines = append(lines, DiffLine{Op: OpInsert, Text: b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		lines = append(lines, DiffLine{Op: OpDelete, Text: a[i]})
+	}
+	for ; j < m; j++ {
+		lines = append(lines, DiffLine{Op: OpInsert, Text: b[j]})
+	}
+	return lines
+}
+
+// DiffStats counts the inserted and deleted blocks of a diff
+func DiffStats(lines []DiffLine) (added int, removed int) {
+	for _, line := range lines {
+		switch line.Op {
+		case OpInsert:
+			added++
+		case OpDelete:
+			removed++
+		}
+	}
+	return added, removed
+}
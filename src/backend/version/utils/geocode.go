@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// ErrAddressNotFound is returned by the geocoders for the addresses they can't place
+var ErrAddressNotFound = errors.New("address not found")
+
+// Coordinates are a latitude and a longitude in degrees
+type Coordinates struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// Valid reports whether the coordinates are on the globe
+func (c Coordinates) Valid() bool {
+	return c.Lat >= -90 && c.Lat <= 90 && c.Lon >= -180 && c.Lon <= 180
+}
+
+// Geocoder turns the free-text address of a version into coordinates
+type Geocoder interface {
+	Geocode(ctx context.Context, address string) (Coordinates, error)
+}
+
+// Gazetteer is an offline geocoder that looks the addresses up in a list of known places
+type Gazetteer struct {
+	places map[string]Coordinates
+}
+
+// LoadGazetteer reads a gazetteer from a CSV file of name,latitude,longitude lines. Lines starting with # are
+// comments.
+func LoadGazetteer(path string) (*Gazetteer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadGazetteer(file)
+}
+
+// ReadGazetteer reads a gazetteer in the format of LoadGazetteer
+func ReadGazetteer(r io.Reader) (*Gazetteer, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	g := &Gazetteer{places: map[string]Coordinates{}}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return g, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		lat, errLat := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		lon, errLon := strconv.ParseFloat(strings.TrimSpace(record[2]), 64)
+		coordinates := Coordinates{Lat: lat, Lon: lon}
+		if errLat != nil || errLon != nil || !coordinates.Valid() {
+			line, _ := reader.FieldPos(0)
+			return nil, fmt.Errorf("gazetteer line %d: invalid coordinates", line)
+		}
+		if name := normalizePlace(record[0]); name != "" {
+			g.places[name] = coordinates
+		}
+	}
+}
+
+// Len returns the number of places of the gazetteer
+func (g *Gazetteer) Len() int {
+	return len(g.places)
+}
+
+// Geocode looks the whole address up, and then each of its comma-separated parts from the most specific,
+// so "Calle Larios 5, Málaga, España" is placed in Málaga when the street isn't known. Case and accents
+// don't matter.
+func (g *Gazetteer) Geocode(ctx context.Context, address string) (Coordinates, error) {
+	if coordinates, ok := g.places[normalizePlace(address)]; ok {
+		return coordinates, nil
+	}
+	for _, part := range strings.Split(address, ",") {
+		if coordinates, ok := g.places[normalizePlace(part)]; ok {
+			return coordinates, nil
+		}
+	}
+	return Coordinates{}, ErrAddressNotFound
+}
+
+// normalizePlace lowercases a place name, drops its accents and collapses its whitespace
+func normalizePlace(name string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(strings.ToLower(name)) {
+		if !unicode.Is(unicode.Mn, r) {
+			b.WriteRune(r)
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// Nominatim geocodes the addresses with the search API of a Nominatim server
+type Nominatim struct {
+	BaseURL   string
+	UserAgent string
+	Client    *http.Client
+}
+
+// Geocode returns the coordinates of the first result of the search for the address
+func (n *Nominatim) Geocode(ctx context.Context, address string) (Coordinates, error) {
+	searchURL := fmt.Sprintf("%s/search?format=json&limit=1&q=%s", strings.TrimRight(n.BaseURL, "/"), url.QueryEscape(address))
+	req, err := http.NewRequestWithContext(ctx, "GET", searchURL, nil)
+	if err != nil {
+		return Coordinates{}, err
+	}
+	// the usage policy of the public servers requires identifying the application
+	req.Header.Set("User-Agent", n.UserAgent)
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return Coordinates{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return Coordinates{}, fmt.Errorf("geocoder returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var results []struct {
+		Lat string `json:"lat"`
+		Lon string `json:"lon"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return Coordinates{}, err
+	}
+	if len(results) == 0 {
+		return Coordinates{}, ErrAddressNotFound
+	}
+	lat, errLat := strconv.ParseFloat(results[0].Lat, 64)
+	lon, errLon := strconv.ParseFloat(results[0].Lon, 64)
+	if errLat != nil || errLon != nil {
+		return Coordinates{}, fmt.Errorf("geocoder returned invalid coordinates %q, %q", results[0].Lat, results[0].Lon)
+	}
+	return Coordinates{Lat: lat, Lon: lon}, nil
+}
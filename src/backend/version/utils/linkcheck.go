@@ -0,0 +1,239 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// ErrPrivateAddress is returned when a link leads to an address of the private network
+var ErrPrivateAddress = errors.New("private address")
+
+// ExternalLinks returns the absolute http(s) URLs the anchors of rendered HTML link to, without fragment
+// and without repeating any
+func ExternalLinks(content string) []string {
+	var links []string
+	seen := map[string]bool{}
+	z := xhtml.NewTokenizer(strings.NewReader(content))
+	for {
+		tokenType := z.Next()
+		if tokenType == xhtml.ErrorToken {
+			return links
+		}
+		if tokenType != xhtml.StartTagToken {
+			continue
+		}
+		token := z.Token()
+		if token.Data != "a" {
+			continue
+		}
+		for _, attr := range token.Attr {
+			if attr.Key != "href" {
+				continue
+			}
+			if link, ok := ExternalLink(attr.Val); ok && !seen[link] {
+				seen[link] = true
+				links = append(links, link)
+			}
+		}
+	}
+}
+
+// ExternalLink normalizes a URL that can be checked, reporting false for relative and non-http(s) ones
+func ExternalLink(raw string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return "", false
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.Host = strings.ToLower(u.Host)
+	return u.String(), true
+}
+
+// LinkStatus is the result of checking an external link. Broken links are those that can't be reached or
+// are gone: a network error, 404, 410 or a server error. Links that need authorization or limit the rate of
+// requests answer, so they aren't broken.
+type LinkStatus struct {
+	URL        string
+	StatusCode int
+	Error      string
+	Broken     bool
+	CheckedAt  time.Time
+}
+
+// LinkChecker probes external links with a bounded number of workers, waiting HostDelay between the
+// requests to the same host. Client is the HTTP client the probes are made with.
+type LinkChecker struct {
+	Client    *http.Client
+	Workers   int
+	HostDelay time.Duration
+	UserAgent string
+
+	mutex sync.Mutex
+	hosts map[string]*hostSlot
+}
+
+// hostSlot is the next moment a host can be requested
+type hostSlot struct {
+	mutex sync.Mutex
+	next  time.Time
+}
+
+// NewLinkChecker creates a link checker probing with the given client
+func NewLinkChecker(client *http.Client, workers int, hostDelay time.Duration) *LinkChecker {
+	if workers < 1 {
+		workers = 1
+	}
+	return &LinkChecker{
+		Client:    client,
+		Workers:   workers,
+		HostDelay: hostDelay,
+		UserAgent: "laWiki link checker",
+		hosts:     map[string]*hostSlot{},
+	}
+}
+
+// Check probes the links, returning their status in the same order. Links left when the context is done
+// are returned with its error, not as broken.
+func (c *LinkChecker) Check(ctx context.Context, links []string) []LinkStatus {
+	statuses := make([]LinkStatus, len(links))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < c.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				statuses[job] = c.probe(ctx, links[job])
+			}
+		}()
+	}
+
+	for i := range links {
+		if ctx.Err() != nil {
+			statuses[i] = LinkStatus{URL: links[i], Error: ctx.Err().Error(), CheckedAt: time.Now().UTC()}
+			continue
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return statuses
+}
+
+// wait blocks until the host of a link can be requested again, and books the following slot
+func (c *LinkChecker) wait(ctx context.Context, host string) error {
+	c.mutex.Lock()
+	if c.hosts == nil {
+		c.hosts = map[string]*hostSlot{}
+	}
+	slot, ok := c.hosts[host]
+	if !ok {
+		slot = &hostSlot{}
+		c.hosts[host] = slot
+	}
+	c.mutex.Unlock()
+
+	slot.mutex.Lock()
+	defer slot.mutex.Unlock()
+	if delay := time.Until(slot.next); delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	slot.next = time.Now().Add(c.HostDelay)
+	return nil
+}
+
+// probe requests a link with HEAD, and with GET when the server doesn't allow HEAD
+func (c *LinkChecker) probe(ctx context.Context, link string) LinkStatus {
+	status := LinkStatus{URL: link}
+	u, err := url.Parse(link)
+	if err != nil {
+		status.Error = err.Error()
+		status.Broken = true
+		status.CheckedAt = time.Now().UTC()
+		return status
+	}
+
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		if err := c.wait(ctx, u.Host); err != nil {
+			status.Error = err.Error()
+			status.CheckedAt = time.Now().UTC()
+			return status
+		}
+		status.StatusCode, err = c.request(ctx, method, link)
+		status.CheckedAt = time.Now().UTC()
+		if err != nil || (status.StatusCode != http.StatusMethodNotAllowed && status.StatusCode != http.StatusNotImplemented) {
+			break
+		}
+	}
+
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// an interrupted check says nothing about the link
+		status.Error = ctx.Err().Error()
+	case errors.Is(err, ErrPrivateAddress):
+		// nor does a link the checker isn't allowed to follow
+		status.Error = err.Error()
+	case err != nil:
+		status.Error = err.Error()
+		status.Broken = true
+	default:
+		status.Broken = status.StatusCode == http.StatusNotFound || status.StatusCode == http.StatusGone || status.StatusCode >= 500
+	}
+	return status
+}
+
+// request makes a request without reading the body, returning the status code
+func (c *LinkChecker) request(ctx context.Context, method string, link string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, link, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", c.UserAgent)
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// NewPublicClient returns an HTTP client that only connects to public addresses, so links in the contents
+// can't be used to reach the services of the private network
+func NewPublicClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+				ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+				return fmt.Errorf("%s: %w", host, ErrPrivateAddress)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
@@ -0,0 +1,233 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLinkCheckerStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		w.WriteHeader(code)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		code   int
+		broken bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusNoContent, false},
+		{http.StatusUnauthorized, false},
+		{http.StatusForbidden, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusNotFound, true},
+		{http.StatusGone, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusBadGateway, true},
+		{http.StatusServiceUnavailable, true},
+	}
+
+	checker := NewLinkChecker(server.Client(), 4, 0)
+	var links []string
+	for _, tt := range tests {
+		links = append(links, server.URL+"/"+strconv.Itoa(tt.code))
+	}
+	statuses := checker.Check(context.Background(), links)
+
+	for i, tt := range tests {
+		status := statuses[i]
+		if status.URL != links[i] {
+			t.Errorf("Check()[%d].URL = %q, want %q", i, status.URL, links[i])
+		}
+		if status.StatusCode != tt.code || status.Broken != tt.broken {
+			t.Errorf("Check() of a %d = %d, broken %v, want broken %v", tt.code, status.StatusCode, status.Broken, tt.broken)
+		}
+		if status.CheckedAt.IsZero() {
+			t.Errorf("Check() of a %d has no CheckedAt", tt.code)
+		}
+	}
+}
+
+func TestLinkCheckerHeadFallback(t *testing.T) {
+	tests := []struct {
+		name        string
+		headCode    int
+		wantMethods []string
+		wantCode    int
+		wantBroken  bool
+	}{
+		{"head allowed", http.StatusOK, []string{"HEAD"}, http.StatusOK, false},
+		{"head not allowed", http.StatusMethodNotAllowed, []string{"HEAD", "GET"}, http.StatusNotFound, true},
+		{"head not implemented", http.StatusNotImplemented, []string{"HEAD", "GET"}, http.StatusNotFound, true},
+		{"head gone", http.StatusGone, []string{"HEAD"}, http.StatusGone, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mutex sync.Mutex
+			var methods []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mutex.Lock()
+				methods = append(methods, r.Method)
+				mutex.Unlock()
+				if r.Method == http.MethodHead {
+					w.WriteHeader(tt.headCode)
+					return
+				}
+				w.WriteHeader(http.StatusNotFound)
+			}))
+			defer server.Close()
+
+			status := NewLinkChecker(server.Client(), 1, 0).Check(context.Background(), []string{server.URL})[0]
+			if !reflect.DeepEqual(methods, tt.wantMethods) {
+				t.Errorf("Check() requested with %v, want %v", methods, tt.wantMethods)
+			}
+			if status.StatusCode != tt.wantCode || status.Broken != tt.wantBroken {
+				t.Errorf("Check() = %d, broken %v, want %d, broken %v", status.StatusCode, status.Broken, tt.wantCode, tt.wantBroken)
+			}
+		})
+	}
+}
+
+func TestLinkCheckerHostDelay(t *testing.T) {
+	const delay = 50 * time.Millisecond
+
+	var mutex sync.Mutex
+	var times []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		times = append(times, time.Now())
+		mutex.Unlock()
+	}))
+	defer server.Close()
+
+	links := []string{server.URL + "/a", server.URL + "/b", server.URL + "/c", server.URL + "/d"}
+	NewLinkChecker(server.Client(), len(links), delay).Check(context.Background(), links)
+
+	if len(times) != len(links) {
+		t.Fatalf("the server got %d requests, want %d", len(times), len(links))
+	}
+	for i := 1; i < len(times); i++ {
+		// the slot is booked before the request is sent, so allow for some scheduling jitter
+		if gap := times[i].Sub(times[i-1]); gap < delay-10*time.Millisecond {
+			t.Errorf("requests %d and %d to the same host were %v apart, want at least %v", i-1, i, gap, delay)
+		}
+	}
+}
+
+func TestLinkCheckerWorkers(t *testing.T) {
+	tests := []struct {
+		workers int
+		want    int
+	}{
+		{0, 1},
+		{1, 1},
+		{3, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.workers), func(t *testing.T) {
+			var mutex sync.Mutex
+			inFlight, most := 0, 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mutex.Lock()
+				inFlight++
+				most = max(most, inFlight)
+				mutex.Unlock()
+				time.Sleep(20 * time.Millisecond)
+				mutex.Lock()
+				inFlight--
+				mutex.Unlock()
+			}))
+			defer server.Close()
+
+			var links []string
+			for i := 0; i < 12; i++ {
+				links = append(links, server.URL+"/"+strconv.Itoa(i))
+			}
+			checker := NewLinkChecker(server.Client(), tt.workers, 0)
+			if checker.Workers != tt.want {
+				t.Errorf("NewLinkChecker() has %d workers, want %d", checker.Workers, tt.want)
+			}
+			checker.Check(context.Background(), links)
+
+			if most > tt.want {
+				t.Errorf("Check() made %d requests at once, want at most %d", most, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinkCheckerErrors(t *testing.T) {
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closedURL := closed.URL
+	closed.Close()
+
+	status := NewLinkChecker(&http.Client{Timeout: time.Second}, 1, 0).Check(context.Background(), []string{closedURL})[0]
+	if !status.Broken || status.Error == "" {
+		t.Errorf("Check() of an unreachable link = broken %v, error %q, want broken with an error", status.Broken, status.Error)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	status = NewLinkChecker(&http.Client{Timeout: time.Second}, 1, 0).Check(ctx, []string{closedURL})[0]
+	if status.Broken || status.Error == "" {
+		t.Errorf("Check() after the context is done = broken %v, error %q, want not broken with an error", status.Broken, status.Error)
+	}
+}
+
+func TestNewPublicClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client := NewPublicClient(time.Second)
+	tests := []string{
+		server.URL,
+		"http://127.0.0.1:1/",
+		"http://[::1]:1/",
+		"http://10.0.0.1/",
+		"http://172.16.0.1/",
+		"http://192.168.1.1/",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://0.0.0.0/",
+		"http://[fd00::1]/",
+	}
+
+	for _, link := range tests {
+		t.Run(link, func(t *testing.T) {
+			resp, err := client.Get(link)
+			if err == nil {
+				resp.Body.Close()
+				t.Fatalf("Get(%q) connected, want ErrPrivateAddress", link)
+			}
+			if !errors.Is(err, ErrPrivateAddress) {
+				t.Errorf("Get(%q) error = %v, want ErrPrivateAddress", link, err)
+			}
+		})
+	}
+
+	// the checker doesn't count links it isn't allowed to follow as broken
+	status := NewLinkChecker(client, 1, 0).Check(context.Background(), []string{server.URL})[0]
+	if status.Broken || status.Error == "" {
+		t.Errorf("Check() of a private link = broken %v, error %q, want not broken with an error", status.Broken, status.Error)
+	}
+}
+
+func TestExternalLinks(t *testing.T) {
+	content := `<p><a href="https://Example.org/a#top">a</a> <a href="/wiki/b">b</a> <a href="mailto:x@example.org">c</a>` +
+		` <a href="https://example.org/a">d</a> <a href=" http://example.net/?q=1 ">e</a></p>`
+	want := []string{"https://example.org/a", "http://example.net/?q=1"}
+
+	if got := ExternalLinks(content); !reflect.DeepEqual(got, want) {
+		t.Errorf("ExternalLinks() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+)
+
+// markdown renders CommonMark with the GitHub tables, strikethrough and autolinks. Raw HTML in the source
+// is dropped by the renderer, and headings get an anchor ID from their text.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.Table, extension.Strikethrough, extension.Linkify),
+	goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+)
+
+// headingIDs generates the anchors of the headings from their text, keeping accented letters
+// so Spanish titles stay readable. Repeated anchors get a numeric suffix.
+type headingIDs struct {
+	used map[string]bool
+}
+
+func (ids *headingIDs) Generate(value []byte, kind ast.NodeKind) []byte {
+	var id strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(string(value)) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if dash && id.Len() > 0 {
+				id.WriteByte('-')
+			}
+			id.WriteRune(r)
+			dash = false
+		case r == '-' || r == '_' || unicode.IsSpace(r):
+			dash = true
+		}
+	}
+	base := id.String()
+	if base == "" {
+		base = "section"
+	}
+
+	unique := base
+	for i := 1; ids.used[unique]; i++ {
+		unique = fmt.Sprintf("%s-%d", base, i)
+	}
+	ids.used[unique] = true
+	return []byte(unique)
+}
+
+func (ids *headingIDs) Put(value []byte) {
+	ids.used[string(value)] = true
+}
+
+// RenderMarkdown renders markdown to HTML sanitized with the policy
+func RenderMarkdown(source string, policy *ContentPolicy) (string, error) {
+	var buf bytes.Buffer
+	ctx := parser.NewContext(parser.WithIDs(&headingIDs{used: map[string]bool{}}))
+	if err := markdown.Convert([]byte(source), &buf, parser.WithContext(ctx)); err != nil {
+		return "", err
+	}
+	return sanitizer(policy, true).Sanitize(buf.String()), nil
+}
+
+// RenderCache keeps the most recently rendered contents. Contents can be tagged with what they were rendered
+// from, like the templates they include, to drop them once it changes.
+type RenderCache struct {
+	mutex sync.Mutex
+	size  int
+	order *list.List
+	items map[string]*list.Element
+	tags  map[string]map[string]bool
+}
+
+type renderedContent struct {
+	key  string
+	html string
+	tags []string
+}
+
+// NewRenderCache creates a cache holding up to size rendered contents
+func NewRenderCache(size int) *RenderCache {
+	return &RenderCache{size: size, order: list.New(), items: map[string]*list.Element{}, tags: map[string]map[string]bool{}}
+}
+
+// RenderKey is the key of a source rendered with a policy
+func RenderKey(source string, policy *ContentPolicy) string {
+	sum := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(sum[:]) + "|" + policy.key()
+}
+
+// Render renders markdown like RenderMarkdown, reusing the previous result for the same source and policy
+func (c *RenderCache) Render(source string, policy *ContentPolicy) (string, error) {
+	key := RenderKey(source, policy)
+	if html, ok := c.Get(key); ok {
+		return html, nil
+	}
+
+	html, err := RenderMarkdown(source, policy)
+	if err != nil {
+		return "", err
+	}
+	c.Add(key, html, nil)
+	return html, nil
+}
+
+// Get returns the content rendered with a key
+func (c *RenderCache) Get(key string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.items[key]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*renderedContent).html, true
+}
+
+// Add keeps a rendered content with its tags, dropping the least recently used one when the cache is full
+func (c *RenderCache) Add(key string, html string, tags []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.items[key]; ok {
+		return
+	}
+	c.items[key] = c.order.PushFront(&renderedContent{key: key, html: html, tags: tags})
+	for _, tag := range tags {
+		if c.tags[tag] == nil {
+			c.tags[tag] = map[string]bool{}
+		}
+		c.tags[tag][key] = true
+	}
+	if c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// Invalidate drops the rendered contents with a tag
+func (c *RenderCache) Invalidate(tag string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key := range c.tags[tag] {
+		if element, ok := c.items[key]; ok {
+			c.remove(element)
+		}
+	}
+	delete(c.tags, tag)
+}
+
+func (c *RenderCache) remove(element *list.Element) {
+	content := element.Value.(*renderedContent)
+	c.order.Remove(element)
+	delete(c.items, content.key)
+	for _, tag := range content.tags {
+		delete(c.tags[tag], content.key)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+}
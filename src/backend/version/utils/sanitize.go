@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// Content profiles of the wikis, see the wiki service
+const (
+	ContentText  = "text"
+	ContentBasic = "basic"
+	ContentRich  = "rich"
+)
+
+// ContentPolicy is the HTML a wiki allows in its content
+type ContentPolicy struct {
+	Profile         string   `json:"profile"`
+	ExtraTags       []string `json:"extra_tags"`
+	ExtraAttributes []string `json:"extra_attributes"`
+}
+
+// codeLanguage is the class of the code blocks with a language
+var codeLanguage = regexp.MustCompile(`^language-[\w+#-]+$`)
+
+var (
+	policiesMutex sync.Mutex
+	policies      = map[string]*bluemonday.Policy{}
+)
+
+// Sanitize removes from content the HTML the policy doesn't allow. A nil policy is the basic profile.
+func Sanitize(content string, policy *ContentPolicy) string {
+	return sanitizer(policy, false).Sanitize(content)
+}
+
+// key identifies the policy in the caches
+func (policy *ContentPolicy) key() string {
+	if policy == nil {
+		return ContentBasic
+	}
+	return policy.Profile + "|" + strings.Join(policy.ExtraTags, ",") + "|" + strings.Join(policy.ExtraAttributes, ",")
+}
+
+// sanitizer returns the bluemonday policy of a content policy. The markdown one also allows what the
+// markdown renderer produces: headings with anchors, tables and code blocks.
+func sanitizer(policy *ContentPolicy, markdown bool) *bluemonday.Policy {
+	if policy == nil {
+		policy = &ContentPolicy{Profile: ContentBasic}
+	}
+
+	key := policy.key()
+	if markdown {
+		key += "|markdown"
+	}
+	policiesMutex.Lock()
+	defer policiesMutex.Unlock()
+	p, ok := policies[key]
+	if !ok {
+		p = buildPolicy(policy, markdown)
+		policies[key] = p
+	}
+	return p
+}
+
+func buildPolicy(policy *ContentPolicy, markdown bool) *bluemonday.Policy {
+	if policy.Profile == ContentText {
+		p := bluemonday.StrictPolicy()
+		allowCitations(p)
+		return p
+	}
+
+	p := bluemonday.NewPolicy()
+	allowCitations(p)
+	p.AllowStandardURLs()
+	p.AllowAttrs("title", "lang", "dir").Globally()
+	p.AllowElements("p", "br", "hr", "b", "strong", "i", "em", "u", "s", "del", "ins", "sub", "sup",
+		"small", "mark", "span", "code", "pre", "kbd", "ul", "ol", "li")
+	p.AllowAttrs("cite").OnElements("blockquote", "q")
+	p.AllowElements("blockquote", "q")
+	p.AllowAttrs("href").OnElements("a")
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+
+	if policy.Profile == ContentRich || markdown {
+		p.AllowElements("h1", "h2", "h3", "h4", "h5", "h6")
+		p.AllowTables()
+		p.AllowAttrs("colspan", "rowspan", "scope").OnElements("td", "th")
+	}
+	if policy.Profile == ContentRich {
+		p.AllowElements("dl", "dt", "dd", "abbr", "figure", "figcaption", "details", "summary")
+		p.AllowImages()
+	}
+	if markdown {
+		p.AllowAttrs("id").Matching(bluemonday.SpaceSeparatedTokens).OnElements("h1", "h2", "h3", "h4", "h5", "h6")
+		p.AllowAttrs("class").Matching(codeLanguage).OnElements("code")
+		p.AllowStyles("text-align").MatchingEnum("left", "center", "right").OnElements("td", "th")
+	}
+
+	if len(policy.ExtraTags) > 0 {
+		p.AllowElements(policy.ExtraTags...)
+	}
+	if len(policy.ExtraAttributes) > 0 {
+		p.AllowAttrs(policy.ExtraAttributes...).Globally()
+	}
+	return p
+}
+
+// allowCitations keeps the <ref name="x"/> citations of the references in every profile, for the renderer
+// to turn into footnotes
+func allowCitations(p *bluemonday.Policy) {
+	p.AllowAttrs("name").Matching(citationName).OnElements("ref")
+}
@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// ErrSectionNotFound is returned for section numbers a content doesn't have
+var ErrSectionNotFound = errors.New("section not found")
+
+// htmlHeading matches the headings of HTML contents, with their level, attributes and inner HTML
+var htmlHeading = regexp.MustCompile(`(?is)<h([1-6])(\s[^>]*)?>(.*?)</h[1-6]\s*>`)
+
+var (
+	htmlTag       = regexp.MustCompile(`<[^>]*>`)
+	htmlIDAttr    = regexp.MustCompile(`(?i)\sid\s*=`)
+	headingOpener = regexp.MustCompile(`(?i)^<h[1-6]`)
+)
+
+// Section is a heading of a content and everything up to the next heading of the same or a higher level,
+// subsections included. Sections are numbered from 1 in the order of their headings; section 0 is the lead,
+// what comes before the first heading. Start and End are the byte offsets of the section in the content.
+type Section struct {
+	Index  int
+	Level  int
+	Title  string
+	Anchor string
+	Start  int
+	End    int
+}
+
+// Sections returns the sections of a content, in order. The anchors are those the headings get when rendered.
+func Sections(content string, markdown bool) []Section {
+	var sections []Section
+	if markdown {
+		sections = markdownHeadings(content)
+	} else {
+		sections = htmlHeadings(content)
+	}
+
+	for i := range sections {
+		sections[i].Index = i + 1
+		sections[i].End = len(content)
+		for _, next := range sections[i+1:] {
+			if next.Level <= sections[i].Level {
+				sections[i].End = next.Start
+				break
+			}
+		}
+	}
+	return sections
+}
+
+// markdownHeadings parses the headings of a markdown content with the renderer, so code blocks and the like
+// are told apart and the anchors match
+func markdownHeadings(content string) []Section {
+	source := []byte(content)
+	ctx := parser.NewContext(parser.WithIDs(&headingIDs{used: map[string]bool{}}))
+	doc := markdown.Parser().Parse(text.NewReader(source), parser.WithContext(ctx))
+
+	var sections []Section
+	for node := doc.FirstChild(); node != nil; node = node.NextSibling() {
+		heading, ok := node.(*ast.Heading)
+		if !ok || heading.Lines().Len() == 0 {
+			continue
+		}
+		start := heading.Lines().At(0).Start
+		start = strings.LastIndexByte(content[:start], '\n') + 1
+		section := Section{Level: heading.Level, Title: string(heading.Text(source)), Start: start}
+		if id, ok := heading.AttributeString("id"); ok {
+			if anchor, ok := id.([]byte); ok {
+				section.Anchor = string(anchor)
+			}
+		}
+		sections = append(sections, section)
+	}
+	return sections
+}
+
+// htmlHeadings finds the headings of an HTML content. Headings without ID get the anchor of their text, as
+// AnchorHeadings gives them.
+func htmlHeadings(content string) []Section {
+	ids := &headingIDs{used: map[string]bool{}}
+	matches := htmlHeading.FindAllStringSubmatchIndex(content, -1)
+	explicit := make([]string, len(matches))
+	for i, match := range matches {
+		if match[4] >= 0 {
+			explicit[i] = attributeValue(content[match[4]:match[5]], "id")
+			if explicit[i] != "" {
+				ids.Put([]byte(explicit[i]))
+			}
+		}
+	}
+
+	var sections []Section
+	for i, match := range matches {
+		title := headingTitle(content[match[6]:match[7]])
+		section := Section{Level: int(content[match[2]] - '0'), Title: title, Start: match[0], Anchor: explicit[i]}
+		if section.Anchor == "" {
+			section.Anchor = string(ids.Generate([]byte(title), ast.KindHeading))
+		}
+		sections = append(sections, section)
+	}
+	return sections
+}
+
+// headingTitle returns the text of the inner HTML of a heading
+func headingTitle(inner string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(htmlTag.ReplaceAllString(inner, ""))), " ")
+}
+
+var attributePattern = regexp.MustCompile(`(?i)([a-z-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+
+// attributeValue returns the value of an attribute in the attributes of a tag
+func attributeValue(attributes string, name string) string {
+	for _, match := range attributePattern.FindAllStringSubmatch(attributes, -1) {
+		if strings.EqualFold(match[1], name) {
+			return html.UnescapeString(match[2] + match[3] + match[4])
+		}
+	}
+	return ""
+}
+
+// FindSection returns a section of a content by number, 0 being the lead
+func FindSection(content string, markdown bool, index int) (Section, error) {
+	sections := Sections(content, markdown)
+	if index == 0 {
+		lead := Section{End: len(content)}
+		if len(sections) > 0 {
+			lead.End = sections[0].Start
+		}
+		return lead, nil
+	}
+	if index < 0 || index > len(sections) {
+		return Section{}, fmt.Errorf("section %d: %w", index, ErrSectionNotFound)
+	}
+	return sections[index-1], nil
+}
+
+// ReplaceSection returns the content with a section, subsections included, replaced by the given text
+func ReplaceSection(content string, markdown bool, index int, replacement string) (string, error) {
+	section, err := FindSection(content, markdown, index)
+	if err != nil {
+		return "", err
+	}
+	// the heading that follows must stay at the start of a line
+	if markdown && section.End < len(content) && replacement != "" && !strings.HasSuffix(replacement, "\n") {
+		replacement += "\n\n"
+	}
+	return content[:section.Start] + replacement + content[section.End:], nil
+}
+
+// AnchorHeadings gives the headings of rendered HTML without an ID the anchor of their text, so the table of
+// contents of HTML versions can link to them
+func AnchorHeadings(content string) string {
+	ids := &headingIDs{used: map[string]bool{}}
+	for _, match := range htmlHeading.FindAllStringSubmatch(content, -1) {
+		if id := attributeValue(match[2], "id"); id != "" {
+			ids.Put([]byte(id))
+		}
+	}
+	return htmlHeading.ReplaceAllStringFunc(content, func(heading string) string {
+		match := htmlHeading.FindStringSubmatch(heading)
+		if htmlIDAttr.MatchString(match[2]) {
+			return heading
+		}
+		anchor := ids.Generate([]byte(headingTitle(match[3])), ast.KindHeading)
+		opener := headingOpener.FindString(heading)
+		return opener + fmt.Sprintf(` id="%s"`, html.EscapeString(string(anchor))) + heading[len(opener):]
+	})
+}
@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Limits of the template expansion, so templates including each other can't blow up a page
+const (
+	MaxTemplateDepth     = 8   // templates included by included templates
+	MaxTemplateInclusion = 200 // templates expanded in a whole page
+)
+
+// templateCall matches {{Template}} and {{Template|param|name=value}}. Matches inside the {{{param}}} of a
+// template body are skipped by templateCalls.
+var templateCall = regexp.MustCompile(`\{\{([^{}|]+)((?:\|[^{}]*)?)\}\}`)
+
+// templateParam matches {{{param}}} and {{{param|default}}} in the body of a template
+var templateParam = regexp.MustCompile(`\{\{\{([^{}|]+)(?:\|([^{}]*))?\}\}\}`)
+
+// Template is a template a content can include, as found by the function given to ExpandTemplates
+type Template struct {
+	ID      string
+	Content string
+}
+
+// TemplateCall is an inclusion of a template in a content
+type TemplateCall struct {
+	Name   string
+	Params map[string]string
+}
+
+// TemplateCalls returns the templates a content includes directly, in order of appearance
+func TemplateCalls(content string) []TemplateCall {
+	var calls []TemplateCall
+	for _, match := range templateCalls(content) {
+		if call := newTemplateCall(content[match[2]:match[3]], content[match[4]:match[5]]); call.Name != "" {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// templateCalls returns the submatch indexes of the template inclusions of a content
+func templateCalls(content string) [][]int {
+	var calls [][]int
+	for _, match := range templateCall.FindAllStringSubmatchIndex(content, -1) {
+		if match[0] > 0 && content[match[0]-1] == '{' {
+			continue
+		}
+		calls = append(calls, match)
+	}
+	return calls
+}
+
+func newTemplateCall(name string, params string) TemplateCall {
+	call := TemplateCall{Name: strings.Join(strings.Fields(name), " "), Params: map[string]string{}}
+	if params == "" {
+		return call
+	}
+	// unnamed parameters are numbered from 1
+	position := 0
+	for _, param := range strings.Split(params[1:], "|") {
+		if key, value, named := strings.Cut(param, "="); named && strings.TrimSpace(key) != "" {
+			call.Params[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			continue
+		}
+		position++
+		call.Params[strconv.Itoa(position)] = strings.TrimSpace(param)
+	}
+	return call
+}
+
+// ExpandTemplates replaces the templates included in a content with their bodies, filled with the parameters
+// of each inclusion. Templates included by templates are expanded too, up to MaxTemplateDepth levels.
+// find returns the template with a name; missing templates become wiki links to the entry that would hold
+// them, and inclusions past the limits or in a loop are left as they are. It returns the expanded content
+// and the IDs of the templates used.
+func ExpandTemplates(content string, find func(name string) (Template, bool)) (string, []string) {
+	e := &expansion{find: find, used: map[string]bool{}}
+	expanded := e.expand(content, nil)
+
+	ids := make([]string, 0, len(e.used))
+	for id := range e.used {
+		ids = append(ids, id)
+	}
+	return expanded, ids
+}
+
+type expansion struct {
+	find      func(name string) (Template, bool)
+	used      map[string]bool
+	inclusion int
+}
+
+func (e *expansion) expand(content string, stack []string) string {
+	var out strings.Builder
+	last := 0
+	for _, match := range templateCalls(content) {
+		out.WriteString(content[last:match[0]])
+		last = match[1]
+		out.WriteString(e.include(content[match[0]:match[1]], newTemplateCall(content[match[2]:match[3]], content[match[4]:match[5]]), stack))
+	}
+	out.WriteString(content[last:])
+	return out.String()
+}
+
+// include returns the expansion of a template inclusion
+func (e *expansion) include(match string, call TemplateCall, stack []string) string {
+	if call.Name == "" || len(stack) >= MaxTemplateDepth || e.inclusion >= MaxTemplateInclusion {
+		return match
+	}
+	for _, name := range stack {
+		if name == call.Name {
+			return match
+		}
+	}
+
+	template, ok := e.find(call.Name)
+	if !ok {
+		return "[[" + call.Name + "]]"
+	}
+	e.inclusion++
+	e.used[template.ID] = true
+
+	body := templateParam.ReplaceAllStringFunc(template.Content, func(param string) string {
+		groups := templateParam.FindStringSubmatch(param)
+		if value, ok := call.Params[strings.TrimSpace(groups[1])]; ok {
+			return value
+		}
+		return groups[2]
+	})
+	return e.expand(body, append(stack, call.Name))
+}
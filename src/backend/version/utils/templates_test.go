@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestExpandTemplates(t *testing.T) {
+	chain := map[string]string{}
+	for i := 0; i < 20; i++ {
+		chain[fmt.Sprintf("T%d", i)] = fmt.Sprintf("%d {{T%d}}", i, i+1)
+	}
+
+	tests := []struct {
+		name      string
+		content   string
+		templates map[string]string
+		want      string
+		wantUsed  []string
+	}{
+		{
+			name:      "plain",
+			content:   "Antes {{Aviso}} después",
+			templates: map[string]string{"Aviso": "<b>aviso</b>"},
+			want:      "Antes <b>aviso</b> después",
+			wantUsed:  []string{"Aviso"},
+		},
+		{
+			name:      "parameters and defaults",
+			content:   "{{Ficha|Madrid|capital=sí}}",
+			templates: map[string]string{"Ficha": "{{{1}}} ({{{capital|no}}}, {{{país|España}}})"},
+			want:      "Madrid (sí, España)",
+			wantUsed:  []string{"Ficha"},
+		},
+		{
+			name:      "missing template becomes a link",
+			content:   "Ver {{No existe}}",
+			templates: map[string]string{},
+			want:      "Ver [[No existe]]",
+			wantUsed:  []string{},
+		},
+		{
+			name:      "nested",
+			content:   "{{A}}",
+			templates: map[string]string{"A": "a{{B}}", "B": "b"},
+			want:      "ab",
+			wantUsed:  []string{"A", "B"},
+		},
+		{
+			name:      "self inclusion",
+			content:   "{{A}}",
+			templates: map[string]string{"A": "x{{A}}"},
+			want:      "x{{A}}",
+			wantUsed:  []string{"A"},
+		},
+		{
+			name:      "mutual inclusion",
+			content:   "{{A}}",
+			templates: map[string]string{"A": "a{{B}}", "B": "b{{A}}"},
+			want:      "ab{{A}}",
+			wantUsed:  []string{"A", "B"},
+		},
+		{
+			name:      "the same template twice is not a loop",
+			content:   "{{A}}",
+			templates: map[string]string{"A": "{{B}}{{B}}", "B": "b"},
+			want:      "bb",
+			wantUsed:  []string{"A", "B"},
+		},
+		{
+			name:      "depth limit",
+			content:   "{{T0}}",
+			templates: chain,
+			want:      "0 1 2 3 4 5 6 7 {{T8}}",
+			wantUsed:  []string{"T0", "T1", "T2", "T3", "T4", "T5", "T6", "T7"},
+		},
+		{
+			name:      "inclusion limit",
+			content:   strings.Repeat("{{A}}", MaxTemplateInclusion+50),
+			templates: map[string]string{"A": "a"},
+			want:      strings.Repeat("a", MaxTemplateInclusion) + strings.Repeat("{{A}}", 50),
+			wantUsed:  []string{"A"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, used := ExpandTemplates(tt.content, func(name string) (Template, bool) {
+				content, ok := tt.templates[name]
+				return Template{ID: name, Content: content}, ok
+			})
+			if got != tt.want {
+				t.Errorf("ExpandTemplates() = %q, want %q", got, tt.want)
+			}
+			sort.Strings(used)
+			if !reflect.DeepEqual(used, tt.wantUsed) {
+				t.Errorf("ExpandTemplates() used %v, want %v", used, tt.wantUsed)
+			}
+		})
+	}
+}
+
+func TestTemplateCalls(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []TemplateCall
+	}{
+		{"sin plantillas", nil},
+		{"{{ Aviso  legal }}", []TemplateCall{{Name: "Aviso legal", Params: map[string]string{}}}},
+		{"{{Ficha| a |b = c|d}}", []TemplateCall{{Name: "Ficha", Params: map[string]string{"1": "a", "b": "c", "2": "d"}}}},
+		{"{{{param}}} y {{A}}", []TemplateCall{{Name: "A", Params: map[string]string{}}}},
+	}
+
+	for _, tt := range tests {
+		if got := TemplateCalls(tt.content); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("TemplateCalls(%q) = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// wikiLink matches [[Entry title]] and [[Entry title|label]]
+var wikiLink = regexp.MustCompile(`\[\[([^\[\]|]+)(?:\|([^\[\]]+))?\]\]`)
+
+// Frontend routes the wiki links point to
+const (
+	entryPath    = "/entrada/%s"
+	newEntryPath = "/entrada/form/%s?title=%s"
+)
+
+// WikiLink is a link to another entry of the same wiki
+type WikiLink struct {
+	Title string
+	Label string
+}
+
+// WikiLinks returns the wiki links of a content, in order of appearance
+func WikiLinks(content string) []WikiLink {
+	var links []WikiLink
+	for _, match := range wikiLink.FindAllStringSubmatch(content, -1) {
+		links = append(links, newWikiLink(html.UnescapeString(match[1]), html.UnescapeString(match[2])))
+	}
+	return links
+}
+
+func newWikiLink(title, label string) WikiLink {
+	title = strings.Join(strings.Fields(title), " ")
+	label = strings.TrimSpace(label)
+	if label == "" {
+		label = title
+	}
+	return WikiLink{Title: title, Label: label}
+}
+
+// ResolveWikiLinks replaces the wiki links in the text of rendered HTML with anchors. resolve returns the ID
+// of the entry with the title; links to missing entries become red links to the form creating them in the wiki.
+// Links in code and inside other anchors are left as they are.
+func ResolveWikiLinks(content string, wikiID string, resolve func(title string) (string, bool)) string {
+	var out strings.Builder
+	skip := 0
+	z := xhtml.NewTokenizer(strings.NewReader(content))
+	for {
+		tokenType := z.Next()
+		if tokenType == xhtml.ErrorToken {
+			return out.String()
+		}
+		raw := string(z.Raw())
+
+		switch tokenType {
+		case xhtml.StartTagToken, xhtml.EndTagToken:
+			name, _ := z.TagName()
+			if tag := string(name); tag == "code" || tag == "pre" || tag == "a" {
+				if tokenType == xhtml.StartTagToken {
+					skip++
+				} else if skip > 0 {
+					skip--
+				}
+			}
+		case xhtml.TextToken:
+			if skip == 0 {
+				raw = wikiLink.ReplaceAllStringFunc(raw, func(match string) string {
+					return resolveWikiLink(match, wikiID, resolve)
+				})
+			}
+		}
+		out.WriteString(raw)
+	}
+}
+
+func resolveWikiLink(match string, wikiID string, resolve func(title string) (string, bool)) string {
+	groups := wikiLink.FindStringSubmatch(match)
+	// the text is escaped HTML
+	link := newWikiLink(html.UnescapeString(groups[1]), html.UnescapeString(groups[2]))
+	if link.Title == "" {
+		return match
+	}
+
+	label := html.EscapeString(link.Label)
+	if entryID, ok := resolve(link.Title); ok {
+		href := fmt.Sprintf(entryPath, url.PathEscape(entryID))
+		return fmt.Sprintf(`<a href="%s" class="wiki-link">%s</a>`, html.EscapeString(href), label)
+	}
+	href := fmt.Sprintf(newEntryPath, url.PathEscape(wikiID), url.QueryEscape(link.Title))
+	return fmt.Sprintf(`<a href="%s" class="wiki-link wiki-link-missing" rel="nofollow">%s</a>`, html.EscapeString(href), label)
+}
@@ -0,0 +1,285 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/laWiki/wiki/config"
+	"github.com/laWiki/wiki/database"
+	"github.com/laWiki/wiki/model"
+	"github.com/laWiki/wiki/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// reservedSlugs are the routes of the service a wiki slug would shadow
+var reservedSlugs = map[string]bool{"health": true, "search": true, "slugs": true, "slugify": true}
+
+// wikiSlug returns a slug for the title of a wiki that no other wiki uses, now or as an old slug
+func wikiSlug(ctx context.Context, title string, wikiID primitive.ObjectID) (string, error) {
+	return utils.UniqueSlug(title, "wiki", func(slug string) (bool, error) {
+		if reservedSlugs[slug] || primitive.IsValidObjectID(slug) {
+			return true, nil
+		}
+		count, err := database.WikiCollection.CountDocuments(ctx, bson.M{
+			"_id": bson.M{"$ne": wikiID},
+			"$or": bson.A{bson.M{"slug": slug}, bson.M{"old_slugs": slug}},
+		})
+		return count > 0, err
+	})
+}
+
+// renamedSlugs returns the slug of a renamed wiki and its old slugs, which keep leading to it
+func renamedSlugs(ctx context.Context, current model.Wiki, title string, wikiID primitive.ObjectID) (string, []string, error) {
+	old := append([]string{}, current.OldSlugs...)
+	if current.Slug != "" {
+		old = append(old, current.Slug)
+	}
+
+	slug := utils.Slugify(title)
+	// the wiki takes back one of its old slugs
+	for i, oldSlug := range old {
+		if oldSlug == slug {
+			return slug, append(old[:i], old[i+1:]...), nil
+		}
+	}
+	slug, err := wikiSlug(ctx, title, wikiID)
+	return slug, old, err
+}
+
+// Slug is the slug of a title
+type Slug struct {
+	Title string `json:"title"`
+	Slug  string `json:"slug"`
+}
+
+// GetSlug godoc
+// @Summary      Slug of a title
+// @Description  Turns a title into a URL slug: lowercase ASCII letters and digits separated by hyphens, with accents dropped. The entry service uses it for the slugs of the entries. Titles without letters or digits have an empty slug.
+// @Tags         Wikis
+// @Produce      application/json
+// @Param        title  query     string  true  "Title"
+// @Success      200    {object}  Slug
+// @Failure      400    {string}  string  "Title is required"
+// @Router       /api/wikis/slugify [get]
+func GetSlug(w http.ResponseWriter, r *http.Request) {
+	title := r.URL.Query().Get("title")
+	if title == "" {
+		config.App.Logger.Warn().Msg("Missing title parameter")
+		http.Error(w, "Title is required", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(Slug{Title: title, Slug: utils.Slugify(title)}); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// findWiki retrieves a wiki by its ID or slug. Old slugs find the wiki too, which is reported as a redirect.
+func findWiki(ctx context.Context, idOrSlug string) (*model.Wiki, bool, error) {
+	var wiki model.Wiki
+	if objID, err := primitive.ObjectIDFromHex(idOrSlug); err == nil {
+		err = database.WikiCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&wiki)
+		return &wiki, false, err
+	}
+
+	err := database.WikiCollection.FindOne(ctx, bson.M{"slug": idOrSlug}).Decode(&wiki)
+	if err == nil {
+		return &wiki, false, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, false, err
+	}
+	err = database.WikiCollection.FindOne(ctx, bson.M{"old_slugs": idOrSlug}).Decode(&wiki)
+	return &wiki, true, err
+}
+
+// EntryBySlug is an entry found by the slugs of its wiki and its own
+type EntryBySlug struct {
+	Wiki       model.Wiki      `json:"wiki"`
+	Entry      json.RawMessage `json:"entry"`
+	Redirected bool            `json:"redirected"`
+	Path       string          `json:"path"`
+}
+
+// GetEntryBySlug godoc
+// @Summary      Get an entry by slug
+// @Description  Retrieves an entry by the slug of its wiki (or the ID of the wiki) and its own slug. Old slugs left behind by renames still lead to the entry; the response tells whether one was used and the current path.
+// @Tags         Wikis
+// @Produce      application/json
+// @Param        id         path      string  true  "Wiki slug or ID"
+// @Param        entrySlug  path      string  true  "Entry slug"
+// @Success      200        {object}  EntryBySlug
+// @Failure      404        {string}  string  "Wiki not found"
+// @Failure      404        {string}  string  "Entry not found"
+// @Failure      500        {string}  string  "Internal server error"
+// @Router       /api/wikis/{id}/entries/{entrySlug} [get]
+func GetEntryBySlug(w http.ResponseWriter, r *http.Request) {
+	wikiKey := chi.URLParam(r, "id")
+	entrySlug := chi.URLParam(r, "entrySlug")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wiki, wikiRedirected, err := findWiki(ctx, wikiKey)
+	if err == mongo.ErrNoDocuments {
+		config.App.Logger.Info().Str("slug", wikiKey).Msg("Wiki not found")
+		http.Error(w, "Wiki not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	entryServiceURL := fmt.Sprintf("%s/api/entries/slug?wikiID=%s&slug=%s",
+		config.App.API_GATEWAY_URL, url.QueryEscape(wiki.ID), url.QueryEscape(entrySlug))
+	req, err := http.NewRequest("GET", entryServiceURL, nil)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to create request to entry service")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	req.Header.Set("X-Internal-Auth", config.App.JWTSecret)
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to send request to entry service")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		config.App.Logger.Info().Str("wikiID", wiki.ID).Str("slug", entrySlug).Msg("Entry not found")
+		http.Error(w, "Entry not found", http.StatusNotFound)
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		config.App.Logger.Error().Int("status", resp.StatusCode).Str("body", string(bodyBytes)).Msg("Entry service returned error")
+		http.Error(w, "Failed to retrieve entry information", http.StatusInternalServerError)
+		return
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode entry response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	var entry struct {
+		ID         string `json:"id"`
+		Slug       string `json:"slug"`
+		Redirected bool   `json:"redirected"`
+	}
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode entry response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// wikis and entries without slug yet are addressed by ID
+	wikiPath, entryPath := wiki.Slug, entry.Slug
+	if wikiPath == "" {
+		wikiPath = wiki.ID
+	}
+	if entryPath == "" {
+		entryPath = entry.ID
+	}
+
+	response := EntryBySlug{
+		Wiki:       *wiki,
+		Entry:      raw,
+		Redirected: wikiRedirected || entry.Redirected,
+		Path:       fmt.Sprintf("/api/wikis/%s/entries/%s", url.PathEscape(wikiPath), url.PathEscape(entryPath)),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// SlugBackfillReport is the result of giving slugs to the wikis created before them
+type SlugBackfillReport struct {
+	Wikis int      `json:"wikis"`
+	Slugs []string `json:"slugs"`
+}
+
+// BackfillWikiSlugs godoc
+// @Summary      Give slugs to the wikis without one
+// @Description  One-off migration that generates the slug of the wikis created before slugs. Only admins can run it.
+// @Tags         Wikis
+// @Produce      application/json
+// @Success      200  {object}  SlugBackfillReport
+// @Failure      403  {string}  string  "Forbidden"
+// @Failure      500  {string}  string  "Internal server error"
+// @Router       /api/wikis/slugs [post]
+func BackfillWikiSlugs(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Internal-Auth") != config.App.JWTSecret && r.Header.Get("X-User-Role") != "admin" {
+		config.App.Logger.Warn().Str("userID", r.Header.Get("X-User-ID")).Msg("Slug backfill without privileges")
+		http.Error(w, "Forbidden: insufficient privileges", http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	cursor, err := database.WikiCollection.Find(ctx, bson.M{"slug": bson.M{"$in": bson.A{"", nil}}})
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var wikis []model.Wiki
+	if err := cursor.All(ctx, &wikis); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode wikis")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	report := SlugBackfillReport{Slugs: []string{}}
+	for _, wiki := range wikis {
+		objID, err := primitive.ObjectIDFromHex(wiki.ID)
+		if err != nil {
+			continue
+		}
+		slug, err := wikiSlug(ctx, wiki.Title, objID)
+		if err == nil {
+			_, err = database.WikiCollection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"slug": slug}})
+		}
+		if err != nil {
+			config.App.Logger.Error().Err(err).Str("wikiID", wiki.ID).Msg("Failed to set the slug of the wiki")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		report.Wikis++
+		report.Slugs = append(report.Slugs, slug)
+	}
+
+	config.App.Logger.Info().Int("wikis", report.Wikis).Msg("Wiki slugs backfilled")
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -17,6 +18,7 @@ import (
 	"github.com/laWiki/wiki/model"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // HealthCheck godoc
@@ -221,13 +223,14 @@ func SearchWikis(w http.ResponseWriter, r *http.Request) {
 
 // PostWiki godoc
 // @Summary      Create a new wiki
-// @Description  Creates a new wiki. Expects a JSON object in the request body.
+// @Description  Creates a new wiki. Expects a JSON object in the request body. Only admins can set the review settings.
 // @Tags         Wikis
 // @Accept       application/json
 // @Produce      application/json
 // @Param        wiki  body      model.Wiki  true  "Wiki information"
 // @Success      201   {object}  model.Wiki
 // @Failure      400   {string}  string  "Invalid request body"
+// @Failure      403   {string}  string  "Forbidden"
 // @Failure      500   {string}  string  "Internal server error"
 // @Router       /api/wikis/ [post]
 func PostWiki(w http.ResponseWriter, r *http.Request) {
@@ -239,11 +242,45 @@ func PostWiki(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the review workflow and its moderators are only set by admins
+	if (len(wiki.RequireReview) > 0 || len(wiki.Moderators) > 0) && !privileged(r) {
+		config.App.Logger.Warn().Str("userID", r.Header.Get("X-User-ID")).Msg("Review settings set without privileges")
+		http.Error(w, "Forbidden: insufficient privileges", http.StatusForbidden)
+		return
+	}
+
+	if p := wiki.Retention; p != nil && !p.Valid() {
+		config.App.Logger.Error().Interface("retention", p).Msg("Invalid retention policy")
+		http.Error(w, "Invalid retention policy", http.StatusBadRequest)
+		return
+	}
+
+	if p := wiki.ContentPolicy; p != nil && !p.Valid() {
+		config.App.Logger.Error().Interface("contentPolicy", p).Msg("Invalid content policy")
+		http.Error(w, "Invalid content policy", http.StatusBadRequest)
+		return
+	}
+
+	if !model.ValidSchema(wiki.InfoboxSchema) {
+		config.App.Logger.Error().Interface("infoboxSchema", wiki.InfoboxSchema).Msg("Invalid infobox schema")
+		http.Error(w, "Invalid infobox schema", http.StatusBadRequest)
+		return
+	}
+
 	wiki.CreatedAt = time.Now().UTC()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	slug, err := wikiSlug(ctx, wiki.Title, primitive.NilObjectID)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	wiki.Slug = slug
+	wiki.OldSlugs = nil
+
 	result, err := database.WikiCollection.InsertOne(ctx, wiki)
 	if err != nil {
 		config.App.Logger.Error().Err(err).Msg("Database error")
@@ -273,7 +310,7 @@ func PostWiki(w http.ResponseWriter, r *http.Request) {
 
 // PutWiki godoc
 // @Summary      Update a wiki by ID
-// @Description  Updates a wiki by its ID. Expects a JSON object in the request.
+// @Description  Updates a wiki by its ID. Expects a JSON object in the request. Renaming a wiki changes its slug; the old slug keeps leading to it. The review settings, the retention and content policies and the infobox schema left out of the body keep their value; only admins can change the review settings.
 // @Tags         Wikis
 // @Accept       application/json
 // @Produce      application/json
@@ -281,6 +318,7 @@ func PostWiki(w http.ResponseWriter, r *http.Request) {
 // @Param        wiki  body      model.Wiki  true  "Updated wiki information"
 // @Success      200   {object}  model.Wiki
 // @Failure      400   {string}  string  "Invalid ID or request body"
+// @Failure      403   {string}  string  "Forbidden"
 // @Failure      404   {string}  string  "Wiki not found"
 // @Failure      500   {string}  string  "Internal server error"
 // @Router       /api/wikis/{id} [put]
@@ -294,27 +332,105 @@ func PutWiki(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to read provided request body")
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
 	var wiki model.Wiki
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&wiki); err != nil {
+	if err := json.Unmarshal(body, &wiki); err != nil {
 		config.App.Logger.Error().Err(err).Msg("Failed to decode provided request body")
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	// the settings left out of the body keep their value
+	var given map[string]json.RawMessage
+	if err := json.Unmarshal(body, &given); err != nil {
+		config.App.Logger.Error().Err(err).Msg("Failed to decode provided request body")
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if p := wiki.Retention; p != nil && !p.Valid() {
+		config.App.Logger.Error().Interface("retention", p).Msg("Invalid retention policy")
+		http.Error(w, "Invalid retention policy", http.StatusBadRequest)
+		return
+	}
+
+	if p := wiki.ContentPolicy; p != nil && !p.Valid() {
+		config.App.Logger.Error().Interface("contentPolicy", p).Msg("Invalid content policy")
+		http.Error(w, "Invalid content policy", http.StatusBadRequest)
+		return
+	}
+
+	if !model.ValidSchema(wiki.InfoboxSchema) {
+		config.App.Logger.Error().Interface("infoboxSchema", wiki.InfoboxSchema).Msg("Invalid infobox schema")
+		http.Error(w, "Invalid infobox schema", http.StatusBadRequest)
+		return
+	}
 
 	wiki.UpdatedAt = time.Now().UTC()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	update := bson.M{
-		"$set": bson.M{
-			"title":       wiki.Title,
-			"description": wiki.Description,
-			"category":    wiki.Category,
-			"updated_at":  wiki.UpdatedAt,
-			"media_id":    wiki.MediaID,
-		},
+	var current model.Wiki
+	if err := database.WikiCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&current); err == mongo.ErrNoDocuments {
+		config.App.Logger.Warn().Str("id", id).Msg("Wiki not found for update")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	} else if err != nil {
+		config.App.Logger.Error().Err(err).Msg("Database error")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	set := bson.M{
+		"title":       wiki.Title,
+		"description": wiki.Description,
+		"category":    wiki.Category,
+		"updated_at":  wiki.UpdatedAt,
+		"media_id":    wiki.MediaID,
+	}
+	if _, ok := given["retention"]; ok {
+		set["retention"] = wiki.Retention
+	}
+	if _, ok := given["content_policy"]; ok {
+		set["content_policy"] = wiki.ContentPolicy
+	}
+	if _, ok := given["infobox_schema"]; ok {
+		set["infobox_schema"] = wiki.InfoboxSchema
+	}
+
+	// the review workflow and its moderators are only changed by admins
+	_, reviewGiven := given["require_review"]
+	_, moderatorsGiven := given["moderators"]
+	reviewChanged := reviewGiven && !slices.Equal(wiki.RequireReview, current.RequireReview)
+	moderatorsChanged := moderatorsGiven && !slices.Equal(wiki.Moderators, current.Moderators)
+	if (reviewChanged || moderatorsChanged) && !privileged(r) {
+		config.App.Logger.Warn().Str("userID", r.Header.Get("X-User-ID")).Str("wikiID", id).Msg("Review settings changed without privileges")
+		http.Error(w, "Forbidden: insufficient privileges", http.StatusForbidden)
+		return
+	}
+	if reviewGiven {
+		set["require_review"] = wiki.RequireReview
+	}
+	if moderatorsGiven {
+		set["moderators"] = wiki.Moderators
+	}
+	update := bson.M{"$set": set}
+
+	// a renamed wiki gets a new slug, the old one keeps leading to it
+	if current.Title != wiki.Title {
+		slug, oldSlugs, err := renamedSlugs(ctx, current, wiki.Title, objID)
+		if err != nil {
+			config.App.Logger.Error().Err(err).Msg("Database error")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		update["$set"].(bson.M)["slug"] = slug
+		update["$set"].(bson.M)["old_slugs"] = oldSlugs
 	}
 
 	result, err := database.WikiCollection.UpdateOne(ctx, bson.M{"_id": objID}, update)
@@ -345,6 +461,11 @@ func PutWiki(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// privileged reports whether the request comes from another service or an admin
+func privileged(r *http.Request) bool {
+	return r.Header.Get("X-Internal-Auth") == config.App.JWTSecret || r.Header.Get("X-User-Role") == "admin"
+}
+
 // DeleteWiki godoc
 // @Summary      Delete a wiki by ID
 // @Description  Deletes a wiki by its ID.
@@ -1,10 +1,16 @@
 package model
 
-import "time"
+import (
+	"regexp"
+	"strings"
+	"time"
+)
 
 type Wiki struct {
 	ID               string                       `json:"id" bson:"_id,omitempty"`
 	Title            string                       `json:"title" bson:"title"`
+	Slug             string                       `json:"slug,omitempty" bson:"slug,omitempty"`
+	OldSlugs         []string                     `json:"old_slugs,omitempty" bson:"old_slugs,omitempty"`
 	Description      string                       `json:"description" bson:"description"`
 	Category         string                       `json:"category" bson:"category"`
 	UpdatedAt        time.Time                    `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
@@ -12,4 +18,117 @@ type Wiki struct {
 	MediaID          string                       `json:"media_id,omitempty" bson:"media_id,omitempty"`
 	TranslatedFields map[string]map[string]string `json:"translatedFields,omitempty" bson:"translatedFields,omitempty"`
 	SourceLang       string                       `json:"sourceLang,omitempty" bson:"sourceLang,omitempty"`
+	RequireReview    []string                     `json:"require_review,omitempty" bson:"require_review,omitempty"`
+	Moderators       []string                     `json:"moderators,omitempty" bson:"moderators,omitempty"`
+	Retention        *RetentionPolicy             `json:"retention,omitempty" bson:"retention,omitempty"`
+	ContentPolicy    *ContentPolicy               `json:"content_policy,omitempty" bson:"content_policy,omitempty"`
+	InfoboxSchema    []AttributeDef               `json:"infobox_schema,omitempty" bson:"infobox_schema,omitempty"`
+}
+
+// RetentionPolicy decides which old versions of the entries of a wiki are pruned. Every version of the
+// last KeepAllDays days is kept; older versions are thinned to one per IntervalDays days, and versions
+// older than MaxAgeDays (if set) are pruned. The current version of an entry is always kept.
+type RetentionPolicy struct {
+	KeepAllDays  int `json:"keep_all_days" bson:"keep_all_days"`
+	IntervalDays int `json:"interval_days" bson:"interval_days"`
+	MaxAgeDays   int `json:"max_age_days,omitempty" bson:"max_age_days,omitempty"`
+}
+
+// Valid reports whether the policy keeps some history: a policy with neither a keep window nor an interval
+// would prune every version but the current one
+func (p *RetentionPolicy) Valid() bool {
+	if p.KeepAllDays < 0 || p.IntervalDays < 0 || p.MaxAgeDays < 0 {
+		return false
+	}
+	if p.KeepAllDays == 0 && p.IntervalDays == 0 {
+		return false
+	}
+	return p.MaxAgeDays == 0 || p.MaxAgeDays >= p.KeepAllDays
+}
+
+// Content profiles, from the most to the least restrictive
+const (
+	ContentText  = "text"  // no HTML at all
+	ContentBasic = "basic" // text formatting, lists, quotes and links
+	ContentRich  = "rich"  // basic plus headings, tables and images
+)
+
+// ContentPolicy is the HTML allowed in the versions and comments of a wiki. The version and comment services
+// sanitize the content against it when it is stored. Wikis without a policy use the basic profile.
+type ContentPolicy struct {
+	Profile         string   `json:"profile" bson:"profile"`
+	ExtraTags       []string `json:"extra_tags,omitempty" bson:"extra_tags,omitempty"`
+	ExtraAttributes []string `json:"extra_attributes,omitempty" bson:"extra_attributes,omitempty"`
+}
+
+var htmlName = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// unsafeTags can run scripts or load other documents, so they can't be allowed
+var unsafeTags = map[string]bool{
+	"script": true, "style": true, "iframe": true, "frame": true, "frameset": true, "object": true,
+	"embed": true, "applet": true, "base": true, "link": true, "meta": true, "form": true,
+	"input": true, "button": true, "textarea": true, "select": true, "svg": true, "math": true,
+}
+
+// unsafeAttributes carry styles, documents or URLs, which the sanitizers only check on the elements they know
+var unsafeAttributes = map[string]bool{
+	"style": true, "srcdoc": true, "href": true, "src": true, "srcset": true, "action": true,
+	"formaction": true, "poster": true, "data": true, "background": true, "xmlns": true,
+}
+
+// Valid reports whether the policy has a known profile and only allows safe tags and attributes
+func (p *ContentPolicy) Valid() bool {
+	switch p.Profile {
+	case ContentText, ContentBasic, ContentRich:
+	default:
+		return false
+	}
+	for _, tag := range p.ExtraTags {
+		if !htmlName.MatchString(tag) || unsafeTags[tag] {
+			return false
+		}
+	}
+	for _, attr := range p.ExtraAttributes {
+		if !htmlName.MatchString(attr) || strings.HasPrefix(attr, "on") || unsafeAttributes[attr] {
+			return false
+		}
+	}
+	return true
+}
+
+// Types of the attributes of the entries
+const (
+	AttributeText   = "text"
+	AttributeNumber = "number"
+	AttributeDate   = "date"  // YYYY-MM-DD
+	AttributeEntry  = "entry" // ID of another entry of the wiki
+	AttributeMedia  = "media" // ID of a media file
+)
+
+// AttributeDef is an attribute the entries of a wiki can have. The entry service validates the attributes of
+// the entries against the schema of their wiki, and shows them in an infobox in the order of the schema.
+type AttributeDef struct {
+	Name     string `json:"name" bson:"name"`
+	Label    string `json:"label,omitempty" bson:"label,omitempty"`
+	Type     string `json:"type" bson:"type"`
+	Required bool   `json:"required,omitempty" bson:"required,omitempty"`
+}
+
+var attributeName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// ValidSchema reports whether the attributes of a schema have known types and distinct names usable in searches
+func ValidSchema(schema []AttributeDef) bool {
+	seen := map[string]bool{}
+	for _, def := range schema {
+		switch def.Type {
+		case AttributeText, AttributeNumber, AttributeDate, AttributeEntry, AttributeMedia:
+		default:
+			return false
+		}
+		if !attributeName.MatchString(def.Name) || seen[def.Name] {
+			return false
+		}
+		seen[def.Name] = true
+	}
+	return true
 }
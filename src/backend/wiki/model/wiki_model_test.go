@@ -0,0 +1,30 @@
+package model
+
+import "testing"
+
+func TestRetentionPolicyValid(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		want   bool
+	}{
+		{"keep window only", RetentionPolicy{KeepAllDays: 30}, true},
+		{"interval only", RetentionPolicy{IntervalDays: 7}, true},
+		{"all set", RetentionPolicy{KeepAllDays: 30, IntervalDays: 7, MaxAgeDays: 365}, true},
+		{"max age equal to the keep window", RetentionPolicy{KeepAllDays: 30, MaxAgeDays: 30}, true},
+		{"empty", RetentionPolicy{}, false},
+		{"max age only", RetentionPolicy{MaxAgeDays: 365}, false},
+		{"max age below the keep window", RetentionPolicy{KeepAllDays: 30, MaxAgeDays: 10}, false},
+		{"negative keep window", RetentionPolicy{KeepAllDays: -1, IntervalDays: 7}, false},
+		{"negative interval", RetentionPolicy{KeepAllDays: 30, IntervalDays: -7}, false},
+		{"negative max age", RetentionPolicy{KeepAllDays: 30, MaxAgeDays: -1}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Valid(); got != tt.want {
+				t.Errorf("Valid() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
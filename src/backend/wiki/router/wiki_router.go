@@ -15,11 +15,14 @@ func NewRouter() http.Handler {
 		r.Get("/", handler.GetWikis)
 		r.Post("/", handler.PostWiki)
 		r.Get("/search", handler.SearchWikis)
+		r.Post("/slugs", handler.BackfillWikiSlugs)
+		r.Get("/slugify", handler.GetSlug)
 		r.Route("/{id}", func(r chi.Router) {
 			r.Get("/", handler.GetWikiByID)
 			r.Put("/", handler.PutWiki)
 			r.Delete("/", handler.DeleteWiki)
 			r.Post("/translate", handler.TranslateWiki)
+			r.Get("/entries/{entrySlug}", handler.GetEntryBySlug)
 		})
 	})
 
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// transliterations are the letters that don't decompose into a base letter and accents
+var transliterations = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'ł': "l", 'đ': "d", 'þ': "th",
+}
+
+// Slugify turns a title into a URL slug: lowercase ASCII letters and digits separated by hyphens.
+// Accents are dropped, so "Año de la Peña" becomes "ano-de-la-pena".
+func Slugify(title string) string {
+	var slug strings.Builder
+	hyphen := false
+	for _, r := range norm.NFD.String(strings.ToLower(title)) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if hyphen && slug.Len() > 0 {
+				slug.WriteByte('-')
+			}
+			hyphen = false
+			slug.WriteRune(r)
+		case transliterations[r] != "":
+			if hyphen && slug.Len() > 0 {
+				slug.WriteByte('-')
+			}
+			hyphen = false
+			slug.WriteString(transliterations[r])
+		default:
+			hyphen = true
+		}
+	}
+	return slug.String()
+}
+
+// UniqueSlug returns the slug of a title, numbered with the first suffix that isn't taken.
+// Titles without letters or digits get the fallback slug.
+func UniqueSlug(title string, fallback string, taken func(slug string) (bool, error)) (string, error) {
+	base := Slugify(title)
+	if base == "" {
+		base = fallback
+	}
+	slug := base
+	for i := 2; ; i++ {
+		used, err := taken(slug)
+		if err != nil || !used {
+			return slug, err
+		}
+		slug = base + "-" + strconv.Itoa(i)
+	}
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Año de la Peña", "ano-de-la-pena"},
+		{"Crème Brûlée", "creme-brulee"},
+		{"Straße", "strasse"},
+		{"Ærø Øresund", "aero-oresund"},
+		{"Łódź", "lodz"},
+		{"Œuvre complète", "oeuvre-complete"},
+		{"Þingvellir", "thingvellir"},
+		{"  Hello,   World!  ", "hello-world"},
+		{"C++ & Go 1.23", "c-go-1-23"},
+		{"Ya-slugificado", "ya-slugificado"},
+		{"東京", ""},
+		{"!!!", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			if got := Slugify(tt.title); got != tt.want {
+				t.Errorf("Slugify(%q) = %q, want %q", tt.title, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUniqueSlug(t *testing.T) {
+	errLookup := errors.New("lookup failed")
+
+	tests := []struct {
+		name    string
+		title   string
+		taken   []string
+		err     error
+		want    string
+		wantErr bool
+	}{
+		{name: "free", title: "Año", want: "ano"},
+		{name: "taken", title: "Año", taken: []string{"ano"}, want: "ano-2"},
+		{name: "numbered taken", title: "Año", taken: []string{"ano", "ano-2", "ano-3"}, want: "ano-4"},
+		{name: "fallback", title: "東京", want: "wiki"},
+		{name: "fallback taken", title: "!!!", taken: []string{"wiki"}, want: "wiki-2"},
+		{name: "lookup error", title: "Año", err: errLookup, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := UniqueSlug(tt.title, "wiki", func(slug string) (bool, error) {
+				if tt.err != nil {
+					return false, tt.err
+				}
+				for _, s := range tt.taken {
+					if s == slug {
+						return true, nil
+					}
+				}
+				return false, nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UniqueSlug() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("UniqueSlug() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}